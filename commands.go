@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sword-challenge/internal/config"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the migrations when enabled and serve the API", serve},
+	{"migrate", "migrate up|down [steps]|status|force <version>", migrateCommand},
+	{"seed", "create the default roles and users", seed},
+	{"create-user", "create-user --username <name> --role <technician|manager>", createUser},
+	{"rotate-keys", "re-encrypt with AES_KEY every task, comment and attachment still encrypted with PREVIOUS_AES_KEY", rotateKeys},
+	{"purge-tokens", "delete login tokens older than --older-than, the token TTL by default", purgeTokens},
+	{"import-tasks", "import-tasks --file <tasks.csv|tasks.jsonl> --as <manager> [--dry-run]", importTasks},
+	{"rebuild-search-index", "recompute the search tokens of every task, needed after SEARCH_KEY changes", rebuildSearchIndex},
+	{"run-schedules", "create the tasks of the recurring schedules up to --recurring-horizon ahead", runSchedules},
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-20s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun %s <command> --help to see the flags of each command\n", os.Args[0])
+}
+
+func serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	printConfig := fs.Bool("print-config", false, "print the configuration with secrets redacted and exit")
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	if *printConfig {
+		out, err := config.Print(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Print(out)
+		return nil
+	}
+
+	a, err := newApp(cfg, true)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	a.server.SetupRoutes()
+	if a.cfg.Migrations.Enabled {
+		if err := a.server.RunMigrations(); err != nil {
+			return fmt.Errorf("failed to run migration on the database: %w", err)
+		}
+	}
+	return a.server.StartWithGracefulShutdown(context.Background(), a.cfg.Server.Port)
+}
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate needs an action: up, down, status or force")
+	}
+	action, args := args[0], args[1:]
+
+	// Positional arguments come before the flags
+	var positional []string
+	for len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		positional, args = append(positional, args[0]), args[1:]
+	}
+
+	cfg, err := loadConfig(flag.NewFlagSet("migrate "+action, flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	switch action {
+	case "up":
+		if err := a.server.RunMigrations(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(positional) > 0 {
+			if steps, err = strconv.Atoi(positional[0]); err != nil || steps < 1 {
+				return fmt.Errorf("steps must be a positive number, got %s", positional[0])
+			}
+		}
+		if err := a.server.RollbackMigrations(steps); err != nil {
+			return err
+		}
+	case "status":
+	case "force":
+		if len(positional) == 0 {
+			return fmt.Errorf("migrate force needs the version to force")
+		}
+		version, err := strconv.Atoi(positional[0])
+		if err != nil {
+			return fmt.Errorf("version must be a number, got %s", positional[0])
+		}
+		if err := a.server.ForceMigrationVersion(version); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown migrate action %s", action)
+	}
+
+	version, dirty, err := a.server.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Database is at version %d (dirty: %t)\n", version, dirty)
+	return nil
+}
+
+func seed(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("seed", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if err := a.server.Seed(); err != nil {
+		return err
+	}
+	fmt.Println("Default roles and users created")
+	return nil
+}
+
+func createUser(args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ExitOnError)
+	username := fs.String("username", "", "username of the new user")
+	role := fs.String("role", "technician", "role of the new user")
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *username == "" {
+		return fmt.Errorf("--username is required")
+	}
+	u, err := a.server.CreateUser(*username, *role)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created user %s with ID %d and role %s\n", u.Username, u.ID, u.Role.Name)
+	return nil
+}
+
+func rotateKeys(args []string) error {
+	fs := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	rotated, err := a.server.RotateEncryptionKey(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Re-encrypted %d rows, PREVIOUS_AES_KEY can be removed from every server now\n", rotated)
+	return nil
+}
+
+func purgeTokens(args []string) error {
+	fs := flag.NewFlagSet("purge-tokens", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", 0, "delete tokens older than this, defaults to the token TTL")
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	if *olderThan == 0 {
+		*olderThan = a.cfg.Auth.TokenTTL
+	}
+	purged, err := a.server.PurgeTokens(*olderThan)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d tokens\n", purged)
+	return nil
+}
+
+func importTasks(args []string) error {
+	fs := flag.NewFlagSet("import-tasks", flag.ExitOnError)
+	file := fs.String("file", "", "CSV or JSON lines file with the tasks to import")
+	format := fs.String("format", "", "csv or jsonl, guessed from the file extension by default")
+	as := fs.String("as", "", "username of the manager the tasks are created by")
+	dryRun := fs.Bool("dry-run", false, "only validate the rows")
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *file == "" || *as == "" {
+		return fmt.Errorf("--file and --as are required")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(*file), ".")
+	}
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	result, err := a.server.ImportTasks(context.Background(), f, *format, *as, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		if e.Field != "" {
+			fmt.Printf("line %d: %s %s\n", e.Line, e.Field, e.Message)
+		} else {
+			fmt.Printf("line %d: %s\n", e.Line, e.Message)
+		}
+	}
+	switch {
+	case len(result.Errors) > 0:
+		return fmt.Errorf("found %d errors in %d rows, no task was imported", len(result.Errors), result.Rows)
+	case result.DryRun:
+		fmt.Printf("All %d rows are valid\n", result.Rows)
+	default:
+		fmt.Printf("Imported %d tasks\n", result.Imported)
+	}
+	return nil
+}
+
+func rebuildSearchIndex(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("rebuild-search-index", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	rebuilt, err := a.server.RebuildSearchIndex(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rebuilt the search index of %d tasks\n", rebuilt)
+	return nil
+}
+
+func runSchedules(args []string) error {
+	cfg, err := loadConfig(flag.NewFlagSet("run-schedules", flag.ExitOnError), args)
+	if err != nil {
+		return err
+	}
+	if cfg.Scheduler.RecurringHorizon <= 0 {
+		return fmt.Errorf("--recurring-horizon must be positive")
+	}
+	a, err := newApp(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+
+	created, err := a.server.CreateScheduledTasks(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %d scheduled tasks\n", created)
+	return nil
+}
@@ -0,0 +1,15 @@
+ALTER TABLE tasks
+    ADD COLUMN status VARCHAR(32) NOT NULL DEFAULT 'todo';
+
+UPDATE tasks SET status = 'done' WHERE completed_date IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS task_transitions
+(
+    id           BIGINT      NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    task_id      BIGINT      NOT NULL REFERENCES tasks,
+    from_status  VARCHAR(32) NOT NULL,
+    to_status    VARCHAR(32) NOT NULL,
+    user_id      BIGINT      NOT NULL REFERENCES users,
+    created_date TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX task_transitions_task_id (task_id)
+);
@@ -0,0 +1,15 @@
+DROP INDEX tasks_user_id ON tasks;
+# Tasks in the unassigned pool go back to whoever created them, or to the first manager when nobody is recorded
+SET @manager_id = (SELECT u.id
+                   FROM users u
+                            JOIN roles r ON u.role_id = r.id
+                   WHERE r.name = 'manager'
+                   ORDER BY u.id
+                   LIMIT 1);
+UPDATE tasks SET user_id = COALESCE(created_by, @manager_id) WHERE user_id IS NULL;
+# Without any manager they can't be given to anyone, user_id can't be NULL anymore so they are deleted
+DELETE FROM task_transitions WHERE task_id IN (SELECT id FROM tasks WHERE user_id IS NULL);
+DELETE FROM tasks WHERE user_id IS NULL;
+ALTER TABLE tasks
+    DROP COLUMN created_by,
+    MODIFY COLUMN user_id BIGINT NOT NULL;
@@ -0,0 +1,8 @@
+# user_id is the assignee, tasks without one are in the unassigned pool
+ALTER TABLE tasks
+    MODIFY COLUMN user_id BIGINT NULL,
+    ADD COLUMN created_by BIGINT NULL REFERENCES users;
+
+UPDATE tasks SET created_by = user_id;
+
+CREATE INDEX tasks_user_id ON tasks (user_id);
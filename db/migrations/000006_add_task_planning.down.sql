@@ -0,0 +1,6 @@
+DROP INDEX tasks_due_date ON tasks;
+ALTER TABLE tasks
+    DROP COLUMN due_date,
+    DROP COLUMN priority,
+    DROP COLUMN estimated_minutes,
+    DROP COLUMN overdue_notified_at;
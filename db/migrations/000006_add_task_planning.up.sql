@@ -0,0 +1,9 @@
+ALTER TABLE tasks
+    ADD COLUMN due_date            TIMESTAMP NULL,
+    # ENUM columns sort by the position of the value so ordering by priority goes from low to urgent
+    ADD COLUMN priority            ENUM ('low', 'normal', 'high', 'urgent') NOT NULL DEFAULT 'normal',
+    ADD COLUMN estimated_minutes   INT NULL,
+    # Set once the overdue notifications were sent, cleared when the due date changes
+    ADD COLUMN overdue_notified_at TIMESTAMP NULL;
+
+CREATE INDEX tasks_due_date ON tasks (due_date);
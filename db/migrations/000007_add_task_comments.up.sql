@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS task_comments
+(
+    id           BIGINT           NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    task_id      BIGINT           NOT NULL REFERENCES tasks,
+    user_id      BIGINT           NOT NULL REFERENCES users,
+    body         VARBINARY(10012) NOT NULL, # 2500*(max char size in UTF-8) + IV
+    created_date TIMESTAMP        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_date TIMESTAMP        NULL,
+    INDEX task_comments_task_id (task_id)
+);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS task_attachments
+(
+    id           BIGINT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    task_id      BIGINT          NOT NULL REFERENCES tasks,
+    user_id      BIGINT          NOT NULL REFERENCES users,
+    filename     VARBINARY(1048) NOT NULL, # 255*(max char size in UTF-8) + IV + tag
+    content_type VARCHAR(255)    NOT NULL,
+    size         BIGINT          NOT NULL,
+    # Key of the encrypted file in the blob storage
+    storage_key  VARCHAR(255)    NOT NULL,
+    created_date TIMESTAMP       NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX task_attachments_task_id (task_id)
+);
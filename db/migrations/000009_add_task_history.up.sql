@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS task_history
+(
+    id           BIGINT           NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    task_id      BIGINT           NOT NULL, # not a reference, the history is kept after the task is deleted
+    user_id      BIGINT           NOT NULL REFERENCES users,
+    action       VARCHAR(32)      NOT NULL,
+    field        VARCHAR(32)      NOT NULL,
+    old_value    VARBINARY(10012) NULL, # summaries are encrypted, see task_comments.body
+    new_value    VARBINARY(10012) NULL,
+    created_date TIMESTAMP        NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX task_history_task_id (task_id)
+);
@@ -0,0 +1,4 @@
+DELETE FROM tasks WHERE deleted_at IS NOT NULL;
+DROP INDEX tasks_deleted_at ON tasks;
+ALTER TABLE tasks
+    DROP COLUMN deleted_at;
@@ -0,0 +1,5 @@
+ALTER TABLE tasks
+    # Deleted tasks stay in the trash until they are restored or purged
+    ADD COLUMN deleted_at TIMESTAMP NULL;
+
+CREATE INDEX tasks_deleted_at ON tasks (deleted_at);
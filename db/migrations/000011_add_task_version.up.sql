@@ -0,0 +1,3 @@
+ALTER TABLE tasks
+    # Incremented on every change, it's the ETag of the task
+    ADD COLUMN version INT UNSIGNED NOT NULL DEFAULT 1;
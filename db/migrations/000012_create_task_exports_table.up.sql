@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS task_exports
+(
+    id            BIGINT       NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id       BIGINT       NOT NULL REFERENCES users,
+    format        VARCHAR(8)   NOT NULL,
+    filters       JSON         NOT NULL, # the query of the export, technicians can only export their own tasks
+    row_count     INT UNSIGNED NULL,     # set when the export ends, interrupted exports have the rows sent until then
+    created_date  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_date TIMESTAMP    NULL,
+    INDEX task_exports_user_id (user_id)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS task_search_tokens
+(
+    task_id BIGINT     NOT NULL REFERENCES tasks,
+    token   BINARY(32) NOT NULL, # HMAC-SHA256 of a word of the summary keyed with crypto.searchKey, never the word
+    PRIMARY KEY (token, task_id),
+    INDEX task_search_tokens_task_id (task_id)
+);
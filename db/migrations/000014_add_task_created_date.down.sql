@@ -0,0 +1,3 @@
+DROP INDEX tasks_status_completed_date ON tasks;
+ALTER TABLE tasks
+    DROP COLUMN created_date;
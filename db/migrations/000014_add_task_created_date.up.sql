@@ -0,0 +1,11 @@
+ALTER TABLE tasks
+    # Tasks created before the history was kept have no creation date, they are left out of the completion times
+    ADD COLUMN created_date TIMESTAMP NULL;
+
+UPDATE tasks t SET created_date = (SELECT MIN(h.created_date) FROM task_history h WHERE h.task_id = t.id);
+
+ALTER TABLE tasks
+    MODIFY COLUMN created_date TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP;
+
+# Completions are counted by status and completion date in the reports
+CREATE INDEX tasks_status_completed_date ON tasks (status, completed_date);
@@ -0,0 +1,5 @@
+DROP INDEX tasks_schedule_occurrence ON tasks;
+ALTER TABLE tasks
+    DROP COLUMN schedule_id,
+    DROP COLUMN scheduled_for;
+DROP TABLE IF EXISTS task_schedules;
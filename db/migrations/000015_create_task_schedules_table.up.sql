@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS task_schedules
+(
+    id                BIGINT                                  NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    summary           VARBINARY(10012)                        NOT NULL, # encrypted like tasks.summary
+    cron              VARCHAR(255)                            NOT NULL,
+    timezone          VARCHAR(64)                             NOT NULL DEFAULT 'UTC',
+    user_id           BIGINT                                  NULL REFERENCES users, # tasks without an assignee go to the pool
+    created_by        BIGINT                                  NOT NULL REFERENCES users,
+    priority          ENUM ('low', 'normal', 'high', 'urgent') NOT NULL DEFAULT 'normal',
+    estimated_minutes INT                                     NULL,
+    paused            BOOLEAN                                 NOT NULL DEFAULT FALSE,
+    # Next occurrence without a task and the last one with a task
+    next_run          TIMESTAMP                               NOT NULL,
+    last_run          TIMESTAMP                               NULL,
+    created_date      TIMESTAMP                               NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX task_schedules_next_run (paused, next_run)
+);
+
+ALTER TABLE tasks
+    # Set for the tasks created for an occurrence of a schedule, an occurrence never has more than one task
+    ADD COLUMN schedule_id   BIGINT    NULL REFERENCES task_schedules,
+    ADD COLUMN scheduled_for TIMESTAMP NULL;
+
+CREATE UNIQUE INDEX tasks_schedule_occurrence ON tasks (schedule_id, scheduled_for);
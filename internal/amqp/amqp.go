@@ -2,78 +2,167 @@ package amqp
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"go.uber.org/zap"
-	"sword-challenge/internal/task"
-	"sync"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/tracing"
 	"time"
 )
 
-type Service struct {
-	logger                  *zap.SugaredLogger
-	rabbitChannel           *amqp.Channel
-	rabbitQueue             *amqp.Queue
-	consumerTag             string
-	gracefulShutdownChannel chan error
+// Bus is the RabbitMQ implementation of eventbus.EventBus. Topics are durable queues and messages are published to the
+// exchange using the topic as routing key, when no exchange is set the default one is used
+type Bus struct {
+	logger        *zap.SugaredLogger
+	rabbitChannel *amqp.Channel
+	exchange      string
+	config        eventbus.ConsumerConfig
 }
 
-func NewService(rabbitChannel *amqp.Channel, logger *zap.SugaredLogger, queueName string) (*Service, error) {
-	s := &Service{rabbitChannel: rabbitChannel, logger: logger}
-	queue, err := rabbitChannel.QueueDeclare(queueName, true, false, false, false, nil)
-	s.consumerTag = "sword-challenge-server-" + uuid.New().String()
+// NewBus declares the exchange and the queues for the topics passed so nothing published before a consumer starts is lost
+func NewBus(rabbitChannel *amqp.Channel, logger *zap.SugaredLogger, config eventbus.ConsumerConfig, exchange string, topics ...string) (*Bus, error) {
+	if config.Workers < 1 {
+		return nil, fmt.Errorf("consumer needs at least one worker, got %d", config.Workers)
+	}
+
+	b := &Bus{rabbitChannel: rabbitChannel, logger: logger, exchange: exchange, config: config}
+	if exchange != "" {
+		if err := rabbitChannel.ExchangeDeclare(exchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+			b.logger.Errorw("Failed to declare RabbitMQ exchange", "exchange", exchange, "error", err)
+			return nil, err
+		}
+	}
+	for _, topic := range topics {
+		if _, err := b.declare(topic); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
 
+func (b *Bus) declare(topic string) (amqp.Queue, error) {
+	queue, err := b.rabbitChannel.QueueDeclare(topic, true, false, false, false, nil)
 	if err != nil {
-		s.logger.Errorw("Failed to declare RabbitMQ queue", "error", err)
-		return nil, err
+		b.logger.Errorw("Failed to declare RabbitMQ queue", "queue", topic, "error", err)
+		return queue, err
 	}
+	// Every queue is already bound to the default exchange by name
+	if b.exchange != "" {
+		if err := b.rabbitChannel.QueueBind(queue.Name, topic, b.exchange, false, nil); err != nil {
+			b.logger.Errorw("Failed to bind RabbitMQ queue", "queue", topic, "exchange", b.exchange, "error", err)
+			return queue, err
+		}
+	}
+	return queue, nil
+}
 
-	s.rabbitQueue = &queue
-	return s, nil
+var errChannelClosed = errors.New("RabbitMQ channel is closed")
+
+func (b *Bus) Healthy() error {
+	if b.rabbitChannel.IsClosed() {
+		return errChannelClosed
+	}
+	return nil
 }
 
-func (s *Service) StartConsumer(ctx context.Context, wg *sync.WaitGroup) {
-	s.gracefulShutdownChannel = make(chan error)
-	deliveries, err := s.rabbitChannel.Consume(s.rabbitQueue.Name, s.consumerTag, true, false, false, false, nil)
+func (b *Bus) Publish(ctx context.Context, msg eventbus.Message) error {
+	msg, span := eventbus.StartPublish(ctx, "rabbitmq", msg)
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+
+	err := b.rabbitChannel.Publish(b.exchange, msg.Topic, false, false, amqp.Publishing{
+		ContentType: gin.MIMEJSON,
+		Type:        msg.Type,
+		Headers:     headers,
+		MessageId:   uuid.New().String(),
+		Timestamp:   time.Now(),
+		Body:        msg.Body,
+	})
+	metrics.MessagePublished(msg.Topic, msg.Type, err)
+	tracing.End(span, err)
 	if err != nil {
-		s.logger.Errorw("Failed to create RabbitMQ consumer", "error", err)
+		b.logger.Warnw("Failed to publish message", "queue", msg.Topic, "type", msg.Type, "error", err)
+		return err
 	}
-	go s.messageHandler(deliveries)
-	wg.Add(1)
+	return nil
+}
 
-	<-ctx.Done()
-	if err := s.rabbitChannel.Cancel(s.consumerTag, false); err != nil {
-		s.logger.Warnw("Failed to cancel RabbitMQ consumer", "error", err)
+// Subscribe consumes the topic until the context is cancelled. On shutdown the consumer is cancelled in the broker and we
+// wait for the deliveries already in flight to be handled before returning
+func (b *Bus) Subscribe(ctx context.Context, topic string, handler eventbus.Handler) error {
+	queue, err := b.declare(topic)
+	if err != nil {
+		return err
 	}
-	select {
-	case <-s.gracefulShutdownChannel:
-	case <-time.After(5 * time.Second):
-		s.logger.Warnw("RabbitMQ consumer did not shut down after 5 seconds")
+	if err := b.rabbitChannel.Qos(b.config.Prefetch, 0, false); err != nil {
+		b.logger.Errorw("Failed to set RabbitMQ consumer QoS", "error", err)
+		return err
 	}
 
-	if err := s.rabbitChannel.Close(); err != nil {
-		s.logger.Warnw("Failed to close RabbitMQ connection", "error", err)
+	consumerTag := "sword-challenge-server-" + uuid.New().String()
+	rabbitDeliveries, err := b.rabbitChannel.Consume(queue.Name, consumerTag, false, false, false, false, nil)
+	if err != nil {
+		b.logger.Errorw("Failed to create RabbitMQ consumer", "error", err)
+		return err
+	}
+
+	deliveries := make(chan eventbus.Delivery)
+	go func() {
+		defer close(deliveries)
+		for d := range rabbitDeliveries {
+			deliveries <- b.toDelivery(topic, d)
+		}
+	}()
+
+	drained := make(chan struct{})
+	go func() {
+		b.logger.Infow("Started RabbitMQ consumer", "consumerTag", consumerTag, "workers", b.config.Workers, "prefetch", b.config.Prefetch)
+		eventbus.Dispatch(deliveries, handler, b.config, b.logger)
+		close(drained)
+	}()
+
+	<-ctx.Done()
+	if err := b.rabbitChannel.Cancel(consumerTag, false); err != nil {
+		b.logger.Warnw("Failed to cancel RabbitMQ consumer", "error", err)
 	}
-	wg.Done()
-	s.logger.Infow("Successfully closed RabbitMQ connection")
+	<-drained
+	b.logger.Infow("Closed RabbitMQ consumer", "consumerTag", consumerTag)
+	return nil
 }
 
-func (s *Service) messageHandler(deliveries <-chan amqp.Delivery) {
-	s.logger.Infow("Started notifications consumer", "consumerTag", s.consumerTag)
-	for d := range deliveries {
-		var t task.Notification
-		err := json.Unmarshal(d.Body, &t)
-		if err != nil {
-			s.logger.Warnw("Failed to parse notification body to task", "error", err)
-		} else {
-			s.logger.Infof("%s: The tech %s performed the task %d on date %s", t.Manager, t.User.Username, t.ID, t.CompletedDate)
+func (b *Bus) toDelivery(topic string, d amqp.Delivery) eventbus.Delivery {
+	headers := map[string]string{}
+	for k, v := range d.Headers {
+		if s, ok := v.(string); ok {
+			headers[k] = s
 		}
+	}
 
+	msg := eventbus.Message{Topic: topic, Type: d.Type, Headers: headers, Body: d.Body, PublishedAt: d.Timestamp}
+	return eventbus.Delivery{Message: msg, Settle: func(err error) { b.settle(d, err) }}
+}
+
+func (b *Bus) settle(d amqp.Delivery, err error) {
+	switch {
+	case err == nil:
 		if err := d.Ack(false); err != nil {
-			s.logger.Warnw("Failed to acknowledge message", "messageId", d.MessageId, "consumerTag", s.consumerTag)
+			b.logger.Warnw("Failed to acknowledge message", "messageId", d.MessageId, "consumerTag", d.ConsumerTag)
+		}
+	case errors.Is(err, eventbus.ErrMalformedMessage):
+		// Requeueing a message we can't parse would only make us receive it again
+		if err := d.Reject(false); err != nil {
+			b.logger.Warnw("Failed to reject message", "messageId", d.MessageId, "consumerTag", d.ConsumerTag)
+		}
+	default:
+		// Give the message a second chance on another consumer, but only once so a message that always times out doesn't loop forever
+		if err := d.Nack(false, !d.Redelivered); err != nil {
+			b.logger.Warnw("Failed to negatively acknowledge message", "messageId", d.MessageId, "consumerTag", d.ConsumerTag)
 		}
 	}
-	s.gracefulShutdownChannel <- nil
-	s.logger.Infow("Closed RabbitMQ consumer", "consumerTag", s.consumerTag)
 }
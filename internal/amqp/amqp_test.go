@@ -1,14 +1,23 @@
 package amqp
 
 import (
+	"context"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"sword-challenge/internal/eventbus"
 	"testing"
 )
 
 func TestFailsToCreateService(t *testing.T) {
 	assert.Panics(t, func() {
-		_, _ = NewService(&amqp.Channel{}, zap.NewNop().Sugar(), " ")
+		_, _ = NewBus(&amqp.Channel{}, zap.NewNop().Sugar(), eventbus.DefaultConsumerConfig(), "", " ")
+	})
+}
+
+func TestFailsToPublishMessage(t *testing.T) {
+	b := Bus{logger: zap.NewNop().Sugar(), rabbitChannel: &amqp.Channel{}}
+	assert.Panics(t, func() {
+		_ = b.Publish(context.Background(), eventbus.Message{Topic: " "})
 	})
 }
@@ -0,0 +1,63 @@
+package amqp
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"sword-challenge/internal/eventbus"
+	"sync"
+	"testing"
+)
+
+type fakeAcknowledger struct {
+	mu       sync.Mutex
+	acked    []uint64
+	nacked   []uint64
+	requeued []bool
+	rejected []uint64
+}
+
+func (f *fakeAcknowledger) Ack(tag uint64, _ bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.acked = append(f.acked, tag)
+	return nil
+}
+
+func (f *fakeAcknowledger) Nack(tag uint64, _ bool, requeue bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nacked = append(f.nacked, tag)
+	f.requeued = append(f.requeued, requeue)
+	return nil
+}
+
+func (f *fakeAcknowledger) Reject(tag uint64, _ bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejected = append(f.rejected, tag)
+	return nil
+}
+
+func TestDeliveriesAreSettledAccordingToTheHandlerResult(t *testing.T) {
+	b := &Bus{logger: zap.NewNop().Sugar()}
+	ack := &fakeAcknowledger{}
+
+	b.toDelivery("tasks", amqp.Delivery{Acknowledger: ack, DeliveryTag: 1}).Settle(nil)
+	b.toDelivery("tasks", amqp.Delivery{Acknowledger: ack, DeliveryTag: 2}).Settle(eventbus.ErrMalformedMessage)
+	b.toDelivery("tasks", amqp.Delivery{Acknowledger: ack, DeliveryTag: 3}).Settle(context.DeadlineExceeded)
+	b.toDelivery("tasks", amqp.Delivery{Acknowledger: ack, DeliveryTag: 4, Redelivered: true}).Settle(context.DeadlineExceeded)
+
+	assert.Equal(t, []uint64{1}, ack.acked)
+	assert.Equal(t, []uint64{2}, ack.rejected)
+	assert.Equal(t, []uint64{3, 4}, ack.nacked)
+	assert.Equal(t, []bool{true, false}, ack.requeued)
+}
+
+func TestDeliveryIsConvertedToMessage(t *testing.T) {
+	b := &Bus{logger: zap.NewNop().Sugar()}
+	d := b.toDelivery("tasks", amqp.Delivery{Type: "task.completed", Body: []byte("{}"), Headers: amqp.Table{"traceparent": "abc", "retries": 1}})
+
+	assert.Equal(t, eventbus.Message{Topic: "tasks", Type: "task.completed", Body: []byte("{}"), Headers: map[string]string{"traceparent": "abc"}}, d.Message)
+}
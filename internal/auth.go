@@ -1,8 +1,11 @@
 package internal
 
 import (
+	"database/sql"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/util"
 )
 
@@ -12,17 +15,19 @@ func (s *SwordChallengeServer) requireAuthentication(c *gin.Context) {
 		token = c.GetHeader(util.AuthHeader)
 	}
 	if token == "" {
-		c.Status(http.StatusUnauthorized)
-		c.Abort()
+		problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthenticated, "An authentication token is required")
 		return
 	}
-	user, err := s.userService.GetUserByToken(token)
-	if err != nil {
-		s.logger.Warnw("Failed to get user from token", "error", err)
-		c.Status(http.StatusUnauthorized)
-		c.Abort()
+	user, err := s.userService.GetUserByToken(util.RequestContext(c), token)
+	if err == sql.ErrNoRows {
+		problem.Abort(c, http.StatusUnauthorized, problem.CodeUnauthenticated, "The authentication token is invalid")
+		return
+	} else if err != nil {
+		logging.FromContext(util.RequestContext(c), s.logger).Warnw("Failed to get user from token", "error", err)
+		problem.Internal(c)
 		return
 	}
 
 	c.Set(util.UserContextKey, user)
+	logging.AddFields(c, "userId", user.ID)
 }
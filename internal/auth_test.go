@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -8,12 +9,13 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"net/http/httptest"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/user"
 	"sword-challenge/internal/util"
 	"testing"
 )
 
-const expectedFetchUserByTokenSQL = "SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u INNER JOIN tokens t on u.id = t.user_id LEFT JOIN roles r on u.role_id = r.id WHERE t.uuid = .+;"
+const expectedFetchUserByTokenSQL = "SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u INNER JOIN tokens t on u.id = t.user_id LEFT JOIN roles r on u.role_id = r.id WHERE t.uuid = .+ AND t.created_date > .+;"
 
 func TestAuthMiddleware(t *testing.T) {
 	db, mock, _ := sqlmock.New()
@@ -46,13 +48,33 @@ func TestAuthMiddleware(t *testing.T) {
 		c.Request = req
 		mock.ExpectQuery(
 			expectedFetchUserByTokenSQL).
-			WithArgs(token).
-			WillReturnError(nil)
+			WithArgs(token, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}))
 
 		server.requireAuthentication(c)
 		c.Writer.Flush()
 
 		assert.Equal(t, 401, w.Code)
+		assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+		assert.Contains(t, w.Body.String(), `"code":"unauthenticated"`)
+	})
+
+	t.Run("shouldReturn500WhenTokenCantBeChecked", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/tasks/1", nil)
+		req.Header.Add(util.AuthHeader, "123")
+		c.Request = req
+		mock.ExpectQuery(
+			expectedFetchUserByTokenSQL).
+			WithArgs("123", sqlmock.AnyArg()).
+			WillReturnError(fmt.Errorf("connection refused"))
+
+		server.requireAuthentication(c)
+		c.Writer.Flush()
+
+		assert.Equal(t, 500, w.Code)
+		assert.True(t, c.IsAborted())
 	})
 
 	t.Run("shouldSetUserInContextIfTokenIsValid", func(t *testing.T) {
@@ -69,7 +91,7 @@ func TestAuthMiddleware(t *testing.T) {
 
 		mock.ExpectQuery(
 			expectedFetchUserByTokenSQL).
-			WithArgs(token).
+			WithArgs(token, sqlmock.AnyArg()).
 			WillReturnRows(rows)
 
 		server.requireAuthentication(c)
@@ -0,0 +1,30 @@
+// Package blob keeps opaque objects, such as the attachments of the tasks, in a local directory or an S3 compatible
+// bucket. Objects are written as they are received, callers encrypt them beforehand
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sword-challenge/internal/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps objects by key, keys are slash separated paths such as tasks/1/<uuid>
+type Store interface {
+	// Put streams the reader to the object, nothing is stored when reading fails
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get returns ErrNotFound when the object doesn't exist, the caller must close the reader
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete doesn't fail when the object doesn't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// New creates the store configured, no connection is made until it's used
+func New(cfg config.AttachmentsConfig) (Store, error) {
+	if cfg.Storage == config.StorageS3 {
+		return NewS3(cfg.S3)
+	}
+	return NewFilesystem(cfg.Path), nil
+}
@@ -0,0 +1,86 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sword-challenge/internal/config"
+	"testing"
+)
+
+type failingReader struct {
+	read int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read > 0 {
+		return 0, errors.New("connection reset")
+	}
+	r.read = copy(p, "partial")
+	return r.read, nil
+}
+
+// testStore checks the behaviour every store must have
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	content := bytes.Repeat([]byte("pump"), 3<<20)
+
+	assert.Nil(t, store.Put(ctx, "tasks/1/photo", bytes.NewReader(content)))
+
+	r, err := store.Get(ctx, "tasks/1/photo")
+	assert.Nil(t, err)
+	stored, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, content, stored)
+
+	assert.NotNil(t, store.Put(ctx, "tasks/1/partial", &failingReader{}))
+	_, err = store.Get(ctx, "tasks/1/partial")
+	assert.Equal(t, ErrNotFound, err)
+
+	assert.Nil(t, store.Delete(ctx, "tasks/1/photo"))
+	_, err = store.Get(ctx, "tasks/1/photo")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Nil(t, store.Delete(ctx, "tasks/1/photo"))
+}
+
+func TestFilesystem(t *testing.T) {
+	store, err := New(config.AttachmentsConfig{Storage: config.StorageFilesystem, Path: t.TempDir()})
+	assert.Nil(t, err)
+
+	testStore(t, store)
+}
+
+func TestFilesystemRefusesKeysOutsideTheRoot(t *testing.T) {
+	store := NewFilesystem(t.TempDir())
+
+	for _, key := range []string{"../secrets", "tasks/../../secrets", "/etc/passwd", "tasks\\..\\secrets", ""} {
+		assert.NotNil(t, store.Put(context.Background(), key, strings.NewReader("x")), key)
+	}
+}
+
+// TestS3 runs against an in-memory S3 stand-in, the same client works with MinIO or AWS. It's served over TLS since the
+// stand-in doesn't support the chunked uploads the client makes over plain HTTP
+func TestS3(t *testing.T) {
+	backend := s3mem.New()
+	assert.Nil(t, backend.CreateBucket("attachments"))
+	server := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	defer server.Close()
+
+	store, err := newS3(config.S3Config{
+		Endpoint:  strings.TrimPrefix(server.URL, "https://"),
+		Bucket:    "attachments",
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secret",
+	}, server.Client().Transport)
+	assert.Nil(t, err)
+
+	testStore(t, store)
+}
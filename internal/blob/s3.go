@@ -0,0 +1,59 @@
+package blob
+
+import (
+	"context"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"io"
+	"net/http"
+	"sword-challenge/internal/config"
+)
+
+// partSize is how much of an upload is buffered before it's sent, objects smaller than it are sent in a single part.
+// It's the smallest part S3 accepts
+const partSize = 5 << 20
+
+// S3 keeps the objects in a bucket of an S3 compatible service, the bucket must already exist
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(cfg config.S3Config) (*S3, error) {
+	return newS3(cfg, nil)
+}
+
+// newS3 lets tests trust the certificate of the stand-in server, a nil transport uses the default one
+func newS3(cfg config.S3Config, transport http.RoundTripper) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:    !cfg.Insecure,
+		Region:    cfg.Region,
+		Transport: transport,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{client: client, bucket: cfg.Bucket}, nil
+}
+
+// Put uploads the object in parts since its size isn't known, the upload is aborted when reading fails
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, -1, minio.PutObjectOptions{ContentType: "application/octet-stream", PartSize: partSize})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	// Objects are fetched lazily, stat first so a missing object is reported before the caller starts reading
+	if _, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,355 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"net/url"
+	"time"
+)
+
+const (
+	DriverRabbitMQ = "rabbitmq"
+	DriverMemory   = "memory"
+
+	LogFormatConsole = "console"
+	LogFormatJSON    = "json"
+
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+
+	StorageFilesystem = "filesystem"
+	StorageS3         = "s3"
+)
+
+// Config is everything the server needs to start, see Load for where each value can come from
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Messaging   MessagingConfig   `yaml:"messaging"`
+	Migrations  MigrationsConfig  `yaml:"migrations"`
+	Log         LogConfig         `yaml:"log"`
+	Crypto      CryptoConfig      `yaml:"crypto"`
+	Auth        AuthConfig        `yaml:"auth"`
+	Tracing     TracingConfig     `yaml:"tracing"`
+	Scheduler   SchedulerConfig   `yaml:"scheduler"`
+	Attachments AttachmentsConfig `yaml:"attachments"`
+}
+
+type ServerConfig struct {
+	Port             int           `yaml:"port"`
+	GinMode          string        `yaml:"ginMode"`
+	ReadinessTimeout time.Duration `yaml:"readinessTimeout"`
+	// ShutdownDelay is how long /readyz fails before the server stops accepting connections, long enough for the
+	// readiness probe to notice and stop routing traffic to it
+	ShutdownDelay time.Duration `yaml:"shutdownDelay"`
+	// ShutdownTimeout is how long the requests in flight have to finish once the server stops accepting connections
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// ValidateAPI checks requests and responses against the OpenAPI document, it buffers responses so it's meant for dev and tests
+	ValidateAPI bool `yaml:"validateAPI"`
+	// RequireIfMatch refuses PUT and DELETE of tasks without an If-Match header so clients can't overwrite changes they
+	// haven't seen
+	RequireIfMatch bool `yaml:"requireIfMatch"`
+}
+
+type DatabaseConfig struct {
+	User            string        `yaml:"user"`
+	Password        string        `yaml:"password"`
+	Host            string        `yaml:"host"`
+	Name            string        `yaml:"name"`
+	MaxOpenConns    int           `yaml:"maxOpenConns"`
+	MaxIdleConns    int           `yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `yaml:"connMaxLifetime"`
+}
+
+type MessagingConfig struct {
+	// Driver is either rabbitmq or memory, when empty we use RabbitMQ only if a URL was configured
+	Driver         string        `yaml:"driver"`
+	URL            string        `yaml:"url"`
+	Queue          string        `yaml:"queue"`
+	Exchange       string        `yaml:"exchange"`
+	Prefetch       int           `yaml:"prefetch"`
+	Workers        int           `yaml:"workers"`
+	HandlerTimeout time.Duration `yaml:"handlerTimeout"`
+	BufferSize     int           `yaml:"bufferSize"`
+}
+
+type MigrationsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level"`
+	// Format is console for humans or json for log collectors
+	Format string `yaml:"format"`
+}
+
+type CryptoConfig struct {
+	AESKey string `yaml:"aesKey"`
+	// PreviousKey is the key being rotated out, it's only used to decrypt what rotate-keys didn't re-encrypt yet
+	PreviousKey string `yaml:"previousKey"`
+	// SearchKey is the HMAC key of the search index, changing it requires rebuilding the index
+	SearchKey string `yaml:"searchKey"`
+}
+
+type AuthConfig struct {
+	// TokenTTL is how long a login token is accepted after it's issued, expired tokens stay in the database until
+	// purge-tokens deletes them
+	TokenTTL time.Duration `yaml:"tokenTTL"`
+}
+
+type TracingConfig struct {
+	// Exporter is either none or otlp, with none spans are never recorded but trace context is still propagated
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	SampleRatio float64 `yaml:"sampleRatio"`
+	ServiceName string  `yaml:"serviceName"`
+}
+
+type SchedulerConfig struct {
+	// OverdueInterval is how often overdue tasks are looked for, 0 disables the notifications
+	OverdueInterval time.Duration `yaml:"overdueInterval"`
+	// PurgeInterval is how often the trash is purged of the tasks deleted more than TrashRetention ago, 0 disables it
+	PurgeInterval  time.Duration `yaml:"purgeInterval"`
+	TrashRetention time.Duration `yaml:"trashRetention"`
+	// RecurringInterval is how often the tasks of recurring schedules are created, RecurringHorizon ahead of their
+	// occurrence. 0 disables it
+	RecurringInterval time.Duration `yaml:"recurringInterval"`
+	RecurringHorizon  time.Duration `yaml:"recurringHorizon"`
+}
+
+type AttachmentsConfig struct {
+	// MaxSize is the largest file that can be uploaded, in bytes
+	MaxSize int64 `yaml:"maxSize"`
+	// AllowedTypes are the MIME types accepted, the type is detected from the content of the file
+	AllowedTypes []string `yaml:"allowedTypes"`
+	// Storage is either filesystem or s3
+	Storage string   `yaml:"storage"`
+	Path    string   `yaml:"path"`
+	S3      S3Config `yaml:"s3"`
+}
+
+// S3Config points to an S3 compatible bucket, e.g. AWS S3 or MinIO
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"accessKey"`
+	SecretKey string `yaml:"secretKey"`
+	Insecure  bool   `yaml:"insecure"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server:     ServerConfig{Port: 8080, ReadinessTimeout: 2 * time.Second, ShutdownDelay: 10 * time.Second, ShutdownTimeout: 5 * time.Second},
+		Database:   DatabaseConfig{MaxOpenConns: 25, MaxIdleConns: 5, ConnMaxLifetime: 5 * time.Minute},
+		Messaging:  MessagingConfig{Queue: "tasks", Prefetch: 20, Workers: 4, HandlerTimeout: 10 * time.Second, BufferSize: 1000},
+		Migrations: MigrationsConfig{Enabled: true, Path: "file://db/migrations"},
+		Log:        LogConfig{Level: "debug", Format: LogFormatConsole},
+		Auth:       AuthConfig{TokenTTL: time.Hour},
+		Tracing:    TracingConfig{Exporter: ExporterNone, Endpoint: "localhost:4318", SampleRatio: 1, ServiceName: "sword-challenge"},
+		Scheduler: SchedulerConfig{OverdueInterval: time.Minute, PurgeInterval: time.Hour, TrashRetention: 30 * 24 * time.Hour,
+			RecurringInterval: 5 * time.Minute, RecurringHorizon: 7 * 24 * time.Hour},
+		Attachments: AttachmentsConfig{MaxSize: 10 << 20, AllowedTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf"},
+			Storage: StorageFilesystem, Path: "data/attachments", S3: S3Config{Region: "us-east-1"}},
+	}
+}
+
+// DSN builds the MySQL connection string from its pieces
+func (c *DatabaseConfig) DSN() string {
+	dsn := mysql.NewConfig()
+	dsn.User = c.User
+	dsn.Passwd = c.Password
+	dsn.Net = "tcp"
+	dsn.Addr = c.Host
+	dsn.DBName = c.Name
+	dsn.ParseTime = true
+	// multiStatements=true is bad (increases SQLi possibilities) but we need it because migrations can be run by the server itself
+	dsn.MultiStatements = true
+	return dsn.FormatDSN()
+}
+
+// Validate fails on the first invalid value, it also resolves the messaging driver when it wasn't set explicitly
+func (c *Config) Validate() error {
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	if c.Server.ReadinessTimeout <= 0 {
+		return fmt.Errorf("server.readinessTimeout must be positive")
+	}
+	if c.Server.ShutdownDelay < 0 {
+		return fmt.Errorf("server.shutdownDelay can't be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		return fmt.Errorf("server.shutdownTimeout must be positive")
+	}
+
+	if c.Database.User == "" || c.Database.Host == "" || c.Database.Name == "" {
+		return fmt.Errorf("database.user, database.host and database.name are required")
+	}
+	if _, _, err := net.SplitHostPort(c.Database.Host); err != nil {
+		return fmt.Errorf("database.host must be in the host:port format: %w", err)
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 {
+		return fmt.Errorf("database pool sizes can't be negative")
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		return fmt.Errorf("database.maxIdleConns (%d) can't be larger than database.maxOpenConns (%d)", c.Database.MaxIdleConns, c.Database.MaxOpenConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		return fmt.Errorf("database.connMaxLifetime can't be negative")
+	}
+
+	if c.Messaging.Driver == "" {
+		c.Messaging.Driver = DriverMemory
+		if c.Messaging.URL != "" {
+			c.Messaging.Driver = DriverRabbitMQ
+		}
+	}
+	switch c.Messaging.Driver {
+	case DriverRabbitMQ:
+		if _, err := url.Parse(c.Messaging.URL); err != nil || c.Messaging.URL == "" {
+			return fmt.Errorf("messaging.url must be a valid AMQP URL when using RabbitMQ")
+		}
+	case DriverMemory:
+	default:
+		return fmt.Errorf("messaging.driver must be %s or %s, got %q", DriverRabbitMQ, DriverMemory, c.Messaging.Driver)
+	}
+	if c.Messaging.Queue == "" {
+		return fmt.Errorf("messaging.queue is required")
+	}
+	if c.Messaging.Workers < 1 || c.Messaging.Prefetch < 0 || c.Messaging.BufferSize < 1 {
+		return fmt.Errorf("messaging.workers and messaging.bufferSize must be positive and messaging.prefetch can't be negative")
+	}
+	if c.Messaging.HandlerTimeout <= 0 {
+		return fmt.Errorf("messaging.handlerTimeout must be positive")
+	}
+
+	if c.Migrations.Enabled && c.Migrations.Path == "" {
+		return fmt.Errorf("migrations.path is required when migrations are enabled")
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		return fmt.Errorf("log.level is invalid: %w", err)
+	}
+	if c.Log.Format != LogFormatConsole && c.Log.Format != LogFormatJSON {
+		return fmt.Errorf("log.format must be %s or %s, got %q", LogFormatConsole, LogFormatJSON, c.Log.Format)
+	}
+
+	key, err := hex.DecodeString(c.Crypto.AESKey)
+	if err != nil {
+		return fmt.Errorf("crypto.aesKey must be hex encoded")
+	}
+	if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+		return fmt.Errorf("crypto.aesKey must be 16, 24 or 32 bytes long, got %d", len(key))
+	}
+	if c.Crypto.PreviousKey != "" {
+		previousKey, err := hex.DecodeString(c.Crypto.PreviousKey)
+		if err != nil {
+			return fmt.Errorf("crypto.previousKey must be hex encoded")
+		}
+		if len(previousKey) != 16 && len(previousKey) != 24 && len(previousKey) != 32 {
+			return fmt.Errorf("crypto.previousKey must be 16, 24 or 32 bytes long, got %d", len(previousKey))
+		}
+		if c.Crypto.PreviousKey == c.Crypto.AESKey {
+			return fmt.Errorf("crypto.previousKey must be different from crypto.aesKey")
+		}
+	}
+	searchKey, err := hex.DecodeString(c.Crypto.SearchKey)
+	if err != nil {
+		return fmt.Errorf("crypto.searchKey must be hex encoded")
+	}
+	if len(searchKey) < 16 {
+		return fmt.Errorf("crypto.searchKey must be at least 16 bytes long, got %d", len(searchKey))
+	}
+
+	if c.Auth.TokenTTL <= 0 {
+		return fmt.Errorf("auth.tokenTTL must be positive")
+	}
+
+	switch c.Tracing.Exporter {
+	case ExporterNone:
+	case ExporterOTLP:
+		if _, _, err := net.SplitHostPort(c.Tracing.Endpoint); err != nil {
+			return fmt.Errorf("tracing.endpoint must be in the host:port format: %w", err)
+		}
+	default:
+		return fmt.Errorf("tracing.exporter must be %s or %s, got %q", ExporterNone, ExporterOTLP, c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("tracing.sampleRatio must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.ServiceName == "" {
+		return fmt.Errorf("tracing.serviceName is required")
+	}
+
+	if c.Scheduler.OverdueInterval < 0 {
+		return fmt.Errorf("scheduler.overdueInterval can't be negative")
+	}
+	if c.Scheduler.PurgeInterval < 0 {
+		return fmt.Errorf("scheduler.purgeInterval can't be negative")
+	}
+	if c.Scheduler.PurgeInterval > 0 && c.Scheduler.TrashRetention <= 0 {
+		return fmt.Errorf("scheduler.trashRetention must be positive when the trash is purged")
+	}
+	if c.Scheduler.RecurringInterval < 0 {
+		return fmt.Errorf("scheduler.recurringInterval can't be negative")
+	}
+	if c.Scheduler.RecurringInterval > 0 && c.Scheduler.RecurringHorizon <= 0 {
+		return fmt.Errorf("scheduler.recurringHorizon must be positive when recurring tasks are created")
+	}
+
+	if c.Attachments.MaxSize <= 0 {
+		return fmt.Errorf("attachments.maxSize must be positive")
+	}
+	if len(c.Attachments.AllowedTypes) == 0 {
+		return fmt.Errorf("attachments.allowedTypes can't be empty")
+	}
+	switch c.Attachments.Storage {
+	case StorageFilesystem:
+		if c.Attachments.Path == "" {
+			return fmt.Errorf("attachments.path is required when storing attachments in the filesystem")
+		}
+	case StorageS3:
+		s3 := c.Attachments.S3
+		if s3.Endpoint == "" || s3.Bucket == "" || s3.AccessKey == "" || s3.SecretKey == "" {
+			return fmt.Errorf("attachments.s3.endpoint, attachments.s3.bucket, attachments.s3.accessKey and attachments.s3.secretKey are required when storing attachments in S3")
+		}
+	default:
+		return fmt.Errorf("attachments.storage must be %s or %s, got %q", StorageFilesystem, StorageS3, c.Attachments.Storage)
+	}
+	return nil
+}
+
+const redacted = "REDACTED"
+
+// Redacted returns a copy of the config that is safe to print
+func (c Config) Redacted() Config {
+	if c.Database.Password != "" {
+		c.Database.Password = redacted
+	}
+	if c.Crypto.AESKey != "" {
+		c.Crypto.AESKey = redacted
+	}
+	if c.Crypto.PreviousKey != "" {
+		c.Crypto.PreviousKey = redacted
+	}
+	if c.Crypto.SearchKey != "" {
+		c.Crypto.SearchKey = redacted
+	}
+	if c.Attachments.S3.SecretKey != "" {
+		c.Attachments.S3.SecretKey = redacted
+	}
+	if u, err := url.Parse(c.Messaging.URL); err == nil && u.User != nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			c.Messaging.URL = u.String()
+		}
+	}
+	return c
+}
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigFileEnv can be used instead of the --config flag to point to the configuration file
+const ConfigFileEnv = "SCS_CONFIG"
+
+// environment maps every flag to the environment variable that can also set it, the names predate this package so they
+// were kept as they were
+var environment = map[string]string{
+	"port":                   "PORT",
+	"gin-mode":               "GIN_MODE",
+	"validate-api":           "VALIDATE_API",
+	"require-if-match":       "REQUIRE_IF_MATCH",
+	"shutdown-delay":         "SHUTDOWN_DELAY",
+	"shutdown-timeout":       "SHUTDOWN_TIMEOUT",
+	"db-user":                "DB_USER",
+	"db-password":            "DB_PASSWORD",
+	"db-host":                "DB_HOST",
+	"db-name":                "DB_NAME",
+	"db-max-open-conns":      "DB_MAX_OPEN_CONNS",
+	"db-max-idle-conns":      "DB_MAX_IDLE_CONNS",
+	"db-conn-max-lifetime":   "DB_CONN_MAX_LIFETIME",
+	"messaging-driver":       "MESSAGING_DRIVER",
+	"rabbit-url":             "RABBIT_URL",
+	"rabbit-queue":           "RABBIT_QUEUE",
+	"rabbit-exchange":        "RABBIT_EXCHANGE",
+	"rabbit-prefetch":        "RABBIT_PREFETCH",
+	"rabbit-workers":         "RABBIT_WORKERS",
+	"rabbit-handler-timeout": "RABBIT_HANDLER_TIMEOUT",
+	"messaging-buffer-size":  "MESSAGING_BUFFER_SIZE",
+	"migrations":             "MIGRATIONS_ENABLED",
+	"migrations-path":        "MIGRATIONS_PATH",
+	"log-level":              "LOG_LEVEL",
+	"log-format":             "LOG_FORMAT",
+	"aes-key":                "AES_KEY",
+	"previous-aes-key":       "PREVIOUS_AES_KEY",
+	"search-key":             "SEARCH_KEY",
+	"token-ttl":              "TOKEN_TTL",
+	"tracing-exporter":       "TRACING_EXPORTER",
+	"tracing-endpoint":       "TRACING_ENDPOINT",
+	"tracing-insecure":       "TRACING_INSECURE",
+	"tracing-sample-ratio":   "TRACING_SAMPLE_RATIO",
+	"tracing-service-name":   "TRACING_SERVICE_NAME",
+	"overdue-interval":       "OVERDUE_INTERVAL",
+	"purge-interval":         "PURGE_INTERVAL",
+	"trash-retention":        "TRASH_RETENTION",
+	"recurring-interval":     "RECURRING_INTERVAL",
+	"recurring-horizon":      "RECURRING_HORIZON",
+	"attachments-max-size":   "ATTACHMENTS_MAX_SIZE",
+	"attachments-types":      "ATTACHMENTS_ALLOWED_TYPES",
+	"attachments-storage":    "ATTACHMENTS_STORAGE",
+	"attachments-path":       "ATTACHMENTS_PATH",
+	"s3-endpoint":            "S3_ENDPOINT",
+	"s3-bucket":              "S3_BUCKET",
+	"s3-region":              "S3_REGION",
+	"s3-access-key":          "S3_ACCESS_KEY",
+	"s3-secret-key":          "S3_SECRET_KEY",
+	"s3-insecure":            "S3_INSECURE",
+}
+
+// listValue is a comma separated flag
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+func register(fs *flag.FlagSet, c *Config) {
+	fs.IntVar(&c.Server.Port, "port", c.Server.Port, "HTTP port the server listens on")
+	fs.StringVar(&c.Server.GinMode, "gin-mode", c.Server.GinMode, "gin mode: debug, release or test")
+	fs.DurationVar(&c.Server.ReadinessTimeout, "readiness-timeout", c.Server.ReadinessTimeout, "how long each dependency has to answer the readiness probe")
+	fs.DurationVar(&c.Server.ShutdownDelay, "shutdown-delay", c.Server.ShutdownDelay, "how long /readyz fails before the server stops accepting connections on shutdown")
+	fs.DurationVar(&c.Server.ShutdownTimeout, "shutdown-timeout", c.Server.ShutdownTimeout, "how long the requests in flight have to finish on shutdown")
+	fs.BoolVar(&c.Server.ValidateAPI, "validate-api", c.Server.ValidateAPI, "validate requests and responses against the OpenAPI document, for development and tests")
+	fs.BoolVar(&c.Server.RequireIfMatch, "require-if-match", c.Server.RequireIfMatch, "refuse changes to tasks without the If-Match header")
+
+	fs.StringVar(&c.Database.User, "db-user", c.Database.User, "MySQL user")
+	fs.StringVar(&c.Database.Password, "db-password", c.Database.Password, "MySQL password")
+	fs.StringVar(&c.Database.Host, "db-host", c.Database.Host, "MySQL address in the host:port format")
+	fs.StringVar(&c.Database.Name, "db-name", c.Database.Name, "MySQL database name")
+	fs.IntVar(&c.Database.MaxOpenConns, "db-max-open-conns", c.Database.MaxOpenConns, "maximum number of open connections to the database, 0 is unlimited")
+	fs.IntVar(&c.Database.MaxIdleConns, "db-max-idle-conns", c.Database.MaxIdleConns, "maximum number of idle connections to the database")
+	fs.DurationVar(&c.Database.ConnMaxLifetime, "db-conn-max-lifetime", c.Database.ConnMaxLifetime, "how long a database connection may be reused, 0 is forever")
+
+	fs.StringVar(&c.Messaging.Driver, "messaging-driver", c.Messaging.Driver, "event bus implementation: rabbitmq or memory, defaults to rabbitmq when an URL is set")
+	fs.StringVar(&c.Messaging.URL, "rabbit-url", c.Messaging.URL, "RabbitMQ URL")
+	fs.StringVar(&c.Messaging.Queue, "rabbit-queue", c.Messaging.Queue, "queue task notifications are sent to")
+	fs.StringVar(&c.Messaging.Exchange, "rabbit-exchange", c.Messaging.Exchange, "exchange messages are published to, empty is the default exchange")
+	fs.IntVar(&c.Messaging.Prefetch, "rabbit-prefetch", c.Messaging.Prefetch, "how many unacknowledged messages are pushed to the consumer")
+	fs.IntVar(&c.Messaging.Workers, "rabbit-workers", c.Messaging.Workers, "how many messages are handled concurrently")
+	fs.DurationVar(&c.Messaging.HandlerTimeout, "rabbit-handler-timeout", c.Messaging.HandlerTimeout, "how long a single message may take to be handled")
+	fs.IntVar(&c.Messaging.BufferSize, "messaging-buffer-size", c.Messaging.BufferSize, "how many messages the in-memory bus keeps per topic")
+
+	fs.BoolVar(&c.Migrations.Enabled, "migrations", c.Migrations.Enabled, "run the database migrations on startup")
+	fs.StringVar(&c.Migrations.Path, "migrations-path", c.Migrations.Path, "go-migrate source URL of the migrations")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "log level: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log format: console or json")
+
+	fs.StringVar(&c.Crypto.AESKey, "aes-key", c.Crypto.AESKey, "hex encoded AES key used to encrypt task summaries")
+	fs.StringVar(&c.Crypto.PreviousKey, "previous-aes-key", c.Crypto.PreviousKey, "hex encoded AES key being rotated out, only used to decrypt")
+	fs.StringVar(&c.Crypto.SearchKey, "search-key", c.Crypto.SearchKey, "hex encoded HMAC key of the search index of task summaries")
+
+	fs.DurationVar(&c.Auth.TokenTTL, "token-ttl", c.Auth.TokenTTL, "how long a login token is valid")
+
+	fs.StringVar(&c.Tracing.Exporter, "tracing-exporter", c.Tracing.Exporter, "where spans are exported to: none or otlp")
+	fs.StringVar(&c.Tracing.Endpoint, "tracing-endpoint", c.Tracing.Endpoint, "OTLP/HTTP collector address in the host:port format")
+	fs.BoolVar(&c.Tracing.Insecure, "tracing-insecure", c.Tracing.Insecure, "export spans over plain HTTP instead of HTTPS")
+	fs.Float64Var(&c.Tracing.SampleRatio, "tracing-sample-ratio", c.Tracing.SampleRatio, "fraction of new traces that are sampled, between 0 and 1")
+	fs.StringVar(&c.Tracing.ServiceName, "tracing-service-name", c.Tracing.ServiceName, "service name reported in the spans")
+
+	fs.DurationVar(&c.Scheduler.OverdueInterval, "overdue-interval", c.Scheduler.OverdueInterval, "how often overdue tasks are looked for, 0 disables the notifications")
+	fs.DurationVar(&c.Scheduler.PurgeInterval, "purge-interval", c.Scheduler.PurgeInterval, "how often the trash is purged, 0 disables purging")
+	fs.DurationVar(&c.Scheduler.TrashRetention, "trash-retention", c.Scheduler.TrashRetention, "how long deleted tasks stay in the trash before being purged")
+	fs.DurationVar(&c.Scheduler.RecurringInterval, "recurring-interval", c.Scheduler.RecurringInterval, "how often the tasks of recurring schedules are created, 0 disables it")
+	fs.DurationVar(&c.Scheduler.RecurringHorizon, "recurring-horizon", c.Scheduler.RecurringHorizon, "how far ahead of their occurrence the tasks of recurring schedules are created")
+
+	fs.Int64Var(&c.Attachments.MaxSize, "attachments-max-size", c.Attachments.MaxSize, "largest attachment that can be uploaded, in bytes")
+	fs.Var((*listValue)(&c.Attachments.AllowedTypes), "attachments-types", "comma separated MIME types attachments can have")
+	fs.StringVar(&c.Attachments.Storage, "attachments-storage", c.Attachments.Storage, "where attachments are stored: filesystem or s3")
+	fs.StringVar(&c.Attachments.Path, "attachments-path", c.Attachments.Path, "directory attachments are stored in with the filesystem storage")
+	fs.StringVar(&c.Attachments.S3.Endpoint, "s3-endpoint", c.Attachments.S3.Endpoint, "S3 compatible endpoint in the host:port format")
+	fs.StringVar(&c.Attachments.S3.Bucket, "s3-bucket", c.Attachments.S3.Bucket, "bucket attachments are stored in")
+	fs.StringVar(&c.Attachments.S3.Region, "s3-region", c.Attachments.S3.Region, "region of the bucket")
+	fs.StringVar(&c.Attachments.S3.AccessKey, "s3-access-key", c.Attachments.S3.AccessKey, "S3 access key")
+	fs.StringVar(&c.Attachments.S3.SecretKey, "s3-secret-key", c.Attachments.S3.SecretKey, "S3 secret key")
+	fs.BoolVar(&c.Attachments.S3.Insecure, "s3-insecure", c.Attachments.S3.Insecure, "connect to the S3 endpoint over plain HTTP")
+}
+
+// Load builds the configuration from, in increasing order of precedence, the defaults, a YAML or TOML file, the
+// environment and the command line flags. The configuration flags are registered in the flag set passed so callers can
+// add their own flags to it before calling Load
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	register(fs, Default())
+	configFile := fs.String("config", os.Getenv(ConfigFileEnv), "path to a YAML or TOML configuration file")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if *configFile != "" {
+		if err := loadFile(*configFile, c); err != nil {
+			return nil, err
+		}
+	}
+
+	// Flags and environment variables are applied through a flag set bound to the final config so they are parsed the same way
+	overrides := flag.NewFlagSet("overrides", flag.ContinueOnError)
+	register(overrides, c)
+	for name, env := range environment {
+		if value, ok := os.LookupEnv(env); ok && value != "" {
+			if err := overrides.Set(name, value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if overrides.Lookup(f.Name) != nil && err == nil {
+			err = overrides.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFile decodes the file on top of the config passed. TOML is converted to YAML first so both formats share the
+// same field names and duration parsing
+func loadFile(path string, c *Config) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+	case ".toml":
+		var values map[string]interface{}
+		if err := toml.Unmarshal(content, &values); err != nil {
+			return fmt.Errorf("failed to parse TOML config file: %w", err)
+		}
+		if content, err = yaml.Marshal(values); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("config file must be .yaml, .yml or .toml, got %s", path)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return nil
+}
+
+// Print writes the config as YAML with every secret redacted
+func Print(c *Config) (string, error) {
+	out, err := yaml.Marshal(c.Redacted())
+	return string(out), err
+}
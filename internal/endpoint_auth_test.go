@@ -11,7 +11,6 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
-	serverAmqp "sword-challenge/internal/amqp"
 	"sword-challenge/internal/task"
 	"sword-challenge/internal/user"
 	"sword-challenge/internal/util"
@@ -34,10 +33,10 @@ func TestAuth(t *testing.T) {
 	router := gin.New()
 	sqlxDB := sqlx.NewDb(db, "mysql")
 
-	pub := &serverAmqp.Publisher{Logger: logger.Sugar(), NotificationsQueue: "tasks"}
+	pub := &task.LogPublisher{Logger: logger.Sugar()}
 	userService := user.NewService(sqlxDB, logger.Sugar())
 
-	tasksService := task.NewService(userService, sqlxDB, pub, logger.Sugar(), "6368616e676520746869732070617373")
+	tasksService := task.NewService(userService, sqlxDB, pub, "tasks", logger.Sugar(), "6368616e676520746869732070617373", "", "7365617263682074686520696e646578")
 
 	server := &SwordChallengeServer{
 		router:              router,
@@ -50,10 +49,11 @@ func TestAuth(t *testing.T) {
 	}
 	server.SetupRoutes()
 
-	s := httptest.NewServer(server.router)
+	s := httptest.NewServer(server.Handler())
 	t.Cleanup(s.Close)
 
 	taskWithUserID1 := []byte(`{"id":1, "summary": "a", "user": {"id": 1, "username": "a"}}`)
+	editedTask := []byte(`{"summary": "a"}`)
 
 	testData := [][]interface{}{
 		// tasks
@@ -61,9 +61,14 @@ func TestAuth(t *testing.T) {
 		{http.MethodGet, "/tasks", 1, "manager", nil, 500},
 
 		{http.MethodPut, "/tasks/1", 0, "", nil, 401},
-		{http.MethodPut, "/tasks/1", 2, "technician", taskWithUserID1, 403},
-		{http.MethodPut, "/tasks/1", 2, "manager", taskWithUserID1, 500},
-		{http.MethodPut, "/tasks/1", 1, "manager", taskWithUserID1, 500},
+		// The owner of the task is checked once it's fetched
+		{http.MethodPut, "/tasks/1", 2, "technician", editedTask, 500},
+		{http.MethodPut, "/tasks/1", 2, "manager", editedTask, 500},
+		{http.MethodPut, "/tasks/1", 1, "manager", editedTask, 500},
+
+		{http.MethodPatch, "/tasks/1", 0, "", nil, 401},
+		// Authenticated patches are only rejected for their missing content type
+		{http.MethodPatch, "/tasks/1", 2, "technician", nil, 415},
 
 		{http.MethodDelete, "/tasks/1", 0, "", nil, 401},
 		{http.MethodDelete, "/tasks/1", 2, "technician", nil, 403},
@@ -74,6 +79,75 @@ func TestAuth(t *testing.T) {
 		{http.MethodPost, "/tasks", 2, "manager", taskWithUserID1, 500},
 		{http.MethodPost, "/tasks", 1, "manager", taskWithUserID1, 500},
 
+		{http.MethodPost, "/tasks:batch", 0, "", nil, 401},
+		{http.MethodPost, "/tasks:batch", 2, "technician", nil, 403},
+		{http.MethodPost, "/tasks:batch", 2, "manager", []byte(`{"operations": [{"op": "delete", "id": 1}]}`), 500},
+		{http.MethodPost, "/tasks/batch", 0, "", nil, 401},
+		{http.MethodPost, "/tasks/batch", 2, "manager", []byte(`{"operations": [{"op": "delete", "id": 1}]}`), 500},
+		// Only the literal custom method is routed, other paths starting with /tasks aren't found before authentication
+		{http.MethodPost, "/tasksfoo", 0, "", nil, 404},
+		{http.MethodPost, "/tasks:purge", 0, "", nil, 404},
+
+		{http.MethodGet, "/tasks/1", 0, "", nil, 401},
+		{http.MethodGet, "/tasks/1", 2, "manager", nil, 500},
+
+		{http.MethodGet, "/tasks/unassigned", 0, "", nil, 401},
+		{http.MethodPost, "/tasks/1/claim", 0, "", nil, 401},
+
+		{http.MethodGet, "/tasks/export?format=csv", 0, "", nil, 401},
+		{http.MethodGet, "/tasks/export?format=csv", 2, "technician", nil, 500},
+		{http.MethodGet, "/tasks/search?q=filter", 0, "", nil, 401},
+		{http.MethodGet, "/tasks/search?q=filter", 2, "technician", nil, 500},
+
+		{http.MethodPost, "/tasks/import?format=csv", 0, "", nil, 401},
+		{http.MethodPost, "/tasks/import?format=csv", 2, "technician", nil, 403},
+		{http.MethodPost, "/tasks/import?format=csv", 2, "manager", []byte("summary,user\nfix the pump,joel\n"), 500},
+
+		{http.MethodGet, "/schedules", 0, "", nil, 401},
+		{http.MethodGet, "/schedules", 2, "technician", nil, 403},
+		{http.MethodGet, "/schedules", 2, "manager", nil, 500},
+		{http.MethodPost, "/schedules", 2, "technician", []byte(`{"summary": "calibrate device", "cron": "@weekly"}`), 403},
+		{http.MethodPost, "/schedules", 2, "manager", []byte(`{"summary": "calibrate device", "cron": "@weekly"}`), 500},
+		{http.MethodGet, "/schedules/1", 2, "technician", nil, 403},
+		{http.MethodPut, "/schedules/1", 0, "", nil, 401},
+		{http.MethodPut, "/schedules/1", 2, "manager", []byte(`{"summary": "calibrate device", "cron": "@weekly"}`), 500},
+		{http.MethodPost, "/schedules/1/pause", 0, "", nil, 401},
+		{http.MethodPost, "/schedules/1/pause", 2, "technician", nil, 403},
+		{http.MethodPost, "/schedules/1/resume", 2, "technician", nil, 403},
+		{http.MethodPost, "/schedules/1/resume", 2, "manager", nil, 500},
+
+		{http.MethodGet, "/reports/completions", 0, "", nil, 401},
+		{http.MethodGet, "/reports/completions", 2, "technician", nil, 403},
+		{http.MethodGet, "/reports/completions?period=week", 2, "manager", nil, 500},
+		{http.MethodGet, "/reports/technicians", 2, "technician", nil, 403},
+		{http.MethodGet, "/reports/technicians?format=csv", 2, "manager", nil, 500},
+		{http.MethodGet, "/reports/summary", 0, "", nil, 401},
+		{http.MethodGet, "/reports/summary", 2, "technician", nil, 403},
+
+		{http.MethodGet, "/tasks/trash", 0, "", nil, 401},
+		{http.MethodGet, "/tasks/trash", 2, "technician", nil, 403},
+		{http.MethodGet, "/tasks/trash", 2, "manager", nil, 500},
+		{http.MethodPost, "/tasks/1/restore", 0, "", nil, 401},
+		{http.MethodPost, "/tasks/1/restore", 2, "technician", nil, 403},
+
+		{http.MethodPut, "/tasks/1/assignee", 0, "", nil, 401},
+		{http.MethodPut, "/tasks/1/assignee", 2, "technician", []byte(`{"id": 1}`), 403},
+		{http.MethodPut, "/tasks/1/assignee", 2, "manager", []byte(`{"id": 1}`), 500},
+		{http.MethodDelete, "/tasks/1/assignee", 2, "technician", nil, 403},
+
+		{http.MethodGet, "/tasks/1/comments", 0, "", nil, 401},
+		{http.MethodPost, "/tasks/1/comments", 0, "", nil, 401},
+		{http.MethodPut, "/tasks/1/comments/1", 0, "", nil, 401},
+		{http.MethodDelete, "/tasks/1/comments/1", 0, "", nil, 401},
+
+		{http.MethodGet, "/tasks/1/history", 0, "", nil, 401},
+		{http.MethodGet, "/tasks/1/history", 2, "manager", nil, 500},
+
+		{http.MethodGet, "/tasks/1/attachments", 0, "", nil, 401},
+		{http.MethodPost, "/tasks/1/attachments", 0, "", nil, 401},
+		{http.MethodGet, "/tasks/1/attachments/1", 0, "", nil, 401},
+		{http.MethodDelete, "/tasks/1/attachments/1", 0, "", nil, 401},
+
 		// users
 		{http.MethodPost, "/login", 0, "", nil, 400},
 	}
@@ -111,7 +185,7 @@ func makeRequestWithValidTokenAndCheckStatusCode(method string, path string, tok
 
 		if tokenUserID != 0 {
 			rows := sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow(tokenUserID, "joao", tokenUserRole, 2)
-			mock.ExpectQuery(expectedFetchUserByTokenSQL).WithArgs(token).WillReturnRows(rows)
+			mock.ExpectQuery(expectedFetchUserByTokenSQL).WithArgs(token, sqlmock.AnyArg()).WillReturnRows(rows)
 		}
 
 		response, err := client.Do(req)
@@ -0,0 +1,42 @@
+package eventbus
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/tracing"
+	"sync"
+)
+
+// Delivery is a message received from a broker together with the callback that acknowledges it, settle receives the
+// result of the handler
+type Delivery struct {
+	Message Message
+	Settle  func(err error)
+}
+
+// Dispatch fans the deliveries out to a pool of workers, each message is handled with its own timeout. It returns once
+// the deliveries channel is closed and every worker is idle, which is what makes a graceful drain possible
+func Dispatch(deliveries <-chan Delivery, handler Handler, config ConsumerConfig, logger *zap.SugaredLogger) {
+	workers := &sync.WaitGroup{}
+	for i := 0; i < config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for d := range deliveries {
+				metrics.ConsumerLag(d.Message.Topic, d.Message.PublishedAt)
+				ctx, cancel := context.WithTimeout(context.Background(), config.HandlerTimeout)
+				ctx, span := startProcess(ctx, d.Message)
+				err := handler(ctx, d.Message)
+				tracing.End(span, err)
+				cancel()
+				if err != nil {
+					logger.Warnw("Failed to handle message", "topic", d.Message.Topic, "type", d.Message.Type, "error", err)
+				}
+				metrics.MessageConsumed(d.Message.Topic, d.Message.Type, err)
+				d.Settle(err)
+			}
+		}()
+	}
+	workers.Wait()
+}
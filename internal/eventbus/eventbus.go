@@ -0,0 +1,53 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrMalformedMessage should be returned by handlers when a message can never be processed, brokers will drop it instead of redelivering it
+var ErrMalformedMessage = errors.New("malformed message")
+
+// Message is the broker agnostic envelope of everything we publish. Topic is where the message is routed to and Type tells
+// consumers how to parse the body. PublishedAt is set by the bus when publishing
+type Message struct {
+	Topic       string
+	Type        string
+	Headers     map[string]string
+	Body        []byte
+	PublishedAt time.Time
+}
+
+type Handler func(ctx context.Context, msg Message) error
+
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Subscriber implementations block in Subscribe until the context is cancelled and every message in flight was handled
+type Subscriber interface {
+	Subscribe(ctx context.Context, topic string, handler Handler) error
+}
+
+type EventBus interface {
+	Publisher
+	Subscriber
+}
+
+// HealthChecker is implemented by the buses that depend on a connection to a broker
+type HealthChecker interface {
+	Healthy() error
+}
+
+// ConsumerConfig controls how many messages are pushed to a subscriber before they are settled (Prefetch), how many of
+// them are processed concurrently (Workers) and how long a single message may take to be handled (HandlerTimeout)
+type ConsumerConfig struct {
+	Prefetch       int
+	Workers        int
+	HandlerTimeout time.Duration
+}
+
+func DefaultConsumerConfig() ConsumerConfig {
+	return ConsumerConfig{Prefetch: 20, Workers: 4, HandlerTimeout: 10 * time.Second}
+}
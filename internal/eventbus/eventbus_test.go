@@ -0,0 +1,131 @@
+package eventbus
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDispatchHandlesDeliveriesConcurrently(t *testing.T) {
+	config := ConsumerConfig{Prefetch: 4, Workers: 4, HandlerTimeout: time.Second}
+
+	var running, maxRunning int32
+	handler := func(ctx context.Context, msg Message) error {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+
+	var settled int32
+	deliveries := make(chan Delivery, 8)
+	for i := 0; i < 8; i++ {
+		deliveries <- Delivery{Settle: func(err error) { atomic.AddInt32(&settled, 1) }}
+	}
+	close(deliveries)
+
+	start := time.Now()
+	Dispatch(deliveries, handler, config, zap.NewNop().Sugar())
+
+	assert.Equal(t, int32(8), settled)
+	assert.Equal(t, int32(4), maxRunning)
+	assert.Less(t, int64(time.Since(start)), int64(400*time.Millisecond))
+}
+
+func TestDispatchTimesOutSlowHandlers(t *testing.T) {
+	config := ConsumerConfig{Prefetch: 1, Workers: 1, HandlerTimeout: 10 * time.Millisecond}
+	handler := func(ctx context.Context, msg Message) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	var result error
+	deliveries := make(chan Delivery, 1)
+	deliveries <- Delivery{Settle: func(err error) { result = err }}
+	close(deliveries)
+
+	Dispatch(deliveries, handler, config, zap.NewNop().Sugar())
+
+	assert.Equal(t, context.DeadlineExceeded, result)
+}
+
+func TestMemoryBusDeliversPublishedMessagesAndDrainsOnShutdown(t *testing.T) {
+	bus := NewMemoryBus(zap.NewNop().Sugar(), DefaultConsumerConfig(), 10)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	mu := sync.Mutex{}
+	var received []string
+	done := make(chan struct{})
+	go func() {
+		_ = bus.Subscribe(ctx, "tasks", func(ctx context.Context, msg Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, string(msg.Body))
+			return nil
+		})
+		close(done)
+	}()
+
+	assert.Nil(t, bus.Publish(context.Background(), Message{Topic: "tasks", Body: []byte("1")}))
+	assert.Nil(t, bus.Publish(context.Background(), Message{Topic: "tasks", Body: []byte("2")}))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestMemoryBusRefusesMessagesWhenBufferIsFull(t *testing.T) {
+	bus := NewMemoryBus(zap.NewNop().Sugar(), DefaultConsumerConfig(), 1)
+
+	assert.Nil(t, bus.Publish(context.Background(), Message{Topic: "tasks"}))
+	assert.Equal(t, ErrBufferFull, bus.Publish(context.Background(), Message{Topic: "tasks"}))
+}
+
+func TestConsumerContinuesThePublisherTrace(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	bus := NewMemoryBus(zap.NewNop().Sugar(), DefaultConsumerConfig(), 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	received := make(chan trace.SpanContext, 1)
+	done := make(chan struct{})
+	go func() {
+		_ = bus.Subscribe(ctx, "tasks", func(ctx context.Context, msg Message) error {
+			received <- trace.SpanContextFromContext(ctx)
+			return nil
+		})
+		close(done)
+	}()
+
+	publishCtx, span := otel.Tracer("test").Start(context.Background(), "updateTask")
+	assert.Nil(t, bus.Publish(publishCtx, Message{Topic: "tasks", Type: "task.completed"}))
+	span.End()
+
+	consumerSpan := <-received
+	cancel()
+	<-done
+
+	assert.Equal(t, span.SpanContext().TraceID(), consumerSpan.TraceID())
+	assert.NotEqual(t, span.SpanContext().SpanID(), consumerSpan.SpanID())
+}
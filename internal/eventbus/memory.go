@@ -0,0 +1,87 @@
+package eventbus
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/tracing"
+	"sync"
+	"time"
+)
+
+var ErrBufferFull = fmt.Errorf("in-memory topic buffer is full")
+
+// MemoryBus is an in-process EventBus backed by buffered channels, one per topic. Subscribers of the same topic compete
+// for messages like consumers of the same queue would. Nothing survives a restart so it's meant for development and tests
+type MemoryBus struct {
+	mu         sync.Mutex
+	topics     map[string]chan Delivery
+	logger     *zap.SugaredLogger
+	config     ConsumerConfig
+	bufferSize int
+}
+
+func NewMemoryBus(logger *zap.SugaredLogger, config ConsumerConfig, bufferSize int) *MemoryBus {
+	return &MemoryBus{topics: map[string]chan Delivery{}, logger: logger, config: config, bufferSize: bufferSize}
+}
+
+func (b *MemoryBus) topic(name string) chan Delivery {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.topics[name]
+	if !ok {
+		ch = make(chan Delivery, b.bufferSize)
+		b.topics[name] = ch
+	}
+	return ch
+}
+
+// Publish never blocks, when nobody is consuming the topic and the buffer fills up the message is refused
+func (b *MemoryBus) Publish(ctx context.Context, msg Message) error {
+	msg, span := StartPublish(ctx, "memory", msg)
+	msg.PublishedAt = time.Now()
+	d := Delivery{Message: msg, Settle: func(err error) {}}
+	select {
+	case b.topic(msg.Topic) <- d:
+		metrics.MessagePublished(msg.Topic, msg.Type, nil)
+		tracing.End(span, nil)
+		return nil
+	default:
+		b.logger.Warnw("Failed to publish message to in-memory topic", "topic", msg.Topic, "error", ErrBufferFull)
+		metrics.MessagePublished(msg.Topic, msg.Type, ErrBufferFull)
+		tracing.End(span, ErrBufferFull)
+		return ErrBufferFull
+	}
+}
+
+func (b *MemoryBus) Subscribe(ctx context.Context, topic string, handler Handler) error {
+	source := b.topic(topic)
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case d := <-source:
+				select {
+				case deliveries <- d:
+				case <-ctx.Done():
+					// Put it back so the next subscriber gets it
+					select {
+					case source <- d:
+					default:
+						b.logger.Warnw("Dropped in-memory message while shutting down consumer", "topic", topic, "type", d.Message.Type)
+					}
+					return
+				}
+			}
+		}
+	}()
+
+	b.logger.Infow("Started in-memory consumer", "topic", topic, "workers", b.config.Workers)
+	Dispatch(deliveries, handler, b.config, b.logger)
+	b.logger.Infow("Closed in-memory consumer", "topic", topic)
+	return nil
+}
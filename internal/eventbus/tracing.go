@@ -0,0 +1,33 @@
+package eventbus
+
+import (
+	"context"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"sword-challenge/internal/tracing"
+)
+
+// StartPublish starts the producer span of a message and adds its trace context to the message headers so the consumer
+// continues the same trace. The caller ends the span once the message was handed to the broker
+func StartPublish(ctx context.Context, system string, msg Message) (Message, trace.Span) {
+	ctx, span := tracing.Start(ctx, msg.Topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String(system),
+			semconv.MessagingDestinationKey.String(msg.Topic),
+			semconv.MessagingDestinationKindQueue,
+		),
+	)
+	msg.Headers = tracing.Inject(ctx, msg.Headers)
+	return msg, span
+}
+
+func startProcess(ctx context.Context, msg Message) (context.Context, trace.Span) {
+	return tracing.Start(tracing.Extract(ctx, msg.Headers), msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingDestinationKey.String(msg.Topic),
+			semconv.MessagingOperationProcess,
+		),
+	)
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"net/http"
+	"os"
+	"sword-challenge/internal/eventbus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	statusOK      = "ok"
+	statusFailing = "failing"
+)
+
+type componentStatus struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+type readinessStatus struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components"`
+}
+
+// livez only tells whether the process is able to serve requests, dependencies are checked by readyz so a broken
+// database doesn't get every pod restarted
+func (s *SwordChallengeServer) livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": statusOK})
+}
+
+// readyz checks every dependency concurrently, each one with its own timeout. It starts failing as soon as the graceful
+// shutdown starts so no new traffic is routed to this pod
+func (s *SwordChallengeServer) readyz(c *gin.Context) {
+	if atomic.LoadInt32(&s.shuttingDown) == 1 {
+		c.JSON(http.StatusServiceUnavailable, readinessStatus{Status: "shuttingDown", Components: map[string]componentStatus{}})
+		return
+	}
+
+	checks := map[string]func(ctx context.Context) error{
+		"database":   s.checkDatabase,
+		"messaging":  s.checkMessaging,
+		"migrations": s.checkMigrations,
+	}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	status := readinessStatus{Status: statusOK, Components: map[string]componentStatus{}}
+	for name, check := range checks {
+		name, check := name, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), s.config.Server.ReadinessTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(ctx)
+			component := componentStatus{Status: statusOK, LatencyMs: time.Since(start).Milliseconds()}
+			if err == nil && ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				component.Status = statusFailing
+				component.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			status.Components[name] = component
+			if err != nil {
+				status.Status = statusFailing
+			}
+		}()
+	}
+	wg.Wait()
+
+	if status.Status != statusOK {
+		s.logger.Warnw("Readiness check failed", "components", status.Components)
+		c.JSON(http.StatusServiceUnavailable, status)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (s *SwordChallengeServer) checkDatabase(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SwordChallengeServer) checkMessaging(_ context.Context) error {
+	if checker, ok := s.bus.(eventbus.HealthChecker); ok {
+		return checker.Healthy()
+	}
+	return nil
+}
+
+var errMigrationsBehind = errors.New("database schema is not at the expected version")
+var errMigrationsDirty = errors.New("last migration failed and the database is dirty")
+
+// checkMigrations compares the version in the database with the latest migration shipped with the server
+func (s *SwordChallengeServer) checkMigrations(ctx context.Context) error {
+	expected, err := s.expectedMigrationVersion()
+	if err != nil {
+		return err
+	}
+
+	var current struct {
+		Version uint
+		Dirty   bool
+	}
+	err = s.db.GetContext(ctx, &current, "SELECT version, dirty FROM schema_migrations LIMIT 1;")
+	if err == sql.ErrNoRows {
+		return errMigrationsBehind
+	} else if err != nil {
+		return err
+	}
+	if current.Dirty {
+		return errMigrationsDirty
+	}
+	if current.Version < expected {
+		return errMigrationsBehind
+	}
+	return nil
+}
+
+func (s *SwordChallengeServer) expectedMigrationVersion() (uint, error) {
+	s.migrationVersionOnce.Do(func() {
+		var driver source.Driver
+		driver, s.migrationVersionErr = source.Open(s.config.Migrations.Path)
+		if s.migrationVersionErr != nil {
+			return
+		}
+		defer driver.Close()
+
+		version, err := driver.First()
+		for err == nil {
+			s.migrationVersion = version
+			version, err = driver.Next(version)
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			s.migrationVersionErr = err
+		}
+	})
+	return s.migrationVersion, s.migrationVersionErr
+}
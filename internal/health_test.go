@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sword-challenge/internal/config"
+	"sword-challenge/internal/eventbus"
+	"testing"
+	"time"
+)
+
+const expectedMigrationVersionSQL = "SELECT version, dirty FROM schema_migrations LIMIT 1;"
+
+type unhealthyBus struct {
+	*eventbus.MemoryBus
+}
+
+func (b unhealthyBus) Healthy() error {
+	return fmt.Errorf("channel closed")
+}
+
+func newHealthTestServer(t *testing.T, bus eventbus.EventBus) (*SwordChallengeServer, sqlmock.Sqlmock) {
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	cfg := config.Default()
+	cfg.Migrations.Path = "file://../db/migrations"
+	server := &SwordChallengeServer{router: gin.New(), db: sqlx.NewDb(db, "mysql"), logger: zap.NewNop().Sugar(), config: cfg, bus: bus}
+	server.router.GET("/livez", server.livez)
+	server.router.GET("/readyz", server.readyz)
+	return server, mock
+}
+
+func getReadiness(t *testing.T, server *SwordChallengeServer) (int, readinessStatus) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/readyz", nil)
+	server.router.ServeHTTP(w, req)
+
+	var status readinessStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to parse response body: error: %v", err)
+	}
+	return w.Code, status
+}
+
+func TestLivenessDoesntCheckDependencies(t *testing.T) {
+	server, mock := newHealthTestServer(t, unhealthyBus{})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/livez", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReadinessWhenEveryDependencyIsUp(t *testing.T) {
+	server, mock := newHealthTestServer(t, eventbus.NewMemoryBus(zap.NewNop().Sugar(), eventbus.DefaultConsumerConfig(), 1))
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectPing()
+	latest, err := server.expectedMigrationVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(expectedMigrationVersionSQL).WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(latest, false))
+
+	code, status := getReadiness(t, server)
+
+	assert.Equal(t, 200, code)
+	assert.Equal(t, statusOK, status.Status)
+	assert.Equal(t, statusOK, status.Components["database"].Status)
+	assert.Equal(t, statusOK, status.Components["messaging"].Status)
+	assert.Equal(t, statusOK, status.Components["migrations"].Status)
+}
+
+func TestReadinessFailsPerComponent(t *testing.T) {
+	server, mock := newHealthTestServer(t, unhealthyBus{})
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectPing().WillReturnError(fmt.Errorf("connection refused"))
+	mock.ExpectQuery(expectedMigrationVersionSQL).WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+
+	code, status := getReadiness(t, server)
+
+	assert.Equal(t, 503, code)
+	assert.Equal(t, statusFailing, status.Status)
+	assert.Equal(t, "connection refused", status.Components["database"].Error)
+	assert.Equal(t, "channel closed", status.Components["messaging"].Error)
+	assert.Equal(t, errMigrationsBehind.Error(), status.Components["migrations"].Error)
+}
+
+func TestReadinessFailsWhenShuttingDown(t *testing.T) {
+	server, mock := newHealthTestServer(t, eventbus.NewMemoryBus(zap.NewNop().Sugar(), eventbus.DefaultConsumerConfig(), 1))
+	server.shuttingDown = 1
+
+	code, status := getReadiness(t, server)
+
+	assert.Equal(t, 503, code)
+	assert.Equal(t, "shuttingDown", status.Status)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestReadinessFailsDuringTheShutdownDelay(t *testing.T) {
+	server, _ := newHealthTestServer(t, eventbus.NewMemoryBus(zap.NewNop().Sugar(), eventbus.DefaultConsumerConfig(), 1))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.server = &http.Server{Handler: server.router}
+	go server.server.Serve(listener)
+	url := "http://" + listener.Addr().String() + "/readyz"
+
+	done := make(chan struct{})
+	go func() {
+		server.shutdown(300*time.Millisecond, time.Second)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// The server still accepts connections but tells the probe to take it out of the service
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, 503, resp.StatusCode)
+
+	<-done
+	_, err = http.Get(url)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"regexp"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey struct{}
+
+// Request IDs sent by callers are only kept when they are reasonably short and safe to print
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+func WithLogger(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the request-scoped logger, or the fallback when the context doesn't carry one
+func FromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(contextKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// AddFields adds the key-value pairs to the request-scoped logger, they show up in every line logged afterwards for the
+// request, including the access log
+func AddFields(c *gin.Context, keysAndValues ...interface{}) {
+	if c.Request == nil {
+		return
+	}
+	if logger, ok := c.Request.Context().Value(contextKey{}).(*zap.SugaredLogger); ok {
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), logger.With(keysAndValues...)))
+	}
+}
+
+// Middleware assigns every request an ID, reusing the one sent by the caller when there is one, and returns it in the
+// response. Handlers get a logger carrying the ID through FromContext and an access log line is written once the
+// request is handled
+func Middleware(logger *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, requestID)
+
+		requestLogger := logger.With("requestId", requestID)
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			requestLogger = requestLogger.With("traceId", span.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), requestLogger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []interface{}{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency", time.Since(start),
+			"clientIp", c.ClientIP(),
+			"responseSize", c.Writer.Size(),
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, "errors", c.Errors.String())
+		}
+		// Read it again, authentication adds the user to it
+		accessLogger := FromContext(c.Request.Context(), requestLogger)
+		if status >= 500 {
+			accessLogger.Warnw("Request handled", fields...)
+		} else {
+			accessLogger.Infow("Request handled", fields...)
+		}
+	}
+}
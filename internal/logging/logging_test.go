@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func setupRouter() (*gin.Engine, *observer.ObservedLogs) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	router := gin.New()
+	router.Use(Middleware(zap.New(core).Sugar()))
+	router.GET("/tasks/:task-id", func(c *gin.Context) {
+		AddFields(c, "userId", 2)
+		FromContext(c.Request.Context(), nil).Infow("Failed to find task while updating")
+		c.Status(http.StatusNotFound)
+	})
+	return router, logs
+}
+
+func TestMiddlewareAssignsRequestIDAndLogsAccess(t *testing.T) {
+	router, logs := setupRouter()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tasks/1", nil))
+
+	requestID := w.Header().Get(RequestIDHeader)
+	assert.Len(t, requestID, 36)
+
+	entries := logs.All()
+	assert.Len(t, entries, 2)
+	handlerLine := entries[0].ContextMap()
+	assert.Equal(t, requestID, handlerLine["requestId"])
+	assert.Equal(t, int64(2), handlerLine["userId"])
+
+	accessLine := entries[1].ContextMap()
+	assert.Equal(t, "Request handled", entries[1].Message)
+	assert.Equal(t, requestID, accessLine["requestId"])
+	assert.Equal(t, int64(2), accessLine["userId"])
+	assert.Equal(t, "/tasks/:task-id", accessLine["route"])
+	assert.Equal(t, int64(404), accessLine["status"])
+	assert.Contains(t, accessLine, "latency")
+}
+
+func TestMiddlewarePropagatesValidRequestIDs(t *testing.T) {
+	router, _ := setupRouter()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1", nil)
+	req.Header.Set(RequestIDHeader, "mobile-7f3a")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, "mobile-7f3a", w.Header().Get(RequestIDHeader))
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/tasks/1", nil)
+	req.Header.Set(RequestIDHeader, "bad\nid")
+	router.ServeHTTP(w, req)
+	assert.NotEqual(t, "bad\nid", w.Header().Get(RequestIDHeader))
+}
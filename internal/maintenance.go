@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/mysql"
+	"io"
+	"sword-challenge/internal/task"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+func (s *SwordChallengeServer) migrator() (*migrate.Migrate, error) {
+	driver, err := mysql.WithInstance(s.db.DB, &mysql.Config{})
+	if err != nil {
+		s.logger.Errorw("Failed to start go-migrate driver", "error", err)
+		return nil, err
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(s.config.Migrations.Path, s.config.Database.Name, driver)
+	if err != nil {
+		s.logger.Errorw("Failed to start go-migrate migration instance", "error", err)
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *SwordChallengeServer) RunMigrations() error {
+	m, err := s.migrator()
+	if err != nil {
+		return err
+	}
+	err = m.Up()
+	if err != nil && err != migrate.ErrNoChange {
+		s.logger.Errorw("Failed to migrate database to latest version", "error", err)
+		return err
+	}
+	return nil
+}
+
+// RollbackMigrations reverts the last steps migrations
+func (s *SwordChallengeServer) RollbackMigrations(steps int) error {
+	m, err := s.migrator()
+	if err != nil {
+		return err
+	}
+	if err := m.Steps(-steps); err != nil && err != migrate.ErrNoChange {
+		s.logger.Errorw("Failed to roll back database migrations", "steps", steps, "error", err)
+		return err
+	}
+	return nil
+}
+
+// MigrationStatus returns the version the database is at, version 0 means no migration was applied yet
+func (s *SwordChallengeServer) MigrationStatus() (uint, bool, error) {
+	m, err := s.migrator()
+	if err != nil {
+		return 0, false, err
+	}
+	version, dirty, err := m.Version()
+	if err == migrate.ErrNilVersion {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// ForceMigrationVersion sets the version without running any migration, it's used to recover from a dirty state after fixing it by hand
+func (s *SwordChallengeServer) ForceMigrationVersion(version int) error {
+	m, err := s.migrator()
+	if err != nil {
+		return err
+	}
+	return m.Force(version)
+}
+
+// Seed creates the default roles and users, it can be run multiple times
+func (s *SwordChallengeServer) Seed() error {
+	for _, role := range []string{util.TechnicianRole, util.AdminRole} {
+		if err := s.userService.EnsureRole(role); err != nil {
+			return err
+		}
+	}
+
+	defaultUsers := []struct{ username, role string }{{"joel", util.TechnicianRole}, {"dvn", util.AdminRole}}
+	for _, u := range defaultUsers {
+		if _, err := s.userService.CreateUser(u.username, u.role); err != nil && !errors.Is(err, user.ErrUsernameTaken) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SwordChallengeServer) CreateUser(username string, role string) (*user.User, error) {
+	return s.userService.CreateUser(username, role)
+}
+
+// RotateEncryptionKey re-encrypts with crypto.aesKey what is still encrypted with crypto.previousKey
+func (s *SwordChallengeServer) RotateEncryptionKey(ctx context.Context) (int, error) {
+	return s.tasksService.RotateKey(ctx)
+}
+
+// RebuildSearchIndex recomputes the search tokens of every task with the configured search key
+func (s *SwordChallengeServer) RebuildSearchIndex(ctx context.Context) (int, error) {
+	return s.tasksService.RebuildSearchIndex(ctx)
+}
+
+// CreateScheduledTasks creates the tasks of the recurring schedules up to the configured horizon
+func (s *SwordChallengeServer) CreateScheduledTasks(ctx context.Context) (int, error) {
+	return s.tasksService.CreateScheduledTasks(ctx, s.config.Scheduler.RecurringHorizon)
+}
+
+// ImportTasks creates the tasks of a CSV or JSON lines file as the manager with the username, see task.Service.Import
+func (s *SwordChallengeServer) ImportTasks(ctx context.Context, r io.Reader, format string, managerUsername string, dryRun bool) (*task.ImportResult, error) {
+	users, err := s.userService.GetUsersByUsernames(ctx, []string{managerUsername})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 || users[0].Role == nil || users[0].Role.Name != util.AdminRole {
+		return nil, fmt.Errorf("%s is not a manager, only managers can import tasks", managerUsername)
+	}
+	return s.tasksService.Import(ctx, r, format, &users[0], dryRun)
+}
+
+// PurgeTokens deletes the login tokens older than the duration passed
+func (s *SwordChallengeServer) PurgeTokens(olderThan time.Duration) (int, error) {
+	return s.userService.PurgeTokens(time.Now().Add(-olderThan))
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"strconv"
+	"time"
+)
+
+const namespace = "scs"
+
+// Every collector is registered in the default registry, which also exposes the Go runtime and process metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "http_requests_total", Help: "HTTP requests by route and status",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "http_request_duration_seconds", Help: "HTTP request latency by route and status",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "db_query_duration_seconds", Help: "Duration of the queries of each store function",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"function"})
+
+	cryptoOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "crypto_operations_total", Help: "Encryptions and decryptions by result",
+	}, []string{"operation", "result"})
+
+	messagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "messages_published_total", Help: "Messages published to the event bus by result",
+	}, []string{"topic", "type", "result"})
+	messagesConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace, Name: "messages_consumed_total", Help: "Messages consumed from the event bus by result",
+	}, []string{"topic", "type", "result"})
+	consumerLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace, Name: "message_consumer_lag_seconds", Help: "Time between a message being published and a consumer starting to handle it",
+		Buckets: []float64{.005, .01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"topic"})
+
+	tokensIssued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace, Name: "tokens_issued_total", Help: "Login tokens issued",
+	})
+)
+
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records every request using the route template instead of the path so task IDs don't explode the cardinality
+func Middleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	status := strconv.Itoa(c.Writer.Status())
+	httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+	httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+}
+
+// RegisterDB exposes the connection pool statistics, registering the same database twice is a no-op
+func RegisterDB(db *sql.DB, name string) error {
+	err := prometheus.Register(collectors.NewDBStatsCollector(db, name))
+	if errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+		return nil
+	}
+	return err
+}
+
+// ObserveQuery is meant to be deferred at the beginning of a store function: defer metrics.ObserveQuery("getTask")()
+func ObserveQuery(function string) func() {
+	start := time.Now()
+	return func() {
+		dbQueryDuration.WithLabelValues(function).Observe(time.Since(start).Seconds())
+	}
+}
+
+func CryptoOperation(operation string, err error) {
+	cryptoOperations.WithLabelValues(operation, result(err)).Inc()
+}
+
+func MessagePublished(topic string, messageType string, err error) {
+	messagesPublished.WithLabelValues(topic, messageType, result(err)).Inc()
+}
+
+// ConsumerLag should be called when a consumer starts handling a message, it's only known when the publish time was kept
+func ConsumerLag(topic string, publishedAt time.Time) {
+	if !publishedAt.IsZero() {
+		consumerLag.WithLabelValues(topic).Observe(time.Since(publishedAt).Seconds())
+	}
+}
+
+func MessageConsumed(topic string, messageType string, err error) {
+	messagesConsumed.WithLabelValues(topic, messageType, result(err)).Inc()
+}
+
+func TokenIssued() {
+	tokensIssued.Inc()
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareUsesTheRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware)
+	router.GET("/tasks/:task-id", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+	router.GET("/metrics", Handler())
+
+	before := testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/tasks/:task-id", "404"))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tasks/1", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tasks/2", nil))
+	assert.Equal(t, before+2, testutil.ToFloat64(httpRequests.WithLabelValues(http.MethodGet, "/tasks/:task-id", "404")))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), `scs_http_requests_total{method="GET",route="/tasks/:task-id",status="404"}`))
+}
+
+func TestCryptoOperationsAreCountedByResult(t *testing.T) {
+	ok := testutil.ToFloat64(cryptoOperations.WithLabelValues("decrypt", "ok"))
+	failed := testutil.ToFloat64(cryptoOperations.WithLabelValues("decrypt", "error"))
+
+	CryptoOperation("decrypt", nil)
+	CryptoOperation("decrypt", errors.New("message authentication failed"))
+
+	assert.Equal(t, ok+1, testutil.ToFloat64(cryptoOperations.WithLabelValues("decrypt", "ok")))
+	assert.Equal(t, failed+1, testutil.ToFloat64(cryptoOperations.WithLabelValues("decrypt", "error")))
+}
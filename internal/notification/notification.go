@@ -0,0 +1,63 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/task"
+	"time"
+)
+
+// Service consumes the task events, for now notifying someone means writing a log line
+type Service struct {
+	logger *zap.SugaredLogger
+}
+
+func NewService(logger *zap.SugaredLogger) *Service {
+	return &Service{logger: logger}
+}
+
+func (s *Service) Handle(ctx context.Context, msg eventbus.Message) error {
+	switch msg.Type {
+	// Messages published before events had a type are all completion notifications
+	case task.EventTaskCompleted, "":
+		var t task.Notification
+		if err := json.Unmarshal(msg.Body, &t); err != nil || t.User == nil {
+			s.logger.Warnw("Failed to parse notification body to task", "error", err)
+			return eventbus.ErrMalformedMessage
+		}
+		s.logger.Infof("%s: The tech %s performed the task %d on date %s", t.Manager, t.User.Username, t.ID, t.CompletedDate)
+	case task.EventTaskStatusChanged:
+		var change task.StatusChange
+		if err := json.Unmarshal(msg.Body, &change); err != nil || change.User == nil {
+			s.logger.Warnw("Failed to parse notification body to status change", "error", err)
+			return eventbus.ErrMalformedMessage
+		}
+		s.logger.Infof("The task %d was moved from %s to %s by %s", change.ID, change.From, change.To, change.User.Username)
+	case task.EventTaskAssigned:
+		var assignment task.Assignment
+		if err := json.Unmarshal(msg.Body, &assignment); err != nil || assignment.Assignee == nil || assignment.AssignedBy == nil {
+			s.logger.Warnw("Failed to parse notification body to assignment", "error", err)
+			return eventbus.ErrMalformedMessage
+		}
+		s.logger.Infof("%s: The task %d was assigned to you by %s", assignment.Assignee.Username, assignment.ID, assignment.AssignedBy.Username)
+	case task.EventTaskOverdue:
+		var overdue task.Overdue
+		if err := json.Unmarshal(msg.Body, &overdue); err != nil || overdue.Recipient == "" || overdue.DueDate == nil {
+			s.logger.Warnw("Failed to parse notification body to overdue task", "error", err)
+			return eventbus.ErrMalformedMessage
+		}
+		s.logger.Infof("%s: The task %d is overdue since %s", overdue.Recipient, overdue.ID, overdue.DueDate.Format(time.RFC3339))
+	case task.EventTaskMentioned:
+		var mention task.Mention
+		if err := json.Unmarshal(msg.Body, &mention); err != nil || mention.Mentioned == nil || mention.Author == nil {
+			s.logger.Warnw("Failed to parse notification body to mention", "error", err)
+			return eventbus.ErrMalformedMessage
+		}
+		s.logger.Infof("%s: %s mentioned you in a comment on the task %d", mention.Mentioned.Username, mention.Author.Username, mention.ID)
+	default:
+		s.logger.Warnw("Ignoring notification with unknown type", "type", msg.Type)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,69 @@
+package notification
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/task"
+	"testing"
+)
+
+func TestHandleCompletedTaskNotification(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s := NewService(zap.New(core).Sugar())
+
+	err := s.Handle(context.Background(), eventbus.Message{Type: task.EventTaskCompleted, Body: []byte(`{"id": 1, "manager": "dvn", "user": {"id": 1, "username": "joel"}}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("dvn: The tech joel performed the task 1").Len())
+}
+
+func TestHandleMalformedNotification(t *testing.T) {
+	s := NewService(zap.NewNop().Sugar())
+
+	err := s.Handle(context.Background(), eventbus.Message{Type: task.EventTaskCompleted, Body: []byte("not json")})
+
+	assert.Equal(t, eventbus.ErrMalformedMessage, err)
+}
+
+func TestHandleStatusChangedNotification(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s := NewService(zap.New(core).Sugar())
+
+	err := s.Handle(context.Background(), eventbus.Message{Type: task.EventTaskStatusChanged, Body: []byte(`{"id": 1, "from": "todo", "to": "in_progress", "user": {"id": 1, "username": "joel"}}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("The task 1 was moved from todo to in_progress by joel").Len())
+}
+
+func TestHandleAssignedNotification(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s := NewService(zap.New(core).Sugar())
+
+	err := s.Handle(context.Background(), eventbus.Message{Type: task.EventTaskAssigned, Body: []byte(`{"id": 1, "assignee": {"id": 3, "username": "ana"}, "assignedBy": {"id": 2, "username": "dvn"}}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("ana: The task 1 was assigned to you by dvn").Len())
+}
+
+func TestHandleOverdueNotification(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s := NewService(zap.New(core).Sugar())
+
+	err := s.Handle(context.Background(), eventbus.Message{Type: task.EventTaskOverdue, Body: []byte(`{"id": 1, "recipient": "dvn", "dueDate": "2021-10-25T18:00:00Z", "user": {"id": 1, "username": "joel"}}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("dvn: The task 1 is overdue since 2021-10-25T18:00:00Z").Len())
+}
+
+func TestHandleMentionedNotification(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	s := NewService(zap.New(core).Sugar())
+
+	err := s.Handle(context.Background(), eventbus.Message{Type: task.EventTaskMentioned, Body: []byte(`{"id": 1, "commentId": 4, "mentioned": {"id": 2, "username": "dvn"}, "author": {"id": 1, "username": "joel"}}`)})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, logs.FilterMessageSnippet("dvn: joel mentioned you in a comment on the task 1").Len())
+}
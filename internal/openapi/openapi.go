@@ -0,0 +1,181 @@
+package openapi
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
+)
+
+// BasePath is the server URL of the document, its paths are relative to it
+const BasePath = "/api/v1"
+
+//go:embed openapi.yaml
+var document []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+func init() {
+	// Errors are validated against their schema like any other JSON response, and so are merge patches
+	openapi3filter.RegisterBodyDecoder(problem.ContentType, openapi3filter.RegisteredBodyDecoder(gin.MIMEJSON))
+	openapi3filter.RegisterBodyDecoder("application/merge-patch+json", openapi3filter.RegisteredBodyDecoder(gin.MIMEJSON))
+	// Imported files are only checked to be text, their rows are validated by the handler
+	openapi3filter.RegisterBodyDecoder("text/csv", openapi3filter.RegisteredBodyDecoder(gin.MIMEPlain))
+	openapi3filter.RegisterBodyDecoder("application/x-ndjson", openapi3filter.RegisteredBodyDecoder(gin.MIMEPlain))
+}
+
+// Spec is the OpenAPI document of the API, it's hand maintained in openapi.yaml and embedded in the binary
+type Spec struct {
+	doc  *openapi3.T
+	json []byte
+}
+
+func Load() (*Spec, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{doc: doc, json: encoded}, nil
+}
+
+func (s *Spec) ServeDocument(c *gin.Context) {
+	c.Data(http.StatusOK, gin.MIMEJSON, s.json)
+}
+
+func (s *Spec) ServeDocs(c *gin.Context) {
+	c.Data(http.StatusOK, gin.MIMEHTML+"; charset=utf-8", docsPage)
+}
+
+// Path converts a gin route to the path of the document, /api/v1/tasks/:task-id becomes /tasks/{task-id}
+func Path(route string) string {
+	segments := strings.Split(strings.TrimPrefix(route, BasePath), "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			segments[i] = "{" + segment[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Route returns the operation documented for the method and gin route, nil when it isn't documented
+func (s *Spec) Route(method string, route string) *routers.Route {
+	path := Path(route)
+	pathItem := s.doc.Paths.Find(path)
+	if pathItem == nil {
+		return nil
+	}
+	operation := pathItem.GetOperation(method)
+	if operation == nil {
+		return nil
+	}
+	return &routers.Route{Spec: s.doc, Path: path, PathItem: pathItem, Method: method, Operation: operation}
+}
+
+// Validator checks requests and responses against the document, it's meant for development and tests since it buffers
+// every response. Invalid requests are refused with a validation problem, responses that don't match are only logged
+// because they were already sent
+func (s *Spec) Validator(logger *zap.SugaredLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := s.Route(c.Request.Method, c.FullPath())
+		if route == nil {
+			c.Next()
+			return
+		}
+
+		pathParams := map[string]string{}
+		for _, p := range c.Params {
+			pathParams[p.Key] = p.Value
+		}
+		// The handlers bind the body as JSON whatever the content type, validate it the same way
+		if c.Request.ContentLength != 0 && c.GetHeader("Content-Type") == "" {
+			c.Request.Header.Set("Content-Type", gin.MIMEJSON)
+		}
+		ctx := c.Request.Context()
+		requestInput := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			// Authentication is checked by requireAuthentication, it knows whether the token is valid
+			Options: &openapi3filter.Options{AuthenticationFunc: openapi3filter.NoopAuthenticationFunc},
+		}
+		if err := openapi3filter.ValidateRequest(ctx, requestInput); err != nil {
+			logging.FromContext(ctx, logger).Infow("Request does not match the OpenAPI document", "error", err)
+			problem.Write(c, requestProblem(err))
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		responseInput := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: requestInput,
+			Status:                 recorder.Status(),
+			Header:                 recorder.Header(),
+			// Files are streamed in whatever type they were uploaded with, only the JSON bodies have a schema to check
+			Options: &openapi3filter.Options{IncludeResponseStatus: true, ExcludeResponseBody: !isJSON(recorder.Header().Get("Content-Type"))},
+		}
+		responseInput.SetBodyBytes(recorder.body.Bytes())
+		if err := openapi3filter.ValidateResponse(ctx, responseInput); err != nil {
+			logging.FromContext(c.Request.Context(), logger).Errorw("Response does not match the OpenAPI document",
+				"route", route.Path, "method", route.Method, "status", recorder.Status(), "error", err)
+		}
+	}
+}
+
+func isJSON(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return mediaType == "" || mediaType == gin.MIMEJSON || mediaType == problem.ContentType
+}
+
+func requestProblem(err error) *problem.Problem {
+	p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The request does not match the API specification")
+	var schemaErr *openapi3.SchemaError
+	var requestErr *openapi3filter.RequestError
+	switch {
+	case errors.As(err, &schemaErr):
+		field := strings.Join(schemaErr.JSONPointer(), ".")
+		if errors.As(err, &requestErr) && requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+		p.Errors = []problem.FieldError{{Field: field, Code: schemaErr.SchemaField, Message: schemaErr.Reason}}
+	case errors.As(err, &requestErr):
+		p.Detail = requestErr.Error()
+	}
+	return p
+}
+
+// bodyRecorder keeps a copy of the response body so it can be validated once the handler is done
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *bodyRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,95 @@
+package openapi
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, "/tasks", Path("/api/v1/tasks"))
+	assert.Equal(t, "/tasks/{task-id}", Path("/api/v1/tasks/:task-id"))
+}
+
+func setupValidatedAPI(t *testing.T) (*gin.Engine, *gin.RouterGroup, *observer.ObservedLogs) {
+	spec, err := Load()
+	if err != nil {
+		t.Fatalf("Failed to load OpenAPI document: %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	router := gin.New()
+	api := router.Group(BasePath)
+	api.Use(spec.Validator(zap.New(core).Sugar()))
+	return router, api, logs
+}
+
+func setupValidatedRouter(t *testing.T, handler gin.HandlerFunc) (*gin.Engine, *observer.ObservedLogs) {
+	router, api, logs := setupValidatedAPI(t)
+	api.PUT("/tasks/:task-id", handler)
+	return router, logs
+}
+
+func TestValidatorRefusesRequestsThatDontMatchTheDocument(t *testing.T) {
+	called := false
+	router, _ := setupValidatedRouter(t, func(c *gin.Context) { called = true })
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/tasks/1", bytes.NewReader([]byte(`{"summary": "a", "estimatedMinutes": "one"}`)))
+	router.ServeHTTP(w, req)
+
+	assert.False(t, called)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"field":"estimatedMinutes"`)
+}
+
+func TestValidatorLogsResponsesThatDontMatchTheDocument(t *testing.T) {
+	router, logs := setupValidatedRouter(t, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"id": 1, "summary": "a"})
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/tasks/1", bytes.NewReader([]byte(`{"summary": "a"}`)))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"id":1,"summary":"a"}`, w.Body.String())
+	assert.Equal(t, 1, logs.FilterMessage("Response does not match the OpenAPI document").Len())
+}
+
+func TestValidatorAcceptsFileUploadsAndDownloads(t *testing.T) {
+	router, api, logs := setupValidatedAPI(t)
+	api.POST("/tasks/:task-id/attachments", func(c *gin.Context) {
+		file, err := c.FormFile("file")
+		assert.Nil(t, err)
+		c.JSON(http.StatusCreated, gin.H{"id": 1, "taskId": 1, "filename": file.Filename, "contentType": "image/png", "size": file.Size,
+			"user": gin.H{"id": 1, "username": "joel"}, "createdDate": "2021-10-23T10:00:00Z"})
+	})
+	api.GET("/tasks/:task-id/attachments/:attachment-id", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte("\x89PNG\r\n\x1a\n"))
+	})
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "pump.png")
+	_, _ = part.Write([]byte("\x89PNG\r\n\x1a\n"))
+	_ = writer.Close()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tasks/1/attachments", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/tasks/1/attachments/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, 0, logs.FilterMessage("Request does not match the OpenAPI document").Len())
+	assert.Equal(t, 0, logs.FilterMessage("Response does not match the OpenAPI document").Len())
+}
@@ -0,0 +1,175 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"net/http"
+	"reflect"
+	"strings"
+	"sword-challenge/internal/logging"
+)
+
+const ContentType = "application/problem+json"
+
+// typeBase prefixes the code of every problem to build its type URI, clients should rely on the code instead
+const typeBase = "urn:sword-challenge:problem:"
+
+// Code identifies an error, codes are part of the API contract so they must never be renamed
+type Code string
+
+const (
+	CodeUnauthenticated      Code = "unauthenticated"
+	CodeForbidden            Code = "forbidden"
+	CodeInvalidBody          Code = "invalid_body"
+	CodeValidationFailed     Code = "validation_failed"
+	CodeInvalidQuery         Code = "invalid_query"
+	CodeInvalidTaskID        Code = "invalid_task_id"
+	CodeTaskNotFound         Code = "task_not_found"
+	CodeInvalidTransition    Code = "invalid_transition"
+	CodeTaskAlreadyAssigned  Code = "task_already_assigned"
+	CodePreconditionFailed   Code = "precondition_failed"
+	CodePreconditionRequired Code = "precondition_required"
+	CodeBatchAborted         Code = "batch_aborted"
+	CodeImportTooLarge       Code = "import_too_large"
+	CodeInvalidCommentID     Code = "invalid_comment_id"
+	CodeCommentNotFound      Code = "comment_not_found"
+	CodeInvalidAttachmentID  Code = "invalid_attachment_id"
+	CodeAttachmentNotFound   Code = "attachment_not_found"
+	CodeAttachmentTooLarge   Code = "attachment_too_large"
+	CodeInvalidScheduleID    Code = "invalid_schedule_id"
+	CodeScheduleNotFound     Code = "schedule_not_found"
+	CodeUnsupportedMedia     Code = "unsupported_media_type"
+	CodeRouteNotFound        Code = "route_not_found"
+	CodeMethodNotAllowed     Code = "method_not_allowed"
+	CodeInternal             Code = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object, Code and Errors are extensions
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError points to the invalid field of the request body using its JSON name, e.g. user.id
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func init() {
+	// Validation errors report the JSON names of the fields, which is what clients send, instead of the Go ones
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			if name == "" {
+				return field.Name
+			}
+			return name
+		})
+	}
+}
+
+func New(status int, code Code, detail string) *Problem {
+	return &Problem{Type: typeBase + string(code), Title: http.StatusText(status), Status: status, Detail: detail, Code: code}
+}
+
+// Write sends the problem and aborts the request so no other handler writes to the response
+func Write(c *gin.Context, p *Problem) {
+	if c.Request != nil {
+		p.Instance = c.Request.URL.Path
+	}
+	p.RequestID = c.Writer.Header().Get(logging.RequestIDHeader)
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, p)
+}
+
+func Abort(c *gin.Context, status int, code Code, detail string) {
+	Write(c, New(status, code, detail))
+}
+
+// Internal hides the cause from the client, it should be logged by the caller
+func Internal(c *gin.Context) {
+	Abort(c, http.StatusInternalServerError, CodeInternal, "An unexpected error occurred, try again later")
+}
+
+func Forbidden(c *gin.Context, detail string) {
+	Abort(c, http.StatusForbidden, CodeForbidden, detail)
+}
+
+// Binding turns the error returned by gin when binding a request body into a 400 problem, validation errors list every
+// invalid field
+func Binding(c *gin.Context, err error) {
+	Write(c, FromBinding(err))
+}
+
+// FromBinding is the problem Binding sends, for errors reported as part of a response
+func FromBinding(err error) *Problem {
+	var validationErrors validator.ValidationErrors
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validationErrors):
+		p := New(http.StatusBadRequest, CodeValidationFailed, "The request body has invalid fields")
+		for _, fe := range validationErrors {
+			p.Errors = append(p.Errors, FieldError{Field: fieldPath(fe), Code: fe.Tag(), Message: message(fe)})
+		}
+		return p
+	case errors.As(err, &typeError):
+		p := New(http.StatusBadRequest, CodeValidationFailed, "The request body has invalid fields")
+		p.Errors = []FieldError{{Field: typeError.Field, Code: "type", Message: fmt.Sprintf("must be a %s", typeError.Type.Kind())}}
+		return p
+	default:
+		return New(http.StatusBadRequest, CodeInvalidBody, "The request body is not valid JSON")
+	}
+}
+
+// Query turns the error returned by gin when binding the query string into a 400 problem, like Binding does for bodies
+func Query(c *gin.Context, err error) {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		Binding(c, err)
+		return
+	}
+	Abort(c, http.StatusBadRequest, CodeInvalidQuery, fmt.Sprintf("The query parameters are invalid: %v", err))
+}
+
+// fieldPath drops the name of the top level struct from the namespace, task.user.id becomes user.id
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func message(fe validator.FieldError) string {
+	unit := ""
+	if fe.Kind() == reflect.String {
+		unit = " characters"
+	}
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		return fmt.Sprintf("must be at most %s%s", fe.Param(), unit)
+	case "min":
+		return fmt.Sprintf("must be at least %s%s", fe.Param(), unit)
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", fe.Param())
+	default:
+		return "is invalid"
+	}
+}
@@ -0,0 +1,67 @@
+package problem
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type owner struct {
+	ID int `json:"id" binding:"required"`
+}
+
+type body struct {
+	Summary string `json:"summary" binding:"max=5"`
+	Owner   *owner `json:"user" binding:"required"`
+}
+
+func bind(t *testing.T, raw string) (*httptest.ResponseRecorder, Problem) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/tasks", strings.NewReader(raw))
+	c.Writer.Header().Set("X-Request-ID", "abc")
+
+	err := c.ShouldBindJSON(&body{})
+	assert.NotNil(t, err)
+	Binding(c, err)
+	c.Writer.Flush()
+
+	var p Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Failed to parse problem: %v", err)
+	}
+	return w, p
+}
+
+func TestValidationErrorsUseJSONFieldNames(t *testing.T) {
+	w, p := bind(t, `{"summary": "too long", "user": {}}`)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, CodeValidationFailed, p.Code)
+	assert.Equal(t, "/api/v1/tasks", p.Instance)
+	assert.Equal(t, "abc", p.RequestID)
+	assert.ElementsMatch(t, []FieldError{
+		{Field: "summary", Code: "max", Message: "must be at most 5 characters"},
+		{Field: "user.id", Code: "required", Message: "is required"},
+	}, p.Errors)
+}
+
+func TestTypeErrorsPointToTheField(t *testing.T) {
+	_, p := bind(t, `{"summary": 1, "user": {"id": 1}}`)
+
+	assert.Equal(t, CodeValidationFailed, p.Code)
+	assert.Equal(t, []FieldError{{Field: "summary", Code: "type", Message: "must be a string"}}, p.Errors)
+}
+
+func TestMalformedBody(t *testing.T) {
+	w, p := bind(t, `asdasd`)
+
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, CodeInvalidBody, p.Code)
+	assert.Empty(t, p.Errors)
+}
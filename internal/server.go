@@ -2,20 +2,26 @@ package internal
 
 import (
 	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/database/mysql"
 	"github.com/jmoiron/sqlx"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"go.uber.org/zap"
 	"net/http"
-	"os"
 	"os/signal"
 	"strconv"
-	serverAmqp "sword-challenge/internal/amqp"
+	"sword-challenge/internal/blob"
+	"sword-challenge/internal/config"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/notification"
+	"sword-challenge/internal/openapi"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/task"
+	"sword-challenge/internal/tracing"
 	"sword-challenge/internal/user"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -25,80 +31,133 @@ type SwordChallengeServer struct {
 	server              *http.Server
 	db                  *sqlx.DB
 	logger              *zap.SugaredLogger
+	config              *config.Config
+	bus                 eventbus.EventBus
+	notificationsTopic  string
 	userService         *user.Service
 	tasksService        *task.Service
-	notificationService *serverAmqp.Service
+	notificationService *notification.Service
+	spec                *openapi.Spec
+
+	// shuttingDown is set to 1 when the graceful shutdown starts so readiness starts failing
+	shuttingDown         int32
+	migrationVersionOnce sync.Once
+	migrationVersion     uint
+	migrationVersionErr  error
 }
 
-// NewServer setups the server routes and dependencies, everything is a bit too coupled so we have some funky logic to check whether we're using rabbit or not
-func NewServer(db *sqlx.DB, logger *zap.SugaredLogger, router *gin.Engine, rabbitCh *amqp.Channel, key string, queueName string) (*SwordChallengeServer, error) {
-	s := &SwordChallengeServer{db: db, router: router, logger: logger}
+// NewServer setups the server routes and dependencies, the bus can be backed by RabbitMQ or be in-memory when running without a broker
+func NewServer(db *sqlx.DB, logger *zap.SugaredLogger, router *gin.Engine, bus eventbus.EventBus, cfg *config.Config) (*SwordChallengeServer, error) {
+	if bus == nil {
+		return nil, fmt.Errorf("an event bus is required")
+	}
+	s := &SwordChallengeServer{db: db, router: router, logger: logger, config: cfg, bus: bus, notificationsTopic: cfg.Messaging.Queue}
 
 	s.userService = user.NewService(db, logger)
-
-	var not *serverAmqp.Service
-	if rabbitCh != nil {
-		notS, err := serverAmqp.NewService(rabbitCh, logger, queueName)
-		if err != nil {
-			return nil, err
-		}
-		not = notS
+	s.userService.TokenTTL = cfg.Auth.TokenTTL
+	s.notificationService = notification.NewService(logger)
+	s.tasksService = task.NewService(s.userService, db, bus, cfg.Messaging.Queue, logger, cfg.Crypto.AESKey, cfg.Crypto.PreviousKey, cfg.Crypto.SearchKey)
+	blobs, err := blob.New(cfg.Attachments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attachment storage: %w", err)
 	}
+	s.tasksService.Blobs = blobs
+	s.tasksService.MaxAttachmentSize = cfg.Attachments.MaxSize
+	s.tasksService.AttachmentTypes = cfg.Attachments.AllowedTypes
+	s.tasksService.RequireIfMatch = cfg.Server.RequireIfMatch
 
-	s.notificationService = not
-	pub := &serverAmqp.Publisher{RabbitChannel: rabbitCh, Logger: logger, NotificationsQueue: queueName}
-	s.tasksService = task.NewService(s.userService, db, pub, logger, key)
+	spec, err := openapi.Load()
+	if err != nil {
+		return nil, err
+	}
+	s.spec = spec
 
+	if err := metrics.RegisterDB(db.DB, cfg.Database.Name); err != nil {
+		return nil, fmt.Errorf("failed to register database metrics: %w", err)
+	}
 	return s, nil
 }
 
 func (s *SwordChallengeServer) SetupRoutes() {
+	s.router.Use(tracing.Middleware, metrics.Middleware)
 	publicAPI := s.router.Group("api/v1")
-	publicAPI.Use(gin.Logger())
+	publicAPI.Use(logging.Middleware(s.logger))
+	if s.spec != nil && s.config != nil && s.config.Server.ValidateAPI {
+		s.logger.Infow("Validating requests and responses against the OpenAPI document")
+		publicAPI.Use(s.spec.Validator(s.logger))
+	}
 	privateAPI := publicAPI.Group("")
 	privateAPI.Use(s.requireAuthentication)
 
-	publicAPI.GET("/health", func(c *gin.Context) {
-		c.Status(http.StatusOK)
-	})
+	// Kept for compatibility, same as /livez
+	publicAPI.GET("/health", s.livez)
+	s.router.GET("/livez", s.livez)
+	s.router.GET("/readyz", s.readyz)
+	s.router.GET("/metrics", metrics.Handler())
+	if s.spec != nil {
+		publicAPI.GET("/openapi.json", s.spec.ServeDocument)
+		publicAPI.GET("/docs", s.spec.ServeDocs)
+	}
 
 	s.userService.SetupRoutes(publicAPI)
 	s.tasksService.SetupRoutes(privateAPI)
+
+	s.router.NoRoute(func(c *gin.Context) {
+		problem.Abort(c, http.StatusNotFound, problem.CodeRouteNotFound, fmt.Sprintf("No route matches %s %s", c.Request.Method, c.Request.URL.Path))
+	})
 }
 
-func (s *SwordChallengeServer) RunMigrations() error {
-	driver, err := mysql.WithInstance(s.db.DB, &mysql.Config{})
-	if err != nil {
-		s.logger.Errorw("Failed to start go-migrate driver", "error", err)
-		return err
-	}
+// customMethodRoutes are the custom methods in the style of Google APIs, such as /tasks:batch, and the routes serving
+// them. gin takes a colon for the start of a parameter so they're rewritten before routing
+var customMethodRoutes = map[string]string{
+	openapi.BasePath + "/tasks:batch": openapi.BasePath + "/tasks/batch",
+}
 
-	m, err := migrate.NewWithDatabaseInstance(
-		"file://db/migrations",
-		os.Getenv("DB_NAME"), driver)
-	if err != nil {
-		s.logger.Errorw("Failed to start go-migrate migration instance", "error", err)
-		return err
-	}
-	err = m.Up()
-	if err != nil && err != migrate.ErrNoChange {
-		s.logger.Errorw("Failed to migrate database to latest version", "error", err)
-		return err
-	}
-	return nil
+// Handler serves the routes of the router and the custom methods
+func (s *SwordChallengeServer) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route, ok := customMethodRoutes[r.URL.Path]; ok {
+			r.URL.Path, r.URL.RawPath = route, ""
+		}
+		s.router.ServeHTTP(w, r)
+	})
 }
 
 func (s *SwordChallengeServer) StartWithGracefulShutdown(ctx context.Context, port int) error {
 	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	wg := &sync.WaitGroup{}
-	if s.notificationService != nil {
-		go s.notificationService.StartConsumer(ctx, wg)
-
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.bus.Subscribe(ctx, s.notificationsTopic, s.notificationService.Handle); err != nil {
+			s.logger.Errorw("Failed to consume notifications", "error", err)
+		}
+	}()
+	if s.config != nil && s.config.Scheduler.OverdueInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.tasksService.RunOverdueScheduler(ctx, s.config.Scheduler.OverdueInterval)
+		}()
+	}
+	if s.config != nil && s.config.Scheduler.PurgeInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.tasksService.RunTrashPurger(ctx, s.config.Scheduler.PurgeInterval, s.config.Scheduler.TrashRetention)
+		}()
+	}
+	if s.config != nil && s.config.Scheduler.RecurringInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.tasksService.RunRecurringScheduler(ctx, s.config.Scheduler.RecurringInterval, s.config.Scheduler.RecurringHorizon)
+		}()
 	}
 	defer stop()
 	s.server = &http.Server{
 		Addr:    ":" + strconv.Itoa(port),
-		Handler: s.router,
+		Handler: s.Handler(),
 	}
 
 	go func() {
@@ -111,16 +170,27 @@ func (s *SwordChallengeServer) StartWithGracefulShutdown(ctx context.Context, po
 
 	stop()
 	s.logger.Infow("Shutting server down gracefully, press Ctrl+C again to force")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := s.server.Shutdown(ctx); err != nil {
-		s.logger.Errorw("Failed to shut down server", "error", err)
+	delay, timeout := time.Duration(0), 5*time.Second
+	if s.config != nil {
+		delay, timeout = s.config.Server.ShutdownDelay, s.config.Server.ShutdownTimeout
 	}
+	s.shutdown(delay, timeout)
 
 	s.logger.Infow("Server closed successfully")
 	wg.Wait()
 	s.logger.Infow("Server dependencies closed successfully")
 	return nil
 }
+
+// shutdown fails the readiness probe for the delay, so the pod is taken out of the service before it stops accepting
+// connections, and then gives the requests in flight the timeout to finish
+func (s *SwordChallengeServer) shutdown(delay time.Duration, timeout time.Duration) {
+	atomic.StoreInt32(&s.shuttingDown, 1)
+	time.Sleep(delay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.logger.Errorw("Failed to shut down server", "error", err)
+	}
+}
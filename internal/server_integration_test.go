@@ -4,110 +4,160 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
-	"fmt"
+	"encoding/json"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/wait"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sword-challenge/internal/config"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/openapi"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/util"
 	"sync"
 	"testing"
 	"time"
 )
 
+// IntegrationTestSuite runs the whole server, with the notifications going through the in-memory event bus
 type IntegrationTestSuite struct {
 	suite.Suite
-	db              *sqlx.DB
-	sqlmock         sqlmock.Sqlmock
-	s               *httptest.Server
-	rabbitContainer *testcontainers.Container
+	db      *sqlx.DB
+	sqlmock sqlmock.Sqlmock
+	s       *httptest.Server
+	logs    *observer.ObservedLogs
+	server  *SwordChallengeServer
+	cancel  context.CancelFunc
+	wg      *sync.WaitGroup
 }
 
 func (s *IntegrationTestSuite) SetupSuite() {
 	db, mock, _ := sqlmock.New()
-	logger, _ := zap.NewDevelopment()
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core).Sugar()
 	sqlxDb := sqlx.NewDb(db, "mysql")
 	s.db = sqlxDb
 	s.sqlmock = mock
+	s.logs = logs
 
 	router := gin.New()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	s.T().Cleanup(cancel)
-	container, channel := startRabbitTestContainer(ctx)
-	s.rabbitContainer = &container
-	server, _ := NewServer(sqlx.NewDb(db, "mysql"), logger.Sugar(), router, channel, "6368616e676520746869732070617373", "tasks")
+	bus := eventbus.NewMemoryBus(logger, eventbus.DefaultConsumerConfig(), 10)
+	cfg := config.Default()
+	cfg.Crypto.AESKey = "6368616e676520746869732070617373"
+	cfg.Crypto.SearchKey = "7365617263682074686520696e646578"
+	cfg.Server.ValidateAPI = true
+	server, err := NewServer(sqlxDb, logger, router, bus, cfg)
+	if err != nil {
+		s.T().Fatalf("Failed to create server: %v", err)
+	}
 	server.SetupRoutes()
+	s.server = server
 
-	go server.notificationService.StartConsumer(ctx, &sync.WaitGroup{})
-	s.s = httptest.NewServer(server.router)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg = &sync.WaitGroup{}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		_ = bus.Subscribe(ctx, "tasks", server.notificationService.Handle)
+	}()
+	s.s = httptest.NewServer(server.Handler())
 }
 
 func (s *IntegrationTestSuite) TearDownSuite() {
-	err := (*s.rabbitContainer).Terminate(context.Background())
-	if err != nil {
-		fmt.Println("RabbitMQ container couldn't be shutdown after testing")
-	}
+	s.s.Close()
+	s.cancel()
+	s.wg.Wait()
 	_ = s.db.Close()
 }
 
 func (s *IntegrationTestSuite) TestNotificationsAreConsumedWhenTaskIsCompleted() {
 	client := &http.Client{}
-	body := bytes.NewReader([]byte(`{"id":1, "completedDate": "2021-10-23T22:50:23Z", "summary": "a", "user": {"id": 1, "username": "a"}}`))
+	body := bytes.NewReader([]byte(`{"status": "done"}`))
 
-	req, _ := http.NewRequest(http.MethodPut, s.s.URL+"/api/v1/tasks/1", body)
+	req, _ := http.NewRequest(http.MethodPost, s.s.URL+"/api/v1/tasks/1/transitions", body)
 	token := "123"
 	req.Header.Add(util.AuthHeader, token)
 
-	userRows := sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow("1", "joao", "technician", 2)
-	s.sqlmock.ExpectQuery(expectedFetchUserByTokenSQL).WithArgs(token).WillReturnRows(userRows)
+	userRows := sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow("1", "joel", "technician", 2)
+	s.sqlmock.ExpectQuery(expectedFetchUserByTokenSQL).WithArgs(token, sqlmock.AnyArg()).WillReturnRows(userRows)
 
-	rows := sqlmock.NewRows([]string{"id", "summary", "completed_date", "user.id", "user.username"}).AddRow(1, "1", nil, 1, "joel")
+	taskColumns := []string{"id", "summary", "status", "completed_date", "user.id", "user.username", "created_by.id", "created_by.username", "due_date", "priority", "estimated_minutes"}
+	hexBytes, _ := hex.DecodeString("85f57deac542185447ba16c29c284790cbd98c417abbef67323afd280bfa36ce")
+	rows := sqlmock.NewRows(taskColumns).AddRow(1, hexBytes, "in_progress", nil, 1, "joel", 2, "manager1", nil, "normal", nil)
 	s.sqlmock.ExpectQuery("SELECT").WillReturnRows(rows)
-	s.sqlmock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(5, 1))
+	s.sqlmock.ExpectBegin()
+	lockedRows := sqlmock.NewRows(taskColumns).AddRow(1, hexBytes, "in_progress", nil, 1, "joel", 2, "manager1", nil, "normal", nil)
+	s.sqlmock.ExpectQuery("SELECT .+ FOR UPDATE").WillReturnRows(lockedRows)
+	s.sqlmock.ExpectExec("UPDATE").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec("INSERT INTO task_transitions").WillReturnResult(sqlmock.NewResult(1, 1))
 	ti := time.Date(2011, 1, 1, 1, 1, 1, 1, time.UTC)
-	hexBytes, _ := hex.DecodeString("85f57deac542185447ba16c29c284790cbd98c417abbef67323afd280bfa36ce")
-	updatedRows := sqlmock.NewRows([]string{"id", "summary", "completed_date", "user.id", "user.username"}).AddRow(1, hexBytes, &ti, 5, "joel")
+	updatedRows := sqlmock.NewRows(taskColumns).AddRow(1, hexBytes, "done", &ti, 1, "joel", 2, "manager1", nil, "normal", nil)
 	s.sqlmock.ExpectQuery("SELECT").WillReturnRows(updatedRows)
+	s.sqlmock.ExpectExec("INSERT INTO task_history").WillReturnResult(sqlmock.NewResult(1, 2))
+	s.sqlmock.ExpectCommit()
 
 	managerRows := sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow("1", "manager1", "manager", 2).AddRow("2", "manager2", "manager", 2)
 	s.sqlmock.ExpectQuery("SELECT").WithArgs("manager").WillReturnRows(managerRows)
 
 	response, err := client.Do(req)
 	if err != nil {
-		s.T().Error(err)
+		s.T().Fatal(err)
 	}
 
 	assert.Equal(s.T(), 200, response.StatusCode)
 
-	//Because the notification has no side effect, there's nothing to assert here
-	// Maybe we could use a logger interface and mock it, but I don't think it's worth as this would have a side effect in a real life use case
+	// Every manager gets notified by the consumer
+	assert.Eventually(s.T(), func() bool {
+		return s.logs.FilterMessageSnippet("The tech joel performed the task 1").Len() == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(s.T(), 1, s.logs.FilterMessageSnippet("manager1:").Len())
+	assert.Equal(s.T(), 1, s.logs.FilterMessageSnippet("manager2:").Len())
+	assert.Equal(s.T(), 1, s.logs.FilterMessageSnippet("The task 1 was moved from in_progress to done by joel").Len())
+	assert.Zero(s.T(), s.logs.FilterMessage("Response does not match the OpenAPI document").Len())
 }
 
-func TestIntegrationTestSuite(t *testing.T) {
-	suite.Run(t, new(IntegrationTestSuite))
+func (s *IntegrationTestSuite) TestRequestsAreValidatedAgainstTheOpenAPIDocument() {
+	response, err := http.Post(s.s.URL+"/api/v1/login", "application/json", bytes.NewReader([]byte(`{"id": 0}`)))
+	if err != nil {
+		s.T().Fatal(err)
+	}
+	defer response.Body.Close()
+
+	assert.Equal(s.T(), 400, response.StatusCode)
+	var p problem.Problem
+	if err := json.NewDecoder(response.Body).Decode(&p); err != nil {
+		s.T().Fatal(err)
+	}
+	assert.Equal(s.T(), problem.CodeValidationFailed, p.Code)
+	assert.Equal(s.T(), "id", p.Errors[0].Field)
+	assert.Equal(s.T(), "minimum", p.Errors[0].Code)
 }
 
-func startRabbitTestContainer(ctx context.Context) (testcontainers.Container, *amqp.Channel) {
-	req := testcontainers.ContainerRequest{
-		Image:        "rabbitmq:3-management-alpine",
-		ExposedPorts: []string{"5672/tcp"},
-		WaitingFor:   wait.ForLog("Server startup complete"),
+func (s *IntegrationTestSuite) TestEveryAPIRouteIsDocumented() {
+	for _, route := range s.server.router.Routes() {
+		if strings.HasPrefix(route.Path, openapi.BasePath) {
+			assert.NotNil(s.T(), s.server.spec.Route(route.Method, route.Path), "%s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+	}
+
+	response, err := http.Get(s.s.URL + "/api/v1/openapi.json")
+	if err != nil {
+		s.T().Fatal(err)
 	}
-	rabbitC, _ := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
-		ContainerRequest: req,
-		Started:          true,
-	})
-
-	endpoint, _ := rabbitC.PortEndpoint(ctx, "5672", "")
-	conn, _ := amqp.Dial("amqp://guest:guest@" + endpoint)
-	rabbitChan, _ := conn.Channel()
-	return rabbitC, rabbitChan
+	defer response.Body.Close()
+	var document map[string]interface{}
+	assert.Nil(s.T(), json.NewDecoder(response.Body).Decode(&document))
+	assert.Equal(s.T(), "3.0.3", document["openapi"])
+}
+
+func TestIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(IntegrationTestSuite))
 }
@@ -7,6 +7,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/notification"
 	"sword-challenge/internal/task"
 	"sword-challenge/internal/user"
 	"testing"
@@ -20,18 +22,21 @@ func TestStartAndStopServer(t *testing.T) {
 	router := gin.New()
 	sqlxDB := sqlx.NewDb(db, "mysql")
 
-	pub := &task.LogPublisher{Logger: logger.Sugar()}
+	bus := eventbus.NewMemoryBus(logger.Sugar(), eventbus.DefaultConsumerConfig(), 10)
 	userService := user.NewService(sqlxDB, logger.Sugar())
 
-	tasksService := task.NewService(userService, sqlxDB, pub, logger.Sugar(), "6368616e676520746869732070617373")
+	tasksService := task.NewService(userService, sqlxDB, bus, "tasks", logger.Sugar(), "6368616e676520746869732070617373", "", "7365617263682074686520696e646578")
 
 	server := &SwordChallengeServer{
-		router:       router,
-		server:       nil,
-		db:           sqlxDB,
-		logger:       logger.Sugar(),
-		userService:  userService,
-		tasksService: tasksService,
+		router:              router,
+		server:              nil,
+		db:                  sqlxDB,
+		logger:              logger.Sugar(),
+		bus:                 bus,
+		notificationsTopic:  "tasks",
+		userService:         userService,
+		tasksService:        tasksService,
+		notificationService: notification.NewService(logger.Sugar()),
 	}
 	server.SetupRoutes()
 	ctx, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,330 @@
+package task
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+// attachmentField is the multipart field the file is uploaded in
+const attachmentField = "file"
+
+// sniffLength is how much of the file http.DetectContentType looks at
+const sniffLength = 512
+
+var errAttachmentTooLarge = errors.New("attachment is too large")
+
+type attachment struct {
+	ID          int        `json:"id"`
+	TaskID      int        `json:"taskId"`
+	Filename    string     `json:"filename"`
+	ContentType string     `json:"contentType"`
+	Size        int64      `json:"size"`
+	User        *user.User `json:"user"`
+	CreatedDate time.Time  `json:"createdDate"`
+}
+
+// encryptedAttachment is the metadata stored in the database, the encrypted file itself is in the blob store under the
+// storage key
+type encryptedAttachment struct {
+	ID                int        `db:"id"`
+	TaskID            int        `db:"task_id"`
+	EncryptedFilename []byte     `db:"filename"`
+	ContentType       string     `db:"content_type"`
+	Size              int64      `db:"size"`
+	StorageKey        string     `db:"storage_key"`
+	User              *user.User `db:"user"`
+	CreatedDate       time.Time  `db:"created_date"`
+}
+
+// limitedReader fails with errAttachmentTooLarge once more than max bytes are read, it counts how many were
+type limitedReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, errAttachmentTooLarge
+	}
+	return n, err
+}
+
+func (s *Service) getAttachments(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	encryptedAttachments, err := s.getAttachmentsFromStore(ctx, id)
+	if err != nil {
+		logger.Warnw("Failed to get attachments from storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	attachments := make([]attachment, len(encryptedAttachments))
+	for i, ea := range encryptedAttachments {
+		ea := ea
+		decryptedAttachment, err := s.taskEncryptor.decryptAttachment(ctx, &ea, currentUser.ID)
+		if err != nil {
+			logger.Warnw("Failed to decrypt attachment")
+			problem.Internal(c)
+			return
+		}
+		attachments[i] = *decryptedAttachment
+	}
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// uploadAttachment streams the file to the blob store as it's received, the type is detected from its first bytes
+// rather than trusting the client
+func (s *Service) uploadAttachment(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+
+	part, err := attachmentPart(c.Request)
+	if err != nil {
+		logger.Infow("Failed to read the attachment from the request", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidBody, fmt.Sprintf("The request must be multipart/form-data with the file in the %s field", attachmentField))
+		return
+	}
+	defer part.Close()
+
+	// FileName drops the directories of the name sent
+	filename := part.FileName()
+	if len(filename) > 255 {
+		p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The request body has invalid fields")
+		p.Errors = []problem.FieldError{{Field: attachmentField, Code: "filename", Message: "must have a name of at most 255 characters"}}
+		problem.Write(c, p)
+		return
+	}
+
+	src := bufio.NewReaderSize(part, sniffLength)
+	head, err := src.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		logger.Infow("Failed to read the attachment", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidBody, "The file could not be read")
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if !s.isAllowedAttachmentType(contentType) {
+		problem.Abort(c, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, fmt.Sprintf("Files of type %s can't be attached", contentType))
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	counted := &limitedReader{r: src, max: s.MaxAttachmentSize}
+	encrypted, err := s.taskEncryptor.encryptStream(counted)
+	if err != nil {
+		problem.Internal(c)
+		return
+	}
+	key := attachmentKey(id)
+	if err := s.Blobs.Put(ctx, key, encrypted); errors.Is(err, errAttachmentTooLarge) {
+		problem.Abort(c, http.StatusRequestEntityTooLarge, problem.CodeAttachmentTooLarge, fmt.Sprintf("Files can be at most %d bytes", s.MaxAttachmentSize))
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to store attachment", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	encryptedFilename, err := s.taskEncryptor.encrypt(ctx, []byte(filename))
+	if err != nil {
+		s.deleteBlob(c, key)
+		problem.Internal(c)
+		return
+	}
+	ea := &encryptedAttachment{TaskID: id, EncryptedFilename: encryptedFilename, ContentType: contentType, Size: counted.n, StorageKey: key,
+		User: &user.User{ID: currentUser.ID, Username: currentUser.Username}, CreatedDate: time.Now().UTC().Truncate(time.Second)}
+	ea.ID, err = s.addAttachmentToStore(ctx, ea)
+	if err != nil {
+		logger.Warnw("Failed to add attachment to storage", "taskId", id, "error", err)
+		s.deleteBlob(c, key)
+		problem.Internal(c)
+		return
+	}
+
+	c.JSON(http.StatusCreated, attachment{ID: ea.ID, TaskID: id, Filename: filename, ContentType: contentType, Size: ea.Size, User: ea.User, CreatedDate: ea.CreatedDate})
+}
+
+func (s *Service) downloadAttachment(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+	attachmentID, err := s.mustGetAttachmentID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+	ea, ok := s.mustGetAttachment(c, id, attachmentID)
+	if !ok {
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	a, err := s.taskEncryptor.decryptAttachment(ctx, ea, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt attachment")
+		problem.Internal(c)
+		return
+	}
+	file, err := s.Blobs.Get(ctx, ea.StorageKey)
+	if err != nil {
+		logger.Warnw("Failed to get attachment from the blob storage", "attachmentId", attachmentID, "error", err)
+		problem.Internal(c)
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", a.ContentType)
+	c.Header("Content-Length", strconv.FormatInt(a.Size, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+	// The status was already sent, a file that fails to decrypt can only be cut short
+	if _, err := io.Copy(c.Writer, s.taskEncryptor.decryptStream(file)); err != nil {
+		logger.Warnw("Failed to send attachment", "attachmentId", attachmentID, "error", err)
+	}
+}
+
+func (s *Service) deleteAttachment(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+	attachmentID, err := s.mustGetAttachmentID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+	ea, ok := s.mustGetAttachment(c, id, attachmentID)
+	if !ok {
+		return
+	}
+	if ea.User.ID != currentUser.ID && currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only who uploaded the attachment or a manager can delete it")
+		return
+	}
+
+	if err := s.deleteAttachmentFromStore(util.RequestContext(c), attachmentID); err != nil {
+		logger.Warnw("Failed to delete attachment", "attachmentId", attachmentID, "error", err)
+		problem.Internal(c)
+		return
+	}
+	s.deleteBlob(c, ea.StorageKey)
+
+	c.Status(http.StatusOK)
+}
+
+// attachmentPart returns the part with the file, the parts before it are skipped without being buffered
+func attachmentPart(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == attachmentField && part.FileName() != "" {
+			return part, nil
+		}
+	}
+}
+
+func (s *Service) isAllowedAttachmentType(contentType string) bool {
+	for _, allowed := range s.AttachmentTypes {
+		if allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// attachmentKey is a new key in the blob storage for a file of the task
+func attachmentKey(taskID int) string {
+	return fmt.Sprintf("tasks/%d/%s", taskID, uuid.NewString())
+}
+
+// deleteBlob removes a file whose metadata is gone, failures are only logged and leave an orphan file behind
+func (s *Service) deleteBlob(c *gin.Context, key string) {
+	if err := s.Blobs.Delete(util.RequestContext(c), key); err != nil {
+		logging.FromContext(util.RequestContext(c), s.logger).Warnw("Failed to delete attachment from the blob storage", "key", key, "error", err)
+	}
+}
+
+func (s *Service) mustGetAttachmentID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("attachment-id"))
+	if err != nil || id <= 0 {
+		s.requestLogger(c).Infow("Failed to parse attachment ID", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidAttachmentID, "The attachment ID must be a positive number")
+		return 0, fmt.Errorf("invalid attachment ID %q", c.Param("attachment-id"))
+	}
+	return id, nil
+}
+
+// mustGetAttachment fetches an attachment of the task, otherwise the request is aborted with the matching problem
+func (s *Service) mustGetAttachment(c *gin.Context, taskID int, id int) (*encryptedAttachment, bool) {
+	logger := s.requestLogger(c)
+	ea, err := s.getAttachmentFromStore(util.RequestContext(c), taskID, id)
+	if err == sql.ErrNoRows {
+		logger.Infow("Failed to find attachment", "taskId", taskID, "attachmentId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeAttachmentNotFound, fmt.Sprintf("Attachment %d does not exist on task %d", id, taskID))
+		return nil, false
+	} else if err != nil {
+		logger.Infow("Failed to get attachment", "attachmentId", id, "error", err)
+		problem.Internal(c)
+		return nil, false
+	}
+	return ea, true
+}
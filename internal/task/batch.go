@@ -0,0 +1,340 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/jmoiron/sqlx"
+	"net/http"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+)
+
+const (
+	batchAtomic     = "atomic"
+	batchBestEffort = "bestEffort"
+
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+type batchRequest struct {
+	// Mode is atomic by default, either every operation is applied or none is. In bestEffort each one is applied on its
+	// own and the ones that fail don't stop the others
+	Mode       string           `json:"mode" binding:"omitempty,oneof=atomic bestEffort"`
+	Operations []batchOperation `json:"operations" binding:"required,min=1,max=100,dive"`
+}
+
+// batchOperation creates a task from Task, updates the task with the ID using Task as a merge patch or deletes it.
+// Updates can also change the status and the assignee, like the transitions and assignment endpoints
+type batchOperation struct {
+	Op      string          `json:"op" binding:"required,oneof=create update delete"`
+	ID      int             `json:"id" binding:"omitempty,min=1"`
+	IfMatch string          `json:"ifMatch"`
+	Task    json.RawMessage `json:"task"`
+}
+
+// batchResult is the outcome of an operation, Status is the one the single task endpoint would have answered with
+type batchResult struct {
+	Status  int              `json:"status"`
+	ID      int              `json:"id,omitempty"`
+	ETag    string           `json:"etag,omitempty"`
+	Task    *task            `json:"task,omitempty"`
+	Problem *problem.Problem `json:"problem,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// batchTasks applies several operations at once for managers, events are published once the operations that caused
+// them are committed
+func (s *Service) batchTasks(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can change tasks in batches")
+		return
+	}
+
+	request := &batchRequest{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		logger.Infow("Failed to parse batch request body", "error", err)
+		problem.Binding(c, err)
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	var results []batchResult
+	var events []func()
+	var err error
+	if request.Mode == batchBestEffort {
+		results, events, err = s.applyBestEffortBatch(ctx, request.Operations, currentUser)
+	} else {
+		results, events, err = s.applyAtomicBatch(ctx, request.Operations, currentUser)
+	}
+	if err != nil {
+		logger.Warnw("Failed to apply batch in storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	for _, publish := range events {
+		publish()
+	}
+	c.JSON(http.StatusOK, batchResponse{Results: results})
+}
+
+// applyAtomicBatch applies the operations in one transaction, when one fails the others are rolled back and answered
+// with 424
+func (s *Service) applyAtomicBatch(ctx context.Context, operations []batchOperation, actor *user.User) ([]batchResult, []func(), error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]batchResult, len(operations))
+	var events []func()
+	for i, op := range operations {
+		result, opEvents := s.applyOperationInTx(ctx, tx, op, actor)
+		if result.Problem == nil {
+			results[i] = result
+			events = append(events, opEvents...)
+			continue
+		}
+
+		for j := range results {
+			results[j] = batchResult{Status: http.StatusFailedDependency, ID: operations[j].ID,
+				Problem: problem.New(http.StatusFailedDependency, problem.CodeBatchAborted, fmt.Sprintf("Operation %d failed so none was applied", i))}
+		}
+		results[i] = result
+		return results, nil, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return results, events, nil
+}
+
+// applyBestEffortBatch applies each operation in its own transaction
+func (s *Service) applyBestEffortBatch(ctx context.Context, operations []batchOperation, actor *user.User) ([]batchResult, []func(), error) {
+	results := make([]batchResult, len(operations))
+	var events []func()
+	for i, op := range operations {
+		tx, err := s.db.BeginTxx(ctx, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		result, opEvents := s.applyOperationInTx(ctx, tx, op, actor)
+		if result.Problem != nil {
+			_ = tx.Rollback()
+		} else if err := tx.Commit(); err != nil {
+			logging.FromContext(ctx, s.logger).Warnw("Failed to commit batch operation", "index", i, "error", err)
+			result = internalBatchResult(op)
+		} else {
+			events = append(events, opEvents...)
+		}
+		results[i] = result
+	}
+	return results, events, nil
+}
+
+// applyOperationInTx applies the operation and returns its result and the events to publish once it's committed.
+// Failures are reported in the result, the transaction must be rolled back when there's a problem
+func (s *Service) applyOperationInTx(ctx context.Context, tx *sqlx.Tx, op batchOperation, actor *user.User) (batchResult, []func()) {
+	if op.Op != batchCreate && op.ID == 0 {
+		return invalidBatchField(op, "id", "required", "is required"), nil
+	}
+	var ifVersion *int
+	if op.IfMatch != "" && op.IfMatch != "*" {
+		ifVersion = parseIfMatch(op.IfMatch)
+	} else if op.IfMatch == "" && op.Op != batchCreate && s.RequireIfMatch {
+		return batchResult{Status: http.StatusPreconditionRequired, ID: op.ID,
+			Problem: problem.New(http.StatusPreconditionRequired, problem.CodePreconditionRequired, "The ifMatch with the ETag of the task is required")}, nil
+	}
+
+	switch op.Op {
+	case batchCreate:
+		return s.createTaskInBatch(ctx, tx, op, actor), nil
+	case batchUpdate:
+		return s.updateTaskInBatch(ctx, tx, op, ifVersion, actor)
+	default:
+		return s.deleteTaskInBatch(ctx, tx, op, ifVersion, actor), nil
+	}
+}
+
+func (s *Service) createTaskInBatch(ctx context.Context, tx *sqlx.Tx, op batchOperation, actor *user.User) batchResult {
+	if op.Task == nil {
+		return invalidBatchField(op, "task", "required", "is required")
+	}
+	receivedTask := &task{}
+	if err := json.Unmarshal(op.Task, receivedTask); err != nil {
+		return batchResult{Status: http.StatusBadRequest, Problem: problem.FromBinding(err)}
+	}
+	if err := binding.Validator.ValidateStruct(receivedTask); err != nil {
+		return batchResult{Status: http.StatusBadRequest, Problem: problem.FromBinding(err)}
+	}
+	// Managers create the tasks so the ones without a user go to the unassigned pool
+	receivedTask.CreatedBy = &user.User{ID: actor.ID, Username: actor.Username}
+	if receivedTask.Priority == "" {
+		receivedTask.Priority = PriorityNormal
+	}
+
+	et, err := s.encryptTask(ctx, receivedTask)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Warnw("Failed to encrypt task")
+		return internalBatchResult(op)
+	}
+	id, err := addTaskInTx(ctx, tx, et)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Warnw("Failed to add task to storage", "error", err)
+		return internalBatchResult(op)
+	}
+
+	receivedTask.ID = id
+	receivedTask.User = presentUser(receivedTask.User)
+	receivedTask.Status = StatusTodo
+	receivedTask.CompletedDate = nil
+	receivedTask.DeletedAt = nil
+	return batchResult{Status: http.StatusCreated, ID: id, ETag: etag(1), Task: receivedTask}
+}
+
+// updateTaskInBatch applies the merge patch to the editable fields, then moves the task to the status of the patch and
+// assigns it to its user, each of them is recorded in the history like the single task endpoints do
+func (s *Service) updateTaskInBatch(ctx context.Context, tx *sqlx.Tx, op batchOperation, ifVersion *int, actor *user.User) (batchResult, []func()) {
+	receivedTask, patch, err := decodePatch(op.Task)
+	if err == errPatchNotAnObject {
+		return batchResult{Status: http.StatusBadRequest, ID: op.ID,
+			Problem: problem.New(http.StatusBadRequest, problem.CodeInvalidBody, "The task of an update must be a JSON object")}, nil
+	} else if err == errSummaryCleared {
+		return invalidBatchField(op, "summary", "required", "can't be cleared"), nil
+	} else if err != nil {
+		return batchResult{Status: http.StatusBadRequest, ID: op.ID, Problem: problem.FromBinding(err)}, nil
+	}
+	if _, ok := patch["completedDate"]; ok {
+		return invalidBatchField(op, "completedDate", "readonly", "is changed through the status"), nil
+	}
+
+	_, changesStatus := patch["status"]
+	if changesStatus {
+		if err := binding.Validator.ValidateStruct(&transitionRequest{Status: receivedTask.Status}); err != nil {
+			return batchResult{Status: http.StatusBadRequest, ID: op.ID, Problem: problem.FromBinding(err)}, nil
+		}
+	}
+	_, changesAssignee := patch["user"]
+	var assignee *user.User
+	if changesAssignee && receivedTask.User != nil {
+		assignee, err = s.userService.GetUserByID(ctx, receivedTask.User.ID)
+		if err == sql.ErrNoRows {
+			return invalidBatchField(op, "user.id", "exists", fmt.Sprintf("user %d does not exist", receivedTask.User.ID)), nil
+		} else if err != nil {
+			logging.FromContext(ctx, s.logger).Warnw("Failed to get assignee", "userId", receivedTask.User.ID, "error", err)
+			return internalBatchResult(op), nil
+		}
+	}
+
+	current, err := lockTaskInTx(ctx, tx, op.ID)
+	if err == sql.ErrNoRows {
+		return taskNotFoundBatchResult(op), nil
+	} else if err != nil {
+		logging.FromContext(ctx, s.logger).Warnw("Failed to lock task in storage", "taskId", op.ID, "error", err)
+		return internalBatchResult(op), nil
+	}
+	if ifVersion != nil && current.Version != *ifVersion {
+		return batchResult{Status: http.StatusPreconditionFailed, ID: op.ID,
+			Problem: problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, fmt.Sprintf("Task %d changed since it was read, get it again", op.ID))}, nil
+	}
+
+	var fields []string
+	for _, field := range editableFields {
+		if _, ok := patch[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	var events []func()
+	if len(fields) > 0 {
+		receivedTask.ID = op.ID
+		if receivedTask.Priority == "" {
+			receivedTask.Priority = PriorityNormal
+		}
+		et, err := s.encryptTask(ctx, receivedTask)
+		if err != nil {
+			logging.FromContext(ctx, s.logger).Warnw("Failed to encrypt task")
+			return internalBatchResult(op), nil
+		}
+		if current, err = changeTaskInTx(ctx, tx, op.ID, nil, actor.ID, historyUpdated, setTaskFields(ctx, et, fields)); err != nil {
+			logging.FromContext(ctx, s.logger).Warnw("Failed to update task in storage", "taskId", op.ID, "error", err)
+			return internalBatchResult(op), nil
+		}
+	}
+	if changesStatus && receivedTask.Status != current.Status {
+		from := current.Status
+		if !canTransition(actor.Role.Name, from, receivedTask.Status) {
+			return batchResult{Status: http.StatusConflict, ID: op.ID,
+				Problem: problem.New(http.StatusConflict, problem.CodeInvalidTransition, fmt.Sprintf("A %s can't move a task from %s to %s", actor.Role.Name, from, receivedTask.Status))}, nil
+		}
+		if current, err = changeTaskInTx(ctx, tx, op.ID, nil, actor.ID, historyTransitioned, setTaskStatus(ctx, current, receivedTask.Status, actor.ID)); err != nil {
+			logging.FromContext(ctx, s.logger).Warnw("Failed to transition task in storage", "taskId", op.ID, "error", err)
+			return internalBatchResult(op), nil
+		}
+		transitioned := *current
+		events = append(events, func() { s.publishTransition(ctx, transitioned, from, actor) })
+	}
+	if changesAssignee {
+		if current, err = changeTaskInTx(ctx, tx, op.ID, nil, actor.ID, historyAssigned, setTaskAssignee(ctx, op.ID, assignee)); err != nil {
+			logging.FromContext(ctx, s.logger).Warnw("Failed to assign task in storage", "taskId", op.ID, "error", err)
+			return internalBatchResult(op), nil
+		}
+		if assignee != nil {
+			assigned := *current
+			events = append(events, func() { s.publishAssignment(ctx, assigned, actor) })
+		}
+	}
+
+	decryptedTask, err := s.taskEncryptor.decryptTask(ctx, current, actor.ID)
+	if err != nil {
+		logging.FromContext(ctx, s.logger).Warnw("Failed to decrypt task")
+		return internalBatchResult(op), nil
+	}
+	return batchResult{Status: http.StatusOK, ID: op.ID, ETag: etag(current.Version), Task: decryptedTask}, events
+}
+
+func (s *Service) deleteTaskInBatch(ctx context.Context, tx *sqlx.Tx, op batchOperation, ifVersion *int, actor *user.User) batchResult {
+	deleted, err := deleteTaskInTx(ctx, tx, op.ID, ifVersion, actor.ID)
+	if err == errVersionMismatch {
+		return batchResult{Status: http.StatusPreconditionFailed, ID: op.ID,
+			Problem: problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, fmt.Sprintf("Task %d changed since it was read, get it again", op.ID))}
+	} else if err != nil {
+		logging.FromContext(ctx, s.logger).Warnw("Failed to delete task in storage", "taskId", op.ID, "error", err)
+		return internalBatchResult(op)
+	} else if deleted == 0 {
+		return taskNotFoundBatchResult(op)
+	}
+	return batchResult{Status: http.StatusOK, ID: op.ID}
+}
+
+func invalidBatchField(op batchOperation, field string, code string, message string) batchResult {
+	p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The operation has invalid fields")
+	p.Errors = []problem.FieldError{{Field: field, Code: code, Message: message}}
+	return batchResult{Status: http.StatusBadRequest, ID: op.ID, Problem: p}
+}
+
+func taskNotFoundBatchResult(op batchOperation) batchResult {
+	return batchResult{Status: http.StatusNotFound, ID: op.ID,
+		Problem: problem.New(http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", op.ID))}
+}
+
+// internalBatchResult hides the cause of the failure like problem.Internal, it should be logged by the caller
+func internalBatchResult(op batchOperation) batchResult {
+	return batchResult{Status: http.StatusInternalServerError, ID: op.ID,
+		Problem: problem.New(http.StatusInternalServerError, problem.CodeInternal, "An unexpected error occurred, try again later")}
+}
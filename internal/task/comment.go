@@ -0,0 +1,292 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+// mentionPattern matches @username when it's not part of a word or an e-mail address, a trailing dot ends the sentence
+// instead of being part of the username
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]*\w)`)
+
+type comment struct {
+	ID          int        `json:"id"`
+	TaskID      int        `json:"taskId"`
+	Body        string     `json:"body"`
+	User        *user.User `json:"user"`
+	CreatedDate time.Time  `json:"createdDate"`
+	UpdatedDate *time.Time `json:"updatedDate"`
+}
+
+type encryptedComment struct {
+	ID            int        `db:"id"`
+	TaskID        int        `db:"task_id"`
+	EncryptedBody []byte     `db:"body"`
+	User          *user.User `db:"user"`
+	CreatedDate   time.Time  `db:"created_date"`
+	UpdatedDate   *time.Time `db:"updated_date"`
+}
+
+type commentRequest struct {
+	Body string `json:"body" binding:"required,max=2500"`
+}
+
+// mentions returns the usernames mentioned in the body, without duplicates
+func mentions(body string) []string {
+	var usernames []string
+	seen := map[string]bool{}
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			usernames = append(usernames, match[1])
+		}
+	}
+	return usernames
+}
+
+func (s *Service) getComments(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	encryptedComments, err := s.getCommentsFromStore(ctx, id)
+	if err != nil {
+		logger.Warnw("Failed to get comments from storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	comments := make([]comment, len(encryptedComments))
+	for i, ec := range encryptedComments {
+		ec := ec
+		decryptedComment, err := s.taskEncryptor.decryptComment(ctx, &ec, currentUser.ID)
+		if err != nil {
+			logger.Warnw("Failed to decrypt comment")
+			problem.Internal(c)
+			return
+		}
+		comments[i] = *decryptedComment
+	}
+
+	c.JSON(http.StatusOK, comments)
+}
+
+func (s *Service) createComment(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	request := &commentRequest{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		logger.Infow("Failed to parse comment request body", "error", err)
+		problem.Binding(c, err)
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	t, ok := s.mustGetOwnTask(c, id, currentUser)
+	if !ok {
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	newComment := &comment{TaskID: id, Body: request.Body, User: &user.User{ID: currentUser.ID, Username: currentUser.Username}, CreatedDate: time.Now().UTC().Truncate(time.Second)}
+	ec, err := s.taskEncryptor.encryptComment(ctx, newComment)
+	if err != nil {
+		logger.Warnw("Failed to encrypt comment")
+		problem.Internal(c)
+		return
+	}
+
+	newComment.ID, err = s.addCommentToStore(ctx, ec)
+	if err != nil {
+		logger.Warnw("Failed to add comment to storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	s.notifyMentions(ctx, t, newComment, mentions(newComment.Body), currentUser)
+	c.JSON(http.StatusCreated, newComment)
+}
+
+func (s *Service) updateComment(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+	commentID, err := s.mustGetCommentID(c)
+	if err != nil {
+		return
+	}
+
+	request := &commentRequest{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		logger.Infow("Failed to parse comment request body", "error", err)
+		problem.Binding(c, err)
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	t, ok := s.mustGetOwnTask(c, id, currentUser)
+	if !ok {
+		return
+	}
+	existing, ok := s.mustGetComment(c, id, commentID)
+	if !ok {
+		return
+	}
+	if existing.User.ID != currentUser.ID {
+		problem.Forbidden(c, "Only the author can edit a comment")
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	previous, err := s.taskEncryptor.decryptComment(ctx, existing, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt comment")
+		problem.Internal(c)
+		return
+	}
+
+	updatedDate := time.Now().UTC().Truncate(time.Second)
+	editedComment := &comment{ID: existing.ID, TaskID: id, Body: request.Body, User: existing.User, CreatedDate: existing.CreatedDate, UpdatedDate: &updatedDate}
+	ec, err := s.taskEncryptor.encryptComment(ctx, editedComment)
+	if err != nil {
+		logger.Warnw("Failed to encrypt comment")
+		problem.Internal(c)
+		return
+	}
+
+	if err := s.updateCommentInStore(ctx, ec); err != nil {
+		logger.Warnw("Failed to update comment in storage", "commentId", commentID, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	// Users that were already mentioned were notified when the comment was created
+	alreadyMentioned := map[string]bool{}
+	for _, username := range mentions(previous.Body) {
+		alreadyMentioned[username] = true
+	}
+	var newMentions []string
+	for _, username := range mentions(editedComment.Body) {
+		if !alreadyMentioned[username] {
+			newMentions = append(newMentions, username)
+		}
+	}
+	s.notifyMentions(ctx, t, editedComment, newMentions, currentUser)
+
+	c.JSON(http.StatusOK, editedComment)
+}
+
+func (s *Service) deleteComment(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+	commentID, err := s.mustGetCommentID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+	existing, ok := s.mustGetComment(c, id, commentID)
+	if !ok {
+		return
+	}
+	if existing.User.ID != currentUser.ID && currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only the author or a manager can delete a comment")
+		return
+	}
+
+	if err := s.deleteCommentFromStore(util.RequestContext(c), commentID); err != nil {
+		logger.Warnw("Failed to delete comment", "commentId", commentID, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Service) mustGetCommentID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("comment-id"))
+	if err != nil || id <= 0 {
+		s.requestLogger(c).Infow("Failed to parse comment ID", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidCommentID, "The comment ID must be a positive number")
+		return 0, fmt.Errorf("invalid comment ID %q", c.Param("comment-id"))
+	}
+	return id, nil
+}
+
+// mustGetComment fetches a comment of the task, otherwise the request is aborted with the matching problem
+func (s *Service) mustGetComment(c *gin.Context, taskID int, id int) (*encryptedComment, bool) {
+	logger := s.requestLogger(c)
+	ec, err := s.getCommentFromStore(util.RequestContext(c), taskID, id)
+	if err == sql.ErrNoRows {
+		logger.Infow("Failed to find comment", "taskId", taskID, "commentId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeCommentNotFound, fmt.Sprintf("Comment %d does not exist on task %d", id, taskID))
+		return nil, false
+	} else if err != nil {
+		logger.Infow("Failed to get comment", "commentId", id, "error", err)
+		problem.Internal(c)
+		return nil, false
+	}
+	return ec, true
+}
+
+// notifyMentions publishes a mention to every mentioned user that can see the task, the assignee and managers. Other
+// usernames are ignored, the comment was already stored so failures are only logged
+func (s *Service) notifyMentions(ctx context.Context, t *encryptedTask, c *comment, usernames []string, author *user.User) {
+	if len(usernames) == 0 {
+		return
+	}
+	logger := logging.FromContext(ctx, s.logger)
+	users, err := s.userService.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		logger.Warnw("Failed to get mentioned users", "commentId", c.ID, "error", err)
+		return
+	}
+
+	assignee := presentUser(t.User)
+	var mentioned []user.User
+	for _, u := range users {
+		isManager := u.Role != nil && u.Role.Name == util.AdminRole
+		if u.ID == author.ID {
+			continue
+		} else if !isManager && (assignee == nil || assignee.ID != u.ID) {
+			logger.Infow("Ignoring mention of a user who can't see the task", "commentId", c.ID, "userId", u.ID)
+			continue
+		}
+		mentioned = append(mentioned, user.User{ID: u.ID, Username: u.Username})
+	}
+	s.publishMentions(ctx, *c, mentioned, author)
+}
@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -8,6 +9,9 @@ import (
 	"fmt"
 	"go.uber.org/zap"
 	"io"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/tracing"
 	"sword-challenge/internal/user"
 	"time"
 )
@@ -16,13 +20,27 @@ type taskCrypto struct {
 	gcm    cipher.AEAD
 	logger *zap.SugaredLogger
 	ivSize int
+	// previous decrypts what is still encrypted with the key being rotated out, it's nil outside of a rotation
+	previous cipher.AEAD
 }
 
 type encryptedTask struct {
 	ID               int
 	EncryptedSummary []byte     `db:"summary"`
+	Status           Status     `db:"status"`
 	CompletedDate    *time.Time `db:"completed_date"`
 	User             *user.User `db:"user"`
+	CreatedBy        *user.User `db:"created_by"`
+	DueDate          *time.Time `db:"due_date"`
+	Priority         Priority   `db:"priority"`
+	EstimatedMinutes *int       `db:"estimated_minutes"`
+	// Version is incremented on every change of the task
+	Version int `db:"version"`
+	// ScheduleID and ScheduledFor are the schedule and occurrence the task was created for, they're only set on creation
+	ScheduleID   *int       `db:"schedule_id"`
+	ScheduledFor *time.Time `db:"scheduled_for"`
+	// SearchTokens are the search index tokens of the summary, they're only set when the summary was just encrypted
+	SearchTokens [][]byte `db:"-"`
 }
 
 func NewCrypto(key string, logger *zap.SugaredLogger) (*taskCrypto, error) {
@@ -46,30 +64,91 @@ func NewCrypto(key string, logger *zap.SugaredLogger) (*taskCrypto, error) {
 	return &taskCrypto{gcm: aesgcm, logger: logger, ivSize: 12}, nil
 }
 
-func (s *taskCrypto) encryptTask(t *task) (*encryptedTask, error) {
+// newRotatingCrypto encrypts with the key and decrypts with both keys, see Service.RotateKey
+func newRotatingCrypto(key string, previousKey string, logger *zap.SugaredLogger) (*taskCrypto, error) {
+	c, err := NewCrypto(key, logger)
+	if err != nil || previousKey == "" {
+		return c, err
+	}
+	previous, err := NewCrypto(previousKey, logger)
+	if err != nil {
+		return nil, err
+	}
+	c.previous = previous.gcm
+	return c, nil
+}
+
+// encrypt seals the plaintext with a random nonce, the nonce is prepended to the ciphertext
+func (s *taskCrypto) encrypt(ctx context.Context, plaintext []byte) (ciphertext []byte, err error) {
+	_, span := tracing.Start(ctx, "taskCrypto.encrypt")
+	defer func() {
+		metrics.CryptoOperation("encrypt", err)
+		tracing.End(span, err)
+	}()
+
 	nonce := make([]byte, s.ivSize)
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		s.logger.Warnw("Failed to generate nonce")
 		return nil, err
 	}
-
-	et := encryptedTask{ID: t.ID, CompletedDate: t.CompletedDate, User: t.User}
-	et.EncryptedSummary = s.gcm.Seal(nonce, nonce, []byte(t.Summary), nil)
-	return &et, nil
+	return s.gcm.Seal(nonce, nonce, plaintext, nil), nil
 }
 
-func (s *taskCrypto) decryptTask(et *encryptedTask, userId int) (*task, error) {
-	s.logger.Infow("Task decryption requested", "taskId", et.ID, "userId", userId)
+func (s *taskCrypto) decrypt(ctx context.Context, ciphertext []byte) (plaintext []byte, err error) {
+	_, span := tracing.Start(ctx, "taskCrypto.decrypt")
+	defer func() {
+		metrics.CryptoOperation("decrypt", err)
+		tracing.End(span, err)
+	}()
 
-	if len(et.EncryptedSummary) < s.ivSize {
+	if len(ciphertext) < s.ivSize {
 		s.logger.Warnw("Failed to parse nonce")
 		return nil, fmt.Errorf("failed to parse nonce")
 	}
 
-	nonce, ciphertext := et.EncryptedSummary[:s.ivSize], et.EncryptedSummary[s.ivSize:]
+	nonce, ciphertext := ciphertext[:s.ivSize], ciphertext[s.ivSize:]
+	plaintext, err = s.gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil && s.previous != nil {
+		plaintext, err = s.previous.Open(nil, nonce, ciphertext, nil)
+	}
+	if err != nil {
+		s.logger.Warnw("Failed to decrypt ciphertext")
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// isCurrent tells whether the ciphertext is encrypted with the current key, nil values have nothing to re-encrypt so
+// they count as current
+func (s *taskCrypto) isCurrent(ciphertext []byte) bool {
+	if ciphertext == nil {
+		return true
+	}
+	if len(ciphertext) < s.ivSize {
+		return false
+	}
+	_, err := s.gcm.Open(nil, ciphertext[:s.ivSize], ciphertext[s.ivSize:], nil)
+	return err == nil
+}
+
+func (s *taskCrypto) encryptTask(ctx context.Context, t *task) (*encryptedTask, error) {
+	encryptedSummary, err := s.encrypt(ctx, []byte(t.Summary))
+	if err != nil {
+		return nil, err
+	}
+
+	et := encryptedTask{ID: t.ID, Status: t.Status, CompletedDate: t.CompletedDate, User: t.User, CreatedBy: t.CreatedBy,
+		DueDate: t.DueDate, Priority: t.Priority, EstimatedMinutes: t.EstimatedMinutes}
+	et.EncryptedSummary = encryptedSummary
+	return &et, nil
+}
 
-	t := task{ID: et.ID, CompletedDate: et.CompletedDate, User: et.User}
-	decryptedSummary, err := s.gcm.Open(nil, nonce, ciphertext, nil)
+func (s *taskCrypto) decryptTask(ctx context.Context, et *encryptedTask, userId int) (*task, error) {
+	logging.FromContext(ctx, s.logger).Infow("Task decryption requested", "taskId", et.ID, "userId", userId)
+
+	t := task{ID: et.ID, Status: et.Status, CompletedDate: et.CompletedDate, User: presentUser(et.User), CreatedBy: presentUser(et.CreatedBy),
+		DueDate: et.DueDate, Priority: et.Priority, EstimatedMinutes: et.EstimatedMinutes}
+	decryptedSummary, err := s.decrypt(ctx, et.EncryptedSummary)
 	if err != nil {
 		s.logger.Warnw("Failed to decrypt summary")
 		return nil, err
@@ -78,3 +157,80 @@ func (s *taskCrypto) decryptTask(et *encryptedTask, userId int) (*task, error) {
 	t.Summary = string(decryptedSummary)
 	return &t, nil
 }
+
+func (s *taskCrypto) encryptComment(ctx context.Context, c *comment) (*encryptedComment, error) {
+	encryptedBody, err := s.encrypt(ctx, []byte(c.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptedComment{ID: c.ID, TaskID: c.TaskID, EncryptedBody: encryptedBody, User: c.User, CreatedDate: c.CreatedDate, UpdatedDate: c.UpdatedDate}, nil
+}
+
+// decryptComment logs who read the comment, like decryptTask does for summaries
+func (s *taskCrypto) decryptComment(ctx context.Context, ec *encryptedComment, userId int) (*comment, error) {
+	logging.FromContext(ctx, s.logger).Infow("Comment decryption requested", "taskId", ec.TaskID, "commentId", ec.ID, "userId", userId)
+
+	decryptedBody, err := s.decrypt(ctx, ec.EncryptedBody)
+	if err != nil {
+		s.logger.Warnw("Failed to decrypt comment")
+		return nil, err
+	}
+	return &comment{ID: ec.ID, TaskID: ec.TaskID, Body: string(decryptedBody), User: ec.User, CreatedDate: ec.CreatedDate, UpdatedDate: ec.UpdatedDate}, nil
+}
+
+// decryptAttachment decrypts the metadata of the attachment, the file itself is decrypted as it's sent with decryptStream
+func (s *taskCrypto) decryptAttachment(ctx context.Context, ea *encryptedAttachment, userId int) (*attachment, error) {
+	logging.FromContext(ctx, s.logger).Infow("Attachment decryption requested", "taskId", ea.TaskID, "attachmentId", ea.ID, "userId", userId)
+
+	decryptedFilename, err := s.decrypt(ctx, ea.EncryptedFilename)
+	if err != nil {
+		s.logger.Warnw("Failed to decrypt attachment filename")
+		return nil, err
+	}
+	return &attachment{ID: ea.ID, TaskID: ea.TaskID, Filename: string(decryptedFilename), ContentType: ea.ContentType, Size: ea.Size, User: ea.User, CreatedDate: ea.CreatedDate}, nil
+}
+
+// decryptHistory decrypts the summaries in the history of a task, reading the history is logged once per request
+func (s *taskCrypto) decryptHistory(ctx context.Context, taskID int, entries []encryptedHistoryEntry, userId int) ([]historyEntry, error) {
+	logging.FromContext(ctx, s.logger).Infow("History decryption requested", "taskId", taskID, "userId", userId)
+
+	history := make([]historyEntry, len(entries))
+	for i, e := range entries {
+		before, err := s.decryptHistoryValue(ctx, e.Field, e.Before)
+		if err != nil {
+			return nil, err
+		}
+		after, err := s.decryptHistoryValue(ctx, e.Field, e.After)
+		if err != nil {
+			return nil, err
+		}
+		history[i] = historyEntry{Action: e.Action, Field: e.Field, Before: before, After: after, User: e.User, CreatedDate: e.CreatedDate}
+	}
+	return history, nil
+}
+
+// decryptHistoryValue returns nil when the field wasn't set, only summaries are encrypted
+func (s *taskCrypto) decryptHistoryValue(ctx context.Context, field string, value []byte) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if field == "summary" {
+		decrypted, err := s.decrypt(ctx, value)
+		if err != nil {
+			s.logger.Warnw("Failed to decrypt summary in history")
+			return nil, err
+		}
+		value = decrypted
+	}
+	plaintext := string(value)
+	return &plaintext, nil
+}
+
+// presentUser drops the zero users loaded for unassigned tasks so they are omitted from the JSON
+func presentUser(u *user.User) *user.User {
+	if u == nil || u.ID == 0 {
+		return nil
+	}
+	return u
+}
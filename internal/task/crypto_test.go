@@ -1,6 +1,7 @@
 package task
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"testing"
@@ -26,8 +27,30 @@ func TestEncodeAndDecodeTaskMatch(t *testing.T) {
 	dt := &task{ID: 1, Summary: text, CompletedDate: nil, User: nil}
 	c, _ := NewCrypto(key, l.Sugar())
 
-	et, _ := c.encryptTask(dt)
+	et, _ := c.encryptTask(context.Background(), dt)
 
-	dt2, _ := c.decryptTask(et, 1)
+	dt2, _ := c.decryptTask(context.Background(), et, 1)
 	assert.Equal(t, text, dt2.Summary)
 }
+
+func TestDecryptWithThePreviousKeyDuringARotation(t *testing.T) {
+	oldKey, newKey := "6368616e676520746869732070617373", "73736170207369687420656761686321"
+	old, _ := NewCrypto(oldKey, zap.NewNop().Sugar())
+	c, err := newRotatingCrypto(newKey, oldKey, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCiphertext, _ := old.encrypt(context.Background(), []byte("replace filter"))
+	newCiphertext, _ := c.encrypt(context.Background(), []byte("replace filter"))
+
+	for _, ciphertext := range [][]byte{oldCiphertext, newCiphertext} {
+		plaintext, err := c.decrypt(context.Background(), ciphertext)
+		assert.Nil(t, err)
+		assert.Equal(t, "replace filter", string(plaintext))
+	}
+	assert.False(t, c.isCurrent(oldCiphertext))
+	assert.True(t, c.isCurrent(newCiphertext))
+	// What is encrypted during the rotation uses the new key only
+	_, err = old.decrypt(context.Background(), newCiphertext)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,61 @@
+package task
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+	"sword-challenge/internal/problem"
+)
+
+// etag is the strong entity tag of the task at the version, the representation of a task only changes with its version
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// mustGetIfMatch returns the version of the task the client expects from the If-Match header, nil when any version is
+// accepted. Without the header the request is aborted with 428 if RequireIfMatch is set. Tags that aren't one of ours,
+// including weak ones which never match strongly, return a version no task has so the change fails with 412
+func (s *Service) mustGetIfMatch(c *gin.Context) (*int, bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" {
+		if s.RequireIfMatch {
+			problem.Abort(c, http.StatusPreconditionRequired, problem.CodePreconditionRequired, "The If-Match header with the ETag of the task is required")
+			return nil, false
+		}
+		return nil, true
+	}
+	if header == "*" {
+		return nil, true
+	}
+	return parseIfMatch(header), true
+}
+
+// parseIfMatch returns the version of the entity tag, any tag that isn't one of ours gets a version no task has
+func parseIfMatch(tag string) *int {
+	version := -1
+	if len(tag) > 2 && strings.HasPrefix(tag, `"`) && strings.HasSuffix(tag, `"`) {
+		if v, err := strconv.Atoi(tag[1 : len(tag)-1]); err == nil {
+			version = v
+		}
+	}
+	return &version
+}
+
+// versionMismatch aborts the request of a change made with an outdated If-Match
+func versionMismatch(c *gin.Context, id int) {
+	problem.Abort(c, http.StatusPreconditionFailed, problem.CodePreconditionFailed, fmt.Sprintf("Task %d changed since it was read, get it again", id))
+}
+
+// weakMatch checks whether the If-None-Match header has the entity tag, it uses the weak comparison so W/ prefixes are
+// ignored
+func weakMatch(header string, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/tracing"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const (
+	EventTaskCompleted     = "task.completed"
+	EventTaskStatusChanged = "task.status_changed"
+	EventTaskAssigned      = "task.assigned"
+	EventTaskOverdue       = "task.overdue"
+	EventTaskMentioned     = "task.mentioned"
+)
+
+type Notification struct {
+	ID            int        `json:"id" binding:"required"`
+	Manager       string     `json:"manager" binding:"required"`
+	CompletedDate *time.Time `json:"completedDate" binding:"required"`
+	User          *user.User `json:"user" binding:"required"`
+}
+
+// StatusChange is published on every transition, the user is the one who made it
+type StatusChange struct {
+	ID            int        `json:"id"`
+	From          Status     `json:"from"`
+	To            Status     `json:"to"`
+	CompletedDate *time.Time `json:"completedDate"`
+	User          *user.User `json:"user"`
+}
+
+// Assignment is published when a manager assigns a task, so the assignee is notified
+type Assignment struct {
+	ID         int        `json:"id"`
+	Assignee   *user.User `json:"assignee"`
+	AssignedBy *user.User `json:"assignedBy"`
+}
+
+// Mention is published when a user is mentioned in a comment, the body is left out since it's only stored encrypted
+type Mention struct {
+	ID        int        `json:"id"`
+	CommentID int        `json:"commentId"`
+	Mentioned *user.User `json:"mentioned"`
+	Author    *user.User `json:"author"`
+}
+
+// publishMentions notifies the mentioned users in the background, like publishAssignment
+func (s *Service) publishMentions(ctx context.Context, c comment, mentioned []user.User, author *user.User) {
+	if len(mentioned) == 0 {
+		return
+	}
+	go func(ctx context.Context) {
+		ctx, span := tracing.Start(ctx, "publishMentions")
+		var err error
+		for _, u := range mentioned {
+			u := u
+			if publishErr := s.publish(ctx, EventTaskMentioned, Mention{ID: c.TaskID, CommentID: c.ID, Mentioned: &u, Author: &user.User{ID: author.ID, Username: author.Username}}); publishErr != nil {
+				err = publishErr
+			}
+		}
+		tracing.End(span, err)
+	}(tracing.Detach(ctx))
+}
+
+// publishAssignment notifies the assignee of the task in the background, like publishTransition
+func (s *Service) publishAssignment(ctx context.Context, t encryptedTask, assignedBy *user.User) {
+	go func(ctx context.Context) {
+		ctx, span := tracing.Start(ctx, "publishAssignment")
+		err := s.publish(ctx, EventTaskAssigned, Assignment{ID: t.ID, Assignee: t.User, AssignedBy: &user.User{ID: assignedBy.ID, Username: assignedBy.Username}})
+		tracing.End(span, err)
+	}(tracing.Detach(ctx))
+}
+
+// publishTransition sends the events of a transition in the background, they outlive the request and only its trace is
+// kept so they show up under it. Managers are notified when a technician completes a task
+func (s *Service) publishTransition(ctx context.Context, t encryptedTask, from Status, actor *user.User) {
+	go func(ctx context.Context) {
+		ctx, span := tracing.Start(ctx, "publishTransition")
+		var err error
+		defer func() { tracing.End(span, err) }()
+
+		_ = s.publish(ctx, EventTaskStatusChanged, StatusChange{ID: t.ID, From: from, To: t.Status, CompletedDate: t.CompletedDate, User: &user.User{ID: actor.ID, Username: actor.Username}})
+		if t.Status != StatusDone || actor.Role.Name == util.AdminRole {
+			return
+		}
+
+		users, err := s.userService.GetUsersByRole(ctx, util.AdminRole)
+		if err != nil {
+			s.logger.Warnw("Failed to get users by role when sending notification", "error", err)
+			return
+		}
+		for _, u := range users {
+			_ = s.publish(ctx, EventTaskCompleted, Notification{ID: t.ID, Manager: u.Username, CompletedDate: t.CompletedDate, User: t.User})
+		}
+	}(tracing.Detach(ctx))
+}
+
+// publish marshals the payload and sends it to the notifications topic, the event type lets consumers know how to parse it
+func (s *Service) publish(ctx context.Context, eventType string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		s.logger.Warnw("Failed to marshal event to JSON", "type", eventType, "error", err)
+		return err
+	}
+
+	err = s.taskPublisher.Publish(ctx, eventbus.Message{Topic: s.notificationsTopic, Type: eventType, Body: body})
+	if err != nil {
+		s.logger.Warnw("Failed to publish event", "type", eventType, "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package task
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/tracing"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+// exportFlushRows is how many tasks are buffered before they are sent to the client
+const exportFlushRows = 100
+
+// exportQuery has the filters of GET /tasks and the format of the export
+type exportQuery struct {
+	taskQuery
+	Format string `form:"format" binding:"required,oneof=csv jsonl"`
+}
+
+var exportContentTypes = map[string]string{
+	"csv":   "text/csv; charset=utf-8",
+	"jsonl": "application/x-ndjson",
+}
+
+var csvHeader = []string{"id", "summary", "status", "priority", "dueDate", "estimatedMinutes", "completedDate", "user", "createdBy"}
+
+// exportWriter writes the tasks of an export in its format, it buffers them until they are flushed
+type exportWriter interface {
+	write(t *task) error
+	flush() error
+}
+
+// exportTasks streams the tasks that getTasks would return as CSV or JSON lines. The export is recorded before any task
+// is sent since it exposes the summaries decrypted, and how many were sent once it ends
+func (s *Service) exportTasks(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+
+	query := &exportQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse export query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+	// Technicians only export their own tasks, managers export every task
+	if currentUser.Role.Name != util.AdminRole {
+		query.UserID = &currentUser.ID
+	}
+
+	ctx := util.RequestContext(c)
+	filters, err := json.Marshal(query.taskQuery)
+	if err != nil {
+		logger.Warnw("Failed to marshal export filters", "error", err)
+		problem.Internal(c)
+		return
+	}
+	exportID, err := s.addExportToStore(ctx, currentUser.ID, query.Format, filters)
+	if err != nil {
+		logger.Warnw("Failed to record export in storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	sent := 0
+	defer func() {
+		// The client may be gone, the export is still recorded as finished
+		if err := s.finishExportInStore(tracing.Detach(ctx), exportID, sent); err != nil {
+			logger.Warnw("Failed to record end of export in storage", "exportId", exportID, "error", err)
+		}
+	}()
+
+	rows, err := s.streamTasksFromStore(ctx, &query.taskQuery)
+	if err != nil {
+		logger.Warnw("Failed to get tasks to export from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	defer rows.Close()
+
+	filename := "tasks-" + time.Now().UTC().Format("2006-01-02") + "." + query.Format
+	c.Header("Content-Type", exportContentTypes[query.Format])
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(http.StatusOK)
+
+	var w exportWriter = &jsonlExportWriter{encoder: json.NewEncoder(c.Writer)}
+	if query.Format == "csv" {
+		w, err = newCSVExportWriter(c.Writer)
+		if err != nil {
+			logger.Infow("Failed to write export header", "error", err)
+			return
+		}
+	}
+	// The status is sent with the first tasks, a failure afterwards can only end the export early
+	for rows.Next() {
+		et := encryptedTask{}
+		if err := rows.StructScan(&et); err != nil {
+			logger.Warnw("Failed to read task to export from storage", "error", err)
+			return
+		}
+		decryptedTask, err := s.taskEncryptor.decryptTask(ctx, &et, currentUser.ID)
+		if err != nil {
+			logger.Warnw("Failed to decrypt task")
+			return
+		}
+		if err := w.write(decryptedTask); err != nil {
+			logger.Infow("Failed to write exported task", "error", err)
+			return
+		}
+		sent++
+		if sent%exportFlushRows == 0 {
+			if err := w.flush(); err != nil {
+				logger.Infow("Failed to send exported tasks", "error", err)
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		logger.Warnw("Failed to get tasks to export from storage", "error", err)
+		return
+	}
+	if err := w.flush(); err != nil {
+		logger.Infow("Failed to send exported tasks", "error", err)
+	}
+}
+
+type jsonlExportWriter struct {
+	encoder *json.Encoder
+}
+
+// write adds the task as a line, the encoder writes straight to the response so there's nothing to flush
+func (w *jsonlExportWriter) write(t *task) error {
+	return w.encoder.Encode(t)
+}
+
+func (w *jsonlExportWriter) flush() error {
+	return nil
+}
+
+type csvExportWriter struct {
+	csv *csv.Writer
+}
+
+func newCSVExportWriter(out io.Writer) (*csvExportWriter, error) {
+	w := &csvExportWriter{csv: csv.NewWriter(out)}
+	return w, w.csv.Write(csvHeader)
+}
+
+func (w *csvExportWriter) write(t *task) error {
+	estimatedMinutes := ""
+	if t.EstimatedMinutes != nil {
+		estimatedMinutes = strconv.Itoa(*t.EstimatedMinutes)
+	}
+	return w.csv.Write([]string{strconv.Itoa(t.ID), csvCell(t.Summary), string(t.Status), string(t.Priority), csvTime(t.DueDate),
+		estimatedMinutes, csvTime(t.CompletedDate), csvCell(username(t.User)), csvCell(username(t.CreatedBy))})
+}
+
+func (w *csvExportWriter) flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// csvCell keeps spreadsheets from evaluating the values users typed that look like formulas, it prefixes them with a
+// quote as OWASP recommends against CSV injection
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func username(u *user.User) string {
+	if u == nil {
+		return ""
+	}
+	return u.Username
+}
@@ -2,52 +2,72 @@ package task
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	_ "github.com/go-sql-driver/mysql"
 	"net/http"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/user"
 	"sword-challenge/internal/util"
 	"time"
 )
 
+// mimeMergePatch is the content type of JSON merge patches
+const mimeMergePatch = "application/merge-patch+json"
+
 type task struct {
 	ID            int        `json:"id,omitempty"`
-	Summary       string     `json:"summary,omitempty"`
+	Summary       string     `json:"summary,omitempty" binding:"max=2500"`
+	Status        Status     `json:"status,omitempty"`
 	CompletedDate *time.Time `json:"completedDate" db:"completed_date"`
-	User          *user.User `json:"user,omitempty" binding:"required"`
+	User          *user.User `json:"user,omitempty"`
+	CreatedBy     *user.User `json:"createdBy,omitempty"`
+	DueDate       *time.Time `json:"dueDate,omitempty"`
+	Priority      Priority   `json:"priority,omitempty" binding:"omitempty,oneof=low normal high urgent"`
+	// EstimatedMinutes is how long the task is expected to take, it's optional
+	EstimatedMinutes *int `json:"estimatedMinutes,omitempty" binding:"omitempty,min=1"`
+	// DeletedAt is only set for tasks in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
-type Notification struct {
-	ID            int        `json:"id" binding:"required"`
-	Manager       string     `json:"manager" binding:"required"`
-	CompletedDate *time.Time `json:"completedDate" binding:"required"`
-	User          *user.User `json:"user" binding:"required"`
+type assigneeRequest struct {
+	ID int `json:"id" binding:"required,min=1"`
 }
 
 func (s *Service) getTasks(c *gin.Context) {
+	logger := s.requestLogger(c)
 	uInterface, _ := c.Get(util.UserContextKey)
 	currentUser := uInterface.(*user.User)
 
-	var encryptedTasks []encryptedTask
-	var err error
-	if currentUser.Role.Name == util.AdminRole {
-		encryptedTasks, err = s.getAllTasksFromStore()
-	} else {
-		encryptedTasks, err = s.getTasksFromStore(currentUser.ID)
+	query := &taskQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse task query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+	// Technicians only see their own tasks, managers see every task
+	if currentUser.Role.Name != util.AdminRole {
+		query.UserID = &currentUser.ID
 	}
+
+	ctx := util.RequestContext(c)
+	encryptedTasks, err := s.getTasksFromStore(ctx, query)
 	if err != nil && err != sql.ErrNoRows {
-		s.logger.Warnw("Failed to get task from storage", "error", err)
-		c.Status(http.StatusInternalServerError)
+		logger.Warnw("Failed to get task from storage", "error", err)
+		problem.Internal(c)
 		return
 	}
 
 	tasks := make([]task, len(encryptedTasks))
 	for i, t := range encryptedTasks {
 		t := t
-		decryptedTask, err := s.taskEncryptor.decryptTask(&t, currentUser.ID)
+		decryptedTask, err := s.taskEncryptor.decryptTask(ctx, &t, currentUser.ID)
 		if err != nil {
-			s.logger.Warnw("Failed to decrypt task")
-			c.Status(http.StatusInternalServerError)
+			logger.Warnw("Failed to decrypt task")
+			problem.Internal(c)
 			return
 		}
 		tasks[i] = *decryptedTask
@@ -56,119 +76,501 @@ func (s *Service) getTasks(c *gin.Context) {
 	c.JSON(http.StatusOK, tasks)
 }
 
+func (s *Service) getTask(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	t, ok := s.mustGetOwnTask(c, id, currentUser)
+	if !ok {
+		return
+	}
+
+	tag := etag(t.Version)
+	c.Header("ETag", tag)
+	if weakMatch(c.GetHeader("If-None-Match"), tag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	decryptedTask, err := s.taskEncryptor.decryptTask(util.RequestContext(c), t, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt task")
+		problem.Internal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, decryptedTask)
+}
+
 func (s *Service) createTask(c *gin.Context) {
+	logger := s.requestLogger(c)
 	receivedTask := &task{}
-	if err := c.BindJSON(receivedTask); err != nil {
-		s.logger.Infow("Failed to parse task request body", "error", err)
+	if err := c.ShouldBindJSON(receivedTask); err != nil {
+		logger.Infow("Failed to parse task request body", "error", err)
+		problem.Binding(c, err)
 		return
 	}
 
-	_, err := user.CheckIdsMatchIfPresentOrIsManager(c, &receivedTask.User.ID)
+	var assigneeID *int
+	if receivedTask.User != nil {
+		assigneeID = &receivedTask.User.ID
+	}
+	currentUser, err := user.CheckIdsMatchIfPresentOrIsManager(c, assigneeID)
 	if err != nil {
-		c.Status(http.StatusForbidden)
+		problem.Forbidden(c, "Only managers can create tasks for other users")
 		return
 	}
+	// Tasks created by managers without a user go to the unassigned pool, technicians create their own tasks
+	if receivedTask.User == nil && currentUser.Role.Name != util.AdminRole {
+		receivedTask.User = &user.User{ID: currentUser.ID, Username: currentUser.Username}
+	}
+	receivedTask.CreatedBy = &user.User{ID: currentUser.ID, Username: currentUser.Username}
+	if receivedTask.Priority == "" {
+		receivedTask.Priority = PriorityNormal
+	}
 
-	et, err := s.taskEncryptor.encryptTask(receivedTask)
+	ctx := util.RequestContext(c)
+	et, err := s.encryptTask(ctx, receivedTask)
 	// Only encrypt it summary was set
 	if err != nil {
-		s.logger.Warnw("Failed to encrypt task")
-		c.Status(http.StatusInternalServerError)
+		logger.Warnw("Failed to encrypt task")
+		problem.Internal(c)
 		return
 	}
 
-	id, err := s.addTaskToStore(et)
+	id, err := s.addTaskToStore(ctx, et)
 	receivedTask.ID = id
 	if err != nil {
-		s.logger.Warnw("Failed to add task to storage", "error", err)
-		c.Status(http.StatusInternalServerError)
+		logger.Warnw("Failed to add task to storage", "error", err)
+		problem.Internal(c)
 		return
 	}
+	// The status and completedDate can only be changed through transitions, deletedAt by deleting the task
+	receivedTask.User = presentUser(receivedTask.User)
+	receivedTask.Status = StatusTodo
+	receivedTask.CompletedDate = nil
+	receivedTask.DeletedAt = nil
+	// Tasks are created at version 1
+	c.Header("ETag", etag(1))
 	c.JSON(http.StatusCreated, receivedTask)
 }
 
+// editableFields are the fields of a task, named like in the API, that PUT replaces and PATCH changes. The status,
+// the assignee and the creator have their own endpoints
+var editableFields = []string{"summary", "dueDate", "priority", "estimatedMinutes"}
+
+// routedFields are the fields PUT and PATCH refuse rather than ignore, so clients know the change wasn't applied, with
+// the endpoint that changes them. PUT accepts them with their current value, so a task read with GET can be sent back
+var routedFields = []struct {
+	name     string
+	endpoint string
+}{
+	{"status", "POST /api/v1/tasks/:task-id/transitions"},
+	{"completedDate", "POST /api/v1/tasks/:task-id/transitions"},
+	{"user", "PUT /api/v1/tasks/:task-id/assignee"},
+}
+
+// errSummaryCleared means a patch sets the summary to null or empty, tasks keep a summary once it's set
+var errSummaryCleared = errors.New("the summary can't be cleared")
+
+// updateTask replaces the editable fields of the task, the ones missing from the body are cleared except the summary
+// which is required. The routedFields can only be sent with their current value
 func (s *Service) updateTask(c *gin.Context) {
+	logger := s.requestLogger(c)
 	id, err := s.mustGetTaskID(c)
 	if err != nil {
 		return
 	}
 
 	receivedTask := &task{}
-	if err := c.BindJSON(receivedTask); err != nil {
-		s.logger.Infow("Failed to parse task from body while updating", "error", err)
+	if err := c.ShouldBindBodyWith(receivedTask, binding.JSON); err != nil {
+		logger.Infow("Failed to parse task from body while updating", "error", err)
+		problem.Binding(c, err)
+		return
+	}
+	var body map[string]json.RawMessage
+	if err := c.ShouldBindBodyWith(&body, binding.JSON); err != nil || body == nil {
+		logger.Infow("Failed to parse task from body while updating", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidBody, "The request body must be a JSON object")
+		return
+	}
+	if receivedTask.Summary == "" {
+		logger.Infow("Task without summary in body while updating")
+		invalidTaskFields(c, []problem.FieldError{{Field: "summary", Code: "required", Message: "is required"}})
+		return
+	}
+	var routed []string
+	for _, field := range routedFields {
+		if _, ok := body[field.name]; ok {
+			routed = append(routed, field.name)
+		}
+	}
+	s.editTask(c, id, receivedTask, editableFields, routed)
+}
+
+// patchTask applies a JSON merge patch (RFC 7396) to the editable fields of the task, fields set to null are cleared
+// and the ones missing from the patch keep their value
+func (s *Service) patchTask(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	if contentType := c.ContentType(); contentType != mimeMergePatch && contentType != gin.MIMEJSON {
+		problem.Abort(c, http.StatusUnsupportedMediaType, problem.CodeUnsupportedMedia, fmt.Sprintf("Patches must be sent as %s", mimeMergePatch))
+		return
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		logger.Infow("Failed to read patch from body", "error", err)
+		problem.Binding(c, err)
+		return
+	}
+	receivedTask, patch, err := decodePatch(body)
+	if err == errPatchNotAnObject {
+		logger.Infow("Failed to parse patch from body", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidBody, "The request body must be a JSON object")
+		return
+	} else if err == errSummaryCleared {
+		logger.Infow("Invalid task in patch", "error", err)
+		invalidTaskFields(c, []problem.FieldError{{Field: "summary", Code: "required", Message: "can't be cleared"}})
+		return
+	} else if err != nil {
+		logger.Infow("Invalid task in patch", "error", err)
+		problem.Binding(c, err)
 		return
 	}
+	if errs := routedFieldErrors(patch); len(errs) > 0 {
+		logger.Infow("Invalid task in patch", "errors", errs)
+		invalidTaskFields(c, errs)
+		return
+	}
+
+	var fields []string
+	for _, field := range editableFields {
+		if _, ok := patch[field]; ok {
+			fields = append(fields, field)
+		}
+	}
+	s.editTask(c, id, receivedTask, fields, nil)
+}
+
+// errPatchNotAnObject means the merge patch isn't a JSON object, which would replace the whole task with something that
+// isn't a task
+var errPatchNotAnObject = errors.New("merge patch is not a JSON object")
+
+// decodePatch parses and validates a merge patch of a task, it returns the task with the values of the patch and the
+// fields the patch has. Nulls unmarshal to the zero value of the field, which is what clearing it means, the summary is
+// the only editable field that can't be cleared
+func decodePatch(body []byte) (*task, map[string]json.RawMessage, error) {
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal(body, &patch); err != nil || patch == nil {
+		return nil, nil, errPatchNotAnObject
+	}
+	patchedTask := &task{}
+	if err := json.Unmarshal(body, patchedTask); err != nil {
+		return nil, nil, err
+	}
+	if err := binding.Validator.ValidateStruct(patchedTask); err != nil {
+		return nil, nil, err
+	}
+	if _, ok := patch["summary"]; ok && patchedTask.Summary == "" {
+		return nil, nil, errSummaryCleared
+	}
+	return patchedTask, patch, nil
+}
+
+// routedFieldErrors has an error for each of the routedFields in the body
+func routedFieldErrors(body map[string]json.RawMessage) []problem.FieldError {
+	var errs []problem.FieldError
+	for _, field := range routedFields {
+		if _, ok := body[field.name]; ok {
+			errs = append(errs, problem.FieldError{Field: field.name, Code: "readonly", Message: "is changed through " + field.endpoint})
+		}
+	}
+	return errs
+}
+
+// changedRoutedFieldErrors has an error for each of the routedFields passed whose value in the task received isn't the
+// one of the current task, an unassigned task has a null user
+func changedRoutedFieldErrors(received *task, current *encryptedTask, fields []string) []problem.FieldError {
+	changed := map[string]json.RawMessage{}
+	for _, field := range fields {
+		switch field {
+		case "status":
+			if received.Status == current.Status {
+				continue
+			}
+		case "completedDate":
+			if received.CompletedDate == nil && current.CompletedDate == nil ||
+				received.CompletedDate != nil && current.CompletedDate != nil && received.CompletedDate.Equal(*current.CompletedDate) {
+				continue
+			}
+		case "user":
+			if userID(received.User) == userID(current.User) {
+				continue
+			}
+		}
+		changed[field] = nil
+	}
+	return routedFieldErrors(changed)
+}
+
+func invalidTaskFields(c *gin.Context, errs []problem.FieldError) {
+	p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The request body has invalid fields")
+	p.Errors = errs
+	problem.Write(c, p)
+}
+
+// editTask sets the fields of the task to the values received, honoring If-Match. The unchanged fields must have the
+// value of the current task
+func (s *Service) editTask(c *gin.Context, id int, receivedTask *task, fields []string, unchanged []string) {
+	logger := s.requestLogger(c)
 	receivedTask.ID = id
+	// A cleared priority goes back to the default like it's when creating the task
+	if receivedTask.Priority == "" {
+		receivedTask.Priority = PriorityNormal
+	}
+	ifVersion, ok := s.mustGetIfMatch(c)
+	if !ok {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	ctx := util.RequestContext(c)
+	// Whether the task belongs to the user making the change can only be checked after we fetch it from the database
+	current, ok := s.mustGetOwnTask(c, id, currentUser)
+	if !ok {
+		return
+	}
+	if errs := changedRoutedFieldErrors(receivedTask, current, unchanged); len(errs) > 0 {
+		logger.Infow("Invalid task in body while updating", "errors", errs)
+		invalidTaskFields(c, errs)
+		return
+	}
+
+	et, err := s.encryptTask(ctx, receivedTask)
+	if err != nil {
+		logger.Warnw("Failed to encrypt task")
+		problem.Internal(c)
+		return
+	}
+
+	updatedTask, err := s.updateTaskInStore(ctx, et, fields, ifVersion, currentUser.ID)
+	if err == sql.ErrNoRows {
+		logger.Infow("Task deleted during the update", "taskId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", id))
+		return
+	} else if err == errVersionMismatch {
+		logger.Infow("Task changed since the client read it", "taskId", id)
+		versionMismatch(c, id)
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to update task in storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	decryptedTask, err := s.taskEncryptor.decryptTask(ctx, updatedTask, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt task")
+		problem.Internal(c)
+		return
+	}
+
+	c.Header("ETag", etag(updatedTask.Version))
+	c.JSON(http.StatusOK, decryptedTask)
+}
+
+func (s *Service) deleteTask(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can delete tasks")
+		return
+	}
+	ifVersion, ok := s.mustGetIfMatch(c)
+	if !ok {
+		return
+	}
+
+	rowsAffected, err := s.deleteTaskFromStore(util.RequestContext(c), id, ifVersion, currentUser.ID)
+	if err == errVersionMismatch {
+		logger.Infow("Task changed since the client read it", "taskId", id)
+		versionMismatch(c, id)
+		return
+	} else if err != nil {
+		logger.Infow("Failed to delete task", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	} else if rowsAffected == 0 {
+		logger.Infow("Failed to find task while deleting", "taskId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", id))
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
 
-	currentUser, err := user.CheckIdsMatchIfPresentOrIsManager(c, &receivedTask.User.ID)
+func (s *Service) transitionTask(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
 	if err != nil {
-		c.Status(http.StatusForbidden)
 		return
 	}
 
-	taskToUpdate, err := s.getTaskFromStore(receivedTask.ID)
+	request := &transitionRequest{}
+	if err := c.ShouldBindJSON(request); err != nil {
+		logger.Infow("Failed to parse transition request body", "error", err)
+		problem.Binding(c, err)
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+
+	ctx := util.RequestContext(c)
+	taskToUpdate, ok := s.mustGetOwnTask(c, id, currentUser)
+	if !ok {
+		return
+	}
+
+	from := taskToUpdate.Status
+	if !canTransition(currentUser.Role.Name, from, request.Status) {
+		problem.Abort(c, http.StatusConflict, problem.CodeInvalidTransition, fmt.Sprintf("A %s can't move a task from %s to %s", currentUser.Role.Name, from, request.Status))
+		return
+	}
+
+	updatedTask, err := s.transitionTaskInStore(ctx, taskToUpdate, request.Status, currentUser.ID)
 	if err == sql.ErrNoRows {
-		s.logger.Infow("Failed to find task while updating", "taskId", id)
-		c.Status(http.StatusNotFound)
+		logger.Infow("Task deleted during the transition", "taskId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", id))
+		return
+	} else if err == errStatusChanged {
+		logger.Infow("Task status changed during the transition", "taskId", id)
+		problem.Abort(c, http.StatusConflict, problem.CodeInvalidTransition, fmt.Sprintf("Task %d is no longer %s", id, from))
 		return
 	} else if err != nil {
-		s.logger.Infow("Failed to get task while updating", "taskId", id, "error", err)
-		c.Status(http.StatusInternalServerError)
+		logger.Warnw("Failed to transition task in storage", "taskId", id, "error", err)
+		problem.Internal(c)
 		return
 	}
 
-	// Check whether the task belongs to the user making the change or if the user is manager, we can only do this after we fetch the task from the database
-	if currentUser.Role.Name != util.AdminRole && taskToUpdate.User.ID != currentUser.ID {
-		c.Status(http.StatusForbidden)
+	s.publishTransition(ctx, *updatedTask, from, currentUser)
+
+	decryptedTask, err := s.taskEncryptor.decryptTask(ctx, updatedTask, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt task")
+		problem.Internal(c)
 		return
 	}
 
-	et := &encryptedTask{ID: receivedTask.ID, User: receivedTask.User, CompletedDate: receivedTask.CompletedDate}
-	// Only encrypt if summary was set
-	if receivedTask.Summary != "" {
-		et2, err := s.taskEncryptor.encryptTask(receivedTask)
+	c.Header("ETag", etag(updatedTask.Version))
+	c.JSON(http.StatusOK, decryptedTask)
+}
+
+func (s *Service) getTransitions(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+
+	transitions, err := s.getTransitionsFromStore(util.RequestContext(c), id)
+	if err != nil {
+		logger.Warnw("Failed to get transitions from storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, transitions)
+}
+
+func (s *Service) getUnassignedTasks(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+
+	ctx := util.RequestContext(c)
+	encryptedTasks, err := s.getUnassignedTasksFromStore(ctx)
+	if err != nil {
+		logger.Warnw("Failed to get unassigned tasks from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	tasks := make([]task, len(encryptedTasks))
+	for i, t := range encryptedTasks {
+		t := t
+		decryptedTask, err := s.taskEncryptor.decryptTask(ctx, &t, currentUser.ID)
 		if err != nil {
-			s.logger.Warnw("Failed to encrypt task")
-			c.Status(http.StatusInternalServerError)
+			logger.Warnw("Failed to decrypt task")
+			problem.Internal(c)
 			return
 		}
-		et = et2
+		tasks[i] = *decryptedTask
 	}
 
-	updatedTask, err := s.updateTaskInStore(et)
+	c.JSON(http.StatusOK, tasks)
+}
+
+func (s *Service) claimTask(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
 	if err != nil {
-		s.logger.Warnw("Failed to update task in storage", "error", err)
-		c.Status(http.StatusInternalServerError)
 		return
 	}
 
-	if taskToUpdate.CompletedDate == nil && updatedTask.CompletedDate != nil && currentUser.Role.Name != util.AdminRole {
-		go func(t encryptedTask) {
-			users, err := s.userService.GetUsersByRole(util.AdminRole)
-			if err != nil {
-				s.logger.Warnw("Failed to get users by role when sending notification", "error", err)
-				return
-			}
-
-			for _, u := range users {
-				u := u
-				_ = s.taskPublisher.PublishTask(Notification{ID: t.ID, Manager: u.Username, CompletedDate: t.CompletedDate, User: t.User})
-			}
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
 
-		}(*updatedTask)
+	ctx := util.RequestContext(c)
+	claimedTask, err := s.claimTaskInStore(ctx, id, currentUser.ID)
+	if err == sql.ErrNoRows {
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", id))
+		return
+	} else if err == errAlreadyAssigned {
+		logger.Infow("Failed to claim task that is not in the unassigned pool", "taskId", id)
+		problem.Abort(c, http.StatusConflict, problem.CodeTaskAlreadyAssigned, fmt.Sprintf("Task %d is not in the unassigned pool", id))
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to claim task in storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
 	}
 
-	decryptedTask, err := s.taskEncryptor.decryptTask(updatedTask, currentUser.ID)
+	decryptedTask, err := s.taskEncryptor.decryptTask(ctx, claimedTask, currentUser.ID)
 	if err != nil {
-		s.logger.Warnw("Failed to decrypt task")
-		c.Status(http.StatusInternalServerError)
+		logger.Warnw("Failed to decrypt task")
+		problem.Internal(c)
 		return
 	}
 
+	c.Header("ETag", etag(claimedTask.Version))
 	c.JSON(http.StatusOK, decryptedTask)
 }
 
-func (s *Service) deleteTask(c *gin.Context) {
+func (s *Service) reassignTask(c *gin.Context) {
+	logger := s.requestLogger(c)
 	id, err := s.mustGetTaskID(c)
 	if err != nil {
 		return
@@ -177,20 +579,59 @@ func (s *Service) deleteTask(c *gin.Context) {
 	authUser, _ := c.Get(util.UserContextKey)
 	currentUser := authUser.(*user.User)
 	if currentUser.Role.Name != util.AdminRole {
-		c.Status(http.StatusForbidden)
+		problem.Forbidden(c, "Only managers can reassign tasks")
 		return
 	}
 
-	rowsAffected, err := s.deleteTaskFromStore(id)
-	if err != nil {
-		s.logger.Infow("Failed to delete task", "taskId", id, "error", err)
-		c.Status(http.StatusInternalServerError)
+	var assignee *user.User
+	if c.Request.Method == http.MethodPut {
+		request := &assigneeRequest{}
+		if err := c.ShouldBindJSON(request); err != nil {
+			logger.Infow("Failed to parse assignee request body", "error", err)
+			problem.Binding(c, err)
+			return
+		}
+
+		assignee, err = s.userService.GetUserByID(util.RequestContext(c), request.ID)
+		if err == sql.ErrNoRows {
+			p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The request body has invalid fields")
+			p.Errors = []problem.FieldError{{Field: "id", Code: "exists", Message: fmt.Sprintf("user %d does not exist", request.ID)}}
+			problem.Write(c, p)
+			return
+		} else if err != nil {
+			logger.Warnw("Failed to get assignee", "userId", request.ID, "error", err)
+			problem.Internal(c)
+			return
+		}
+	}
+
+	ctx := util.RequestContext(c)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
 		return
-	} else if rowsAffected == 0 {
-		s.logger.Infow("Failed to find task while deleting", "taskId", id)
-		c.Status(http.StatusNotFound)
+	}
+
+	updatedTask, err := s.assignTaskInStore(ctx, id, assignee, currentUser.ID)
+	if err == sql.ErrNoRows {
+		logger.Infow("Task deleted during the assignment", "taskId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", id))
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to assign task in storage", "taskId", id, "error", err)
+		problem.Internal(c)
 		return
 	}
 
-	c.Status(http.StatusOK)
+	if assignee != nil {
+		s.publishAssignment(ctx, *updatedTask, currentUser)
+	}
+
+	decryptedTask, err := s.taskEncryptor.decryptTask(ctx, updatedTask, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt task")
+		problem.Internal(c)
+		return
+	}
+
+	c.Header("ETag", etag(updatedTask.Version))
+	c.JSON(http.StatusOK, decryptedTask)
 }
@@ -0,0 +1,128 @@
+package task
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+type historyAction string
+
+const (
+	historyCreated      historyAction = "created"
+	historyUpdated      historyAction = "updated"
+	historyTransitioned historyAction = "transitioned"
+	historyAssigned     historyAction = "assigned"
+	historyDeleted      historyAction = "deleted"
+	historyRestored     historyAction = "restored"
+)
+
+// historyFields are the fields of a task whose changes are recorded, named like in the JSON of the task. Deleting and
+// restoring a task is recorded as a change of deletedAt
+var historyFields = []string{"summary", "status", "completedDate", "user", "dueDate", "priority", "estimatedMinutes"}
+
+// historyEntry is the change of one field of a task, before is null when the field was first set and after when it was
+// cleared. Users are recorded by ID and dates in RFC 3339
+type historyEntry struct {
+	Action      historyAction `json:"action"`
+	Field       string        `json:"field"`
+	Before      *string       `json:"before"`
+	After       *string       `json:"after"`
+	User        *user.User    `json:"user"`
+	CreatedDate *time.Time    `json:"createdDate"`
+}
+
+type encryptedHistoryEntry struct {
+	ID          int           `db:"id"`
+	TaskID      int           `db:"task_id"`
+	Action      historyAction `db:"action"`
+	Field       string        `db:"field"`
+	Before      []byte        `db:"old_value"`
+	After       []byte        `db:"new_value"`
+	User        *user.User    `db:"user"`
+	CreatedDate *time.Time    `db:"created_date"`
+}
+
+// fieldChange is a field that changed, summaries are kept as ciphertexts
+type fieldChange struct {
+	Field  string
+	Before []byte
+	After  []byte
+}
+
+// taskChanges compares the task before and after a change, a nil task is one that doesn't exist. Summaries can only be
+// compared by their ciphertext so sending the same summary again is recorded as a change
+func taskChanges(before, after *encryptedTask) []fieldChange {
+	beforeFields, afterFields := historyValues(before), historyValues(after)
+	var changes []fieldChange
+	for _, field := range historyFields {
+		if !bytes.Equal(beforeFields[field], afterFields[field]) {
+			changes = append(changes, fieldChange{Field: field, Before: beforeFields[field], After: afterFields[field]})
+		}
+	}
+	return changes
+}
+
+// historyValues are the values stored in the history for each field that is set
+func historyValues(t *encryptedTask) map[string][]byte {
+	values := map[string][]byte{}
+	if t == nil {
+		return values
+	}
+	values["summary"] = t.EncryptedSummary
+	if t.Status != "" {
+		values["status"] = []byte(t.Status)
+	}
+	if t.CompletedDate != nil {
+		values["completedDate"] = []byte(t.CompletedDate.UTC().Format(time.RFC3339))
+	}
+	if u := presentUser(t.User); u != nil {
+		values["user"] = []byte(strconv.Itoa(u.ID))
+	}
+	if t.DueDate != nil {
+		values["dueDate"] = []byte(t.DueDate.UTC().Format(time.RFC3339))
+	}
+	if t.Priority != "" {
+		values["priority"] = []byte(t.Priority)
+	}
+	if t.EstimatedMinutes != nil {
+		values["estimatedMinutes"] = []byte(strconv.Itoa(*t.EstimatedMinutes))
+	}
+	return values
+}
+
+func (s *Service) getHistory(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if _, ok := s.mustGetOwnTask(c, id, currentUser); !ok {
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	encryptedEntries, err := s.getHistoryFromStore(ctx, id)
+	if err != nil {
+		logger.Warnw("Failed to get history from storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	history, err := s.taskEncryptor.decryptHistory(ctx, id, encryptedEntries, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt history", "taskId", id)
+		problem.Internal(c)
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
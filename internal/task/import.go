@@ -0,0 +1,363 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const (
+	// maxImportRows is how many tasks a single import can have
+	maxImportRows = 10000
+	// importBatchSize is how many tasks are inserted with each statement
+	importBatchSize = 100
+	// maxImportLineSize is the longest JSON line accepted, summaries are at most 2500 characters
+	maxImportLineSize = 64 * 1024
+	// maxImportSize is the largest file accepted by the API, it's read whole before any row is imported
+	maxImportSize = 32 * 1024 * 1024
+)
+
+var (
+	errImportTooLarge     = errors.New("import has too many rows")
+	errImportBodyTooLarge = errors.New("import is too large")
+)
+
+// importQuery is the format of the file imported and whether it's only validated
+type importQuery struct {
+	Format string `form:"format" binding:"required,oneof=csv jsonl"`
+	DryRun bool   `form:"dryRun"`
+}
+
+// ImportResult reports how many rows were read and imported, and why the rows that weren't valid were refused
+type ImportResult struct {
+	Rows     int           `json:"rows"`
+	Imported int           `json:"imported"`
+	DryRun   bool          `json:"dryRun"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// ImportError is a problem with the row starting at Line, the header of a CSV file is line 1. Field is the JSON name of
+// the invalid field, it's empty when the row couldn't be read at all
+type ImportError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// importRow is a task read from the file, fields that can't be imported like the id or the status are ignored so
+// exports can be imported again
+type importRow struct {
+	Summary          string      `json:"summary" binding:"required,max=2500"`
+	User             *importUser `json:"user"`
+	DueDate          *time.Time  `json:"dueDate"`
+	Priority         Priority    `json:"priority" binding:"omitempty,oneof=low normal high urgent"`
+	EstimatedMinutes *int        `json:"estimatedMinutes" binding:"omitempty,min=1"`
+
+	line int
+	// errs are why the row couldn't be read, it's not validated then
+	errs []ImportError
+}
+
+// importUser is the assignee of an imported task, it's found by username so files from other installations match
+type importUser struct {
+	Username string `json:"username"`
+}
+
+// importTasks creates the tasks of a CSV or JSON lines file for managers, see Import
+func (s *Service) importTasks(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can import tasks")
+		return
+	}
+
+	query := &importQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse import query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+
+	body := &limitedImportReader{r: c.Request.Body, remaining: maxImportSize}
+	result, err := s.Import(util.RequestContext(c), body, query.Format, currentUser, query.DryRun)
+	if errors.Is(err, errImportTooLarge) {
+		problem.Abort(c, http.StatusRequestEntityTooLarge, problem.CodeImportTooLarge, fmt.Sprintf("Imports can have at most %d rows", maxImportRows))
+		return
+	} else if errors.Is(err, errImportBodyTooLarge) {
+		problem.Abort(c, http.StatusRequestEntityTooLarge, problem.CodeImportTooLarge, fmt.Sprintf("Imports can be at most %d bytes", maxImportSize))
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to import tasks", "error", err)
+		problem.Internal(c)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Import creates a task for every row of the CSV or JSON lines file, created by creator. Either every row is imported
+// or, when any of them is invalid, none is and the result has the errors of each row. A dry run only validates them
+func (s *Service) Import(ctx context.Context, r io.Reader, format string, creator *user.User, dryRun bool) (*ImportResult, error) {
+	logger := logging.FromContext(ctx, s.logger)
+	var rows []importRow
+	var errs []ImportError
+	var err error
+	switch format {
+	case "csv":
+		rows, errs, err = readCSVImport(r)
+	case "jsonl":
+		rows, errs, err = readJSONLImport(r)
+	default:
+		return nil, fmt.Errorf("unknown import format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var valid []importRow
+	for _, row := range rows {
+		if len(row.errs) > 0 {
+			errs = append(errs, row.errs...)
+			continue
+		}
+		if err := binding.Validator.ValidateStruct(&row); err != nil {
+			errs = append(errs, importErrors(row.line, err)...)
+			continue
+		}
+		valid = append(valid, row)
+	}
+	assignees, err := s.importAssignees(ctx, valid)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{Rows: len(rows), DryRun: dryRun}
+	tasks := make([]*encryptedTask, 0, len(valid))
+	for _, row := range valid {
+		t := &task{Summary: row.Summary, DueDate: row.DueDate, Priority: row.Priority, EstimatedMinutes: row.EstimatedMinutes,
+			CreatedBy: &user.User{ID: creator.ID, Username: creator.Username}}
+		if t.Priority == "" {
+			t.Priority = PriorityNormal
+		}
+		if row.User != nil && row.User.Username != "" {
+			assignee, ok := assignees[row.User.Username]
+			if !ok {
+				errs = append(errs, ImportError{Line: row.line, Field: "user.username", Code: "exists", Message: fmt.Sprintf("user %s does not exist", row.User.Username)})
+				continue
+			}
+			t.User = &user.User{ID: assignee.ID, Username: assignee.Username}
+		}
+		et, err := s.encryptTask(ctx, t)
+		if err != nil {
+			logger.Warnw("Failed to encrypt imported task", "line", row.line)
+			return nil, err
+		}
+		tasks = append(tasks, et)
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	result.Errors = append([]ImportError{}, errs...)
+	if len(errs) > 0 || dryRun {
+		return result, nil
+	}
+	if err := s.importTasksToStore(ctx, tasks); err != nil {
+		return nil, err
+	}
+	result.Imported = len(tasks)
+	logger.Infow("Imported tasks", "count", result.Imported, "userId", creator.ID)
+	return result, nil
+}
+
+// importAssignees gets the users assigned to the rows by username
+func (s *Service) importAssignees(ctx context.Context, rows []importRow) (map[string]user.User, error) {
+	var usernames []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		if row.User != nil && row.User.Username != "" && !seen[row.User.Username] {
+			seen[row.User.Username] = true
+			usernames = append(usernames, row.User.Username)
+		}
+	}
+	users, err := s.userService.GetUsersByUsernames(ctx, usernames)
+	if err != nil {
+		return nil, err
+	}
+	assignees := make(map[string]user.User, len(users))
+	for _, u := range users {
+		assignees[u.Username] = u
+	}
+	return assignees, nil
+}
+
+// readCSVImport reads the rows of a CSV file with a header, columns are found by their name and the ones that can't
+// be imported are ignored. The errors returned aren't of any row, like a missing column or a broken quote which ends
+// the file
+func readCSVImport(r io.Reader) ([]importRow, []ImportError, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	var parseErr *csv.ParseError
+	if err == io.EOF {
+		return nil, nil, nil
+	} else if errors.As(err, &parseErr) {
+		return nil, []ImportError{csvImportError(err)}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		// Spreadsheets may start the file with a byte order mark
+		columns[strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))] = i
+	}
+	if _, ok := columns["summary"]; !ok {
+		return nil, []ImportError{{Line: 1, Field: "summary", Code: "required", Message: "the summary column is missing"}}, nil
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil, nil
+		}
+		if len(rows) == maxImportRows {
+			return nil, nil, errImportTooLarge
+		}
+		if errors.Is(err, csv.ErrFieldCount) {
+			rows = append(rows, importRow{errs: []ImportError{csvImportError(err)}})
+			continue
+		} else if errors.As(err, &parseErr) {
+			return rows, []ImportError{csvImportError(err)}, nil
+		} else if err != nil {
+			return nil, nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, csvImportRow(record, columns, line))
+	}
+}
+
+func csvImportError(err error) ImportError {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return ImportError{Line: parseErr.StartLine, Code: "syntax", Message: parseErr.Err.Error()}
+	}
+	return ImportError{Code: "syntax", Message: err.Error()}
+}
+
+// csvImportRow parses the cells of a record, empty cells are fields that aren't set. The user column has the username
+// of the assignee
+func csvImportRow(record []string, columns map[string]int, line int) importRow {
+	cell := func(name string) string {
+		if i, ok := columns[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	row := importRow{Summary: csvValue(record[columns["summary"]]), Priority: Priority(cell("priority")), line: line}
+	if username := csvValue(cell("user")); username != "" {
+		row.User = &importUser{Username: username}
+	}
+
+	if dueDate := cell("dueDate"); dueDate != "" {
+		if parsed, err := time.Parse(time.RFC3339, dueDate); err != nil {
+			row.errs = append(row.errs, ImportError{Line: line, Field: "dueDate", Code: "type", Message: "must be an RFC 3339 date"})
+		} else {
+			row.DueDate = &parsed
+		}
+	}
+	if estimatedMinutes := cell("estimatedMinutes"); estimatedMinutes != "" {
+		if parsed, err := strconv.Atoi(estimatedMinutes); err != nil {
+			row.errs = append(row.errs, ImportError{Line: line, Field: "estimatedMinutes", Code: "type", Message: "must be a number"})
+		} else {
+			row.EstimatedMinutes = &parsed
+		}
+	}
+	return row
+}
+
+// csvValue undoes csvCell so exported files can be imported again
+func csvValue(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(cell[1])) {
+		return cell[1:]
+	}
+	return cell
+}
+
+// readJSONLImport reads a task from each line, blank lines are skipped. A line longer than maxImportLineSize ends the
+// file like a broken quote ends a CSV file
+func readJSONLImport(r io.Reader) ([]importRow, []ImportError, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineSize)
+	var rows []importRow
+	line := 1
+	for ; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, nil, errImportTooLarge
+		}
+		row := importRow{line: line}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			row = importRow{line: line, errs: importErrors(line, err)}
+		}
+		rows = append(rows, row)
+	}
+	if errors.Is(scanner.Err(), bufio.ErrTooLong) {
+		return rows, []ImportError{{Line: line, Code: "size", Message: fmt.Sprintf("must be at most %d bytes", maxImportLineSize)}}, nil
+	}
+	return rows, nil, scanner.Err()
+}
+
+// limitedImportReader fails with errImportBodyTooLarge once more than remaining bytes are read
+type limitedImportReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedImportReader) Read(p []byte) (int, error) {
+	// Reading one byte past the limit tells a file of exactly the limit from a larger one
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errImportBodyTooLarge
+	}
+	return n, err
+}
+
+// importErrors are the errors of decoding or validating a row, named like problem.Binding names them
+func importErrors(line int, err error) []ImportError {
+	// The due date is the only date of a row
+	var timeErr *time.ParseError
+	if errors.As(err, &timeErr) {
+		return []ImportError{{Line: line, Field: "dueDate", Code: "type", Message: "must be an RFC 3339 date"}}
+	}
+	p := problem.FromBinding(err)
+	if len(p.Errors) == 0 || p.Errors[0].Field == "" {
+		return []ImportError{{Line: line, Code: "syntax", Message: "must be a JSON object"}}
+	}
+	errs := make([]ImportError, len(p.Errors))
+	for i, fe := range p.Errors {
+		errs[i] = ImportError{Line: line, Field: fe.Field, Code: fe.Code, Message: fe.Message}
+	}
+	return errs
+}
@@ -0,0 +1,225 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// keyRotationBatchSize is how many rows each transaction of a key rotation locks and re-encrypts
+const keyRotationBatchSize = 100
+
+var errNoPreviousKey = errors.New("crypto.previousKey must be set to the key being rotated out")
+
+// rotatedTable is a table with encrypted columns, where filters the rows whose columns are encrypted
+type rotatedTable struct {
+	name    string
+	columns []string
+	where   string
+}
+
+var rotatedTables = []rotatedTable{
+	{name: "tasks", columns: []string{"summary"}},
+	{name: "task_history", columns: []string{"old_value", "new_value"}, where: "field = 'summary'"},
+	{name: "task_comments", columns: []string{"body"}},
+	{name: "task_schedules", columns: []string{"summary"}},
+}
+
+// RotateKey re-encrypts with the current key every summary, including the ones in the task history and of the
+// schedules, every comment and every attachment still encrypted with the previous key. Servers decrypt with both keys
+// while it runs, so it's done in batches of keyRotationBatchSize rows, each in its own transaction, and attachments are
+// re-encrypted into new files without holding any lock. Rows already encrypted with the current key are left as they
+// are, so an interrupted rotation is finished by running it again. It returns how many rows were re-encrypted
+func (s *Service) RotateKey(ctx context.Context) (int, error) {
+	if s.taskEncryptor.previous == nil {
+		return 0, errNoPreviousKey
+	}
+
+	rotated := 0
+	for _, table := range rotatedTables {
+		lastID := 0
+		for {
+			batchLastID, batchRotated, err := s.rotateKeyBatch(ctx, table, lastID)
+			rotated += batchRotated
+			if err != nil {
+				return rotated, err
+			}
+			if batchLastID == lastID {
+				break
+			}
+			lastID = batchLastID
+		}
+	}
+
+	attachments, err := s.rotateAttachmentKeys(ctx)
+	rotated += attachments
+	if err != nil {
+		return rotated, err
+	}
+	s.logger.Infow("Rotated task encryption key", "rows", rotated)
+	return rotated, nil
+}
+
+// rotateKeyBatch re-encrypts the rows of the table after the ID passed, it returns the last ID it went through, the
+// same ID once there are no rows left
+func (s *Service) rotateKeyBatch(ctx context.Context, table rotatedTable, afterID int) (int, int, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return afterID, 0, err
+	}
+	defer tx.Rollback()
+
+	where := "id > ?"
+	if table.where != "" {
+		where += " AND " + table.where
+	}
+	query := fmt.Sprintf("SELECT id, %s FROM %s WHERE %s ORDER BY id LIMIT ? FOR UPDATE;", strings.Join(table.columns, ", "), table.name, where)
+	rows, err := tx.QueryContext(ctx, query, afterID, keyRotationBatchSize)
+	if err != nil {
+		return afterID, 0, err
+	}
+	type row struct {
+		id     int
+		values [][]byte
+	}
+	var batch []row
+	for rows.Next() {
+		r := row{values: make([][]byte, len(table.columns))}
+		dest := []interface{}{&r.id}
+		for i := range r.values {
+			dest = append(dest, &r.values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			return afterID, 0, err
+		}
+		batch = append(batch, r)
+	}
+	if err := rows.Close(); err != nil {
+		return afterID, 0, err
+	}
+	if len(batch) == 0 {
+		return afterID, 0, nil
+	}
+
+	assignments := make([]string, len(table.columns))
+	for i, column := range table.columns {
+		assignments[i] = column + " = ?"
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s WHERE id = ?;", table.name, strings.Join(assignments, ", "))
+	rotated := 0
+	for _, r := range batch {
+		changed := false
+		args := make([]interface{}, 0, len(r.values)+1)
+		for _, value := range r.values {
+			if !s.taskEncryptor.isCurrent(value) {
+				reEncrypted, err := s.reEncrypt(ctx, value)
+				if err != nil {
+					s.logger.Warnw("Failed to decrypt value while rotating key", "table", table.name, "id", r.id)
+					return afterID, 0, err
+				}
+				value, changed = reEncrypted, true
+			}
+			args = append(args, nullBytes(value))
+		}
+		if !changed {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, update, append(args, r.id)...); err != nil {
+			return afterID, 0, err
+		}
+		rotated++
+	}
+	if err := tx.Commit(); err != nil {
+		return afterID, 0, err
+	}
+	return batch[len(batch)-1].id, rotated, nil
+}
+
+// rotateAttachmentKeys copies the files of the attachments still encrypted with the previous key into new files
+// encrypted with the current one. The filename and the file are encrypted with the same key, so the filename tells
+// which attachments are left. An attachment is only switched to its new file if it didn't change meanwhile
+func (s *Service) rotateAttachmentKeys(ctx context.Context) (int, error) {
+	rotated, lastID := 0, 0
+	for {
+		var attachments []encryptedAttachment
+		if err := s.db.SelectContext(ctx, &attachments, "SELECT a.id, a.task_id, a.filename, a.storage_key FROM task_attachments a WHERE a.id > ? ORDER BY a.id LIMIT ?;",
+			lastID, keyRotationBatchSize); err != nil {
+			return rotated, err
+		}
+		if len(attachments) == 0 {
+			return rotated, nil
+		}
+		for _, a := range attachments {
+			if s.taskEncryptor.isCurrent(a.EncryptedFilename) {
+				continue
+			}
+			switched, err := s.rotateAttachmentKey(ctx, a)
+			if err != nil {
+				s.logger.Warnw("Failed to re-encrypt attachment while rotating key", "attachmentId", a.ID, "error", err)
+				return rotated, err
+			}
+			if switched {
+				rotated++
+			}
+		}
+		lastID = attachments[len(attachments)-1].ID
+	}
+}
+
+// rotateAttachmentKey tells whether the attachment was switched to its new file, it isn't when it was deleted meanwhile
+func (s *Service) rotateAttachmentKey(ctx context.Context, a encryptedAttachment) (bool, error) {
+	filename, err := s.reEncrypt(ctx, a.EncryptedFilename)
+	if err != nil {
+		return false, err
+	}
+	key := attachmentKey(a.TaskID)
+	if err := s.reEncryptBlob(ctx, a.StorageKey, key); err != nil {
+		return false, err
+	}
+
+	// The file that isn't used in the end is deleted, failures leave an orphan file behind
+	orphan := key
+	defer func() {
+		if err := s.Blobs.Delete(ctx, orphan); err != nil {
+			s.logger.Warnw("Failed to delete attachment from the blob storage while rotating key", "key", orphan, "error", err)
+		}
+	}()
+	result, err := s.db.ExecContext(ctx, "UPDATE task_attachments SET filename = ?, storage_key = ? WHERE id = ? AND storage_key = ?;",
+		filename, key, a.ID, a.StorageKey)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+	orphan = a.StorageKey
+	return true, nil
+}
+
+// reEncrypt decrypts the ciphertext with the previous key and encrypts it with the current one
+func (s *Service) reEncrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	plaintext, err := s.taskEncryptor.decrypt(ctx, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return s.taskEncryptor.encrypt(ctx, plaintext)
+}
+
+// reEncryptBlob streams the file stored in from into to encrypted with the current key, a corrupted file fails the
+// rotation
+func (s *Service) reEncryptBlob(ctx context.Context, from string, to string) error {
+	file, err := s.Blobs.Get(ctx, from)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encrypted, err := s.taskEncryptor.encryptStream(s.taskEncryptor.decryptStream(file))
+	if err != nil {
+		return err
+	}
+	return s.Blobs.Put(ctx, to, encrypted)
+}
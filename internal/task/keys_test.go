@@ -0,0 +1,144 @@
+package task
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"strings"
+	"sword-challenge/internal/blob"
+	"testing"
+)
+
+func TestRotateKeyReEncryptsWhatThePreviousKeyEncrypted(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	t.Cleanup(func() {
+		db.Close()
+	})
+	oldKey, newKey := "6368616e676520746869732070617373", "73736170207369687420656761686321"
+	oldEncryptor, _ := NewCrypto(oldKey, zap.NewNop().Sugar())
+	encryptor, _ := newRotatingCrypto(newKey, oldKey, zap.NewNop().Sugar())
+	blobs := blob.NewFilesystem(t.TempDir())
+	service := &Service{db: sqlx.NewDb(db, "mysql"), logger: zap.NewNop().Sugar(), taskEncryptor: encryptor, Blobs: blobs}
+	encryptedFile, _ := oldEncryptor.encryptStream(strings.NewReader("photo of the pump"))
+	assert.Nil(t, blobs.Put(context.Background(), "tasks/1/old", encryptedFile))
+	filename, _ := oldEncryptor.encrypt(context.Background(), []byte("pump.png"))
+	oldSummary, _ := oldEncryptor.encrypt(context.Background(), []byte("replace filter"))
+	newSummary, _ := encryptor.encrypt(context.Background(), []byte("replace filter"))
+
+	// Each batch is its own transaction, rows written with the new key since the servers switched are left as they are
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, summary FROM tasks WHERE id > .+ ORDER BY id LIMIT .+ FOR UPDATE;").WithArgs(0, keyRotationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "summary"}).AddRow(1, oldSummary).AddRow(2, newSummary))
+	mock.ExpectExec("UPDATE tasks SET summary = .+ WHERE id = .+;").WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, summary FROM tasks WHERE id > .+").WithArgs(2, keyRotationBatchSize).WillReturnRows(sqlmock.NewRows([]string{"id", "summary"}))
+	mock.ExpectRollback()
+	// The summaries in the history are re-encrypted too, a summary set at creation has no old value
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, old_value, new_value FROM task_history WHERE id > .+ AND field = 'summary' ORDER BY id LIMIT .+ FOR UPDATE;").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "old_value", "new_value"}).AddRow(3, nil, oldSummary))
+	mock.ExpectExec("UPDATE task_history SET old_value = .+, new_value = .+ WHERE id = .+;").
+		WithArgs(nil, sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id, old_value, new_value FROM task_history").WithArgs(3, keyRotationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "old_value", "new_value"}))
+	mock.ExpectRollback()
+	for _, table := range []string{"task_comments", "task_schedules"} {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, .+ FROM "+table+" WHERE id > .+").WithArgs(0, keyRotationBatchSize).WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+		mock.ExpectRollback()
+	}
+	// Attachments are switched to their new file only if they weren't deleted meanwhile, without locking them
+	mock.ExpectQuery("SELECT a.id, a.task_id, a.filename, a.storage_key FROM task_attachments a WHERE a.id > .+ ORDER BY a.id LIMIT .+;").
+		WithArgs(0, keyRotationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_id", "filename", "storage_key"}).AddRow(7, 1, filename, "tasks/1/old"))
+	storageKey := &capturedArg{}
+	mock.ExpectExec("UPDATE task_attachments SET filename = .+, storage_key = .+ WHERE id = .+ AND storage_key = .+;").
+		WithArgs(sqlmock.AnyArg(), storageKey, 7, "tasks/1/old").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT a.id, a.task_id, a.filename, a.storage_key FROM task_attachments a").WithArgs(7, keyRotationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_id", "filename", "storage_key"}))
+
+	rotated, err := service.RotateKey(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rotated)
+	assert.Nil(t, mock.ExpectationsWereMet())
+
+	// The attachment was moved to a file encrypted with the new key, which decrypts without the previous one
+	newEncryptor, _ := NewCrypto(newKey, zap.NewNop().Sugar())
+	_, err = blobs.Get(context.Background(), "tasks/1/old")
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+	file, err := blobs.Get(context.Background(), storageKey.value.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(newEncryptor.decryptStream(file))
+	assert.Nil(t, err)
+	assert.Equal(t, "photo of the pump", string(content))
+}
+
+func TestRotateKeyKeepsTheFileOfAnAttachmentDeletedMeanwhile(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	t.Cleanup(func() {
+		db.Close()
+	})
+	oldKey := "6368616e676520746869732070617373"
+	oldEncryptor, _ := NewCrypto(oldKey, zap.NewNop().Sugar())
+	encryptor, _ := newRotatingCrypto("73736170207369687420656761686321", oldKey, zap.NewNop().Sugar())
+	blobs := blob.NewFilesystem(t.TempDir())
+	service := &Service{db: sqlx.NewDb(db, "mysql"), logger: zap.NewNop().Sugar(), taskEncryptor: encryptor, Blobs: blobs}
+	encryptedFile, _ := oldEncryptor.encryptStream(strings.NewReader("photo of the pump"))
+	assert.Nil(t, blobs.Put(context.Background(), "tasks/1/old", encryptedFile))
+	filename, _ := oldEncryptor.encrypt(context.Background(), []byte("pump.png"))
+
+	for range rotatedTables {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id, .+ FOR UPDATE;").WillReturnRows(sqlmock.NewRows([]string{"id", "value"}))
+		mock.ExpectRollback()
+	}
+	mock.ExpectQuery("SELECT a.id, a.task_id, a.filename, a.storage_key FROM task_attachments a").WithArgs(0, keyRotationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_id", "filename", "storage_key"}).AddRow(7, 1, filename, "tasks/1/old"))
+	storageKey := &capturedArg{}
+	mock.ExpectExec("UPDATE task_attachments SET filename = .+, storage_key = .+ WHERE id = .+ AND storage_key = .+;").
+		WithArgs(sqlmock.AnyArg(), storageKey, 7, "tasks/1/old").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT a.id, a.task_id, a.filename, a.storage_key FROM task_attachments a").WithArgs(7, keyRotationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "task_id", "filename", "storage_key"}))
+
+	rotated, err := service.RotateKey(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rotated)
+	assert.Nil(t, mock.ExpectationsWereMet())
+	// The new file is deleted and the old one is left to whoever deleted the attachment
+	_, err = blobs.Get(context.Background(), storageKey.value.(string))
+	assert.ErrorIs(t, err, blob.ErrNotFound)
+	file, err := blobs.Get(context.Background(), "tasks/1/old")
+	assert.Nil(t, err)
+	file.Close()
+}
+
+// capturedArg matches any argument and keeps it
+type capturedArg struct {
+	value driver.Value
+}
+
+func (c *capturedArg) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestRotateKeyNeedsThePreviousKey(t *testing.T) {
+	encryptor, _ := NewCrypto("6368616e676520746869732070617373", zap.NewNop().Sugar())
+	service := &Service{logger: zap.NewNop().Sugar(), taskEncryptor: encryptor}
+
+	_, err := service.RotateKey(context.Background())
+
+	assert.ErrorIs(t, err, errNoPreviousKey)
+}
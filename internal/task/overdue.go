@@ -0,0 +1,81 @@
+package task
+
+import (
+	"context"
+	"sword-challenge/internal/tracing"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+// Overdue is published once per recipient when an open task passes its due date, the user is the assignee and is
+// missing for tasks in the unassigned pool
+type Overdue struct {
+	ID        int        `json:"id"`
+	Recipient string     `json:"recipient"`
+	DueDate   *time.Time `json:"dueDate"`
+	User      *user.User `json:"user,omitempty"`
+}
+
+// NotifyOverdue publishes a task.overdue event to the assignee and every manager for each open task past its due date.
+// Each task is marked before its events are published so replicas running the scheduler at the same time never notify
+// twice, the notifications of a task are lost if publishing fails afterwards
+func (s *Service) NotifyOverdue(ctx context.Context) (notified int, err error) {
+	ctx, span := tracing.Start(ctx, "NotifyOverdue")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now().UTC()
+	tasks, err := s.getOverdueTasksFromStore(ctx, now)
+	if err != nil || len(tasks) == 0 {
+		return 0, err
+	}
+
+	managers, err := s.userService.GetUsersByRole(ctx, util.AdminRole)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, t := range tasks {
+		claimed, err := s.markOverdueNotifiedInStore(ctx, t.ID, now)
+		if err != nil {
+			return notified, err
+		} else if !claimed {
+			continue
+		}
+
+		assignee := presentUser(t.User)
+		var recipients []string
+		if assignee != nil {
+			recipients = append(recipients, assignee.Username)
+		}
+		for _, m := range managers {
+			if assignee == nil || m.ID != assignee.ID {
+				recipients = append(recipients, m.Username)
+			}
+		}
+		for _, recipient := range recipients {
+			_ = s.publish(ctx, EventTaskOverdue, Overdue{ID: t.ID, Recipient: recipient, DueDate: t.DueDate, User: assignee})
+		}
+		notified++
+	}
+	return notified, nil
+}
+
+// RunOverdueScheduler looks for overdue tasks every interval until the context is cancelled
+func (s *Service) RunOverdueScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			notified, err := s.NotifyOverdue(ctx)
+			if err != nil {
+				s.logger.Warnw("Failed to notify overdue tasks", "error", err)
+			} else if notified > 0 {
+				s.logger.Infow("Notified overdue tasks", "tasks", notified)
+			}
+		}
+	}
+}
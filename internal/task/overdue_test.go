@@ -0,0 +1,58 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"sword-challenge/internal/eventbus"
+	"time"
+)
+
+const getOverdueTasksSQL = "SELECT .+ FROM tasks t .+ WHERE t.due_date < .+ AND t.status NOT IN (.+, .+) AND t.overdue_notified_at IS NULL AND t.deleted_at IS NULL ORDER BY t.due_date;"
+const markOverdueNotifiedSQL = "UPDATE tasks SET overdue_notified_at = .+ WHERE id = .+ AND overdue_notified_at IS NULL;"
+const getUsersByRoleSQL = "SELECT u.id, u.username FROM users u INNER JOIN roles r on u.role_id = r.id WHERE r.name = .+;"
+
+func (s *TaskAPITestSuite) TestNotifyOverdueNotifiesTheTechnicianAndManagers() {
+	publisher := &recordingPublisher{messages: make(chan eventbus.Message, 10)}
+	s.service.taskPublisher = publisher
+	defer func() { s.service.taskPublisher = &LogPublisher{Logger: s.service.logger} }()
+
+	due := time.Date(2021, 10, 23, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows(taskColumns).
+		AddRow(1, "1", "in_progress", nil, 1, "joel", 2, "dvn", &due, "high", nil).
+		AddRow(2, "1", "todo", nil, 0, "", 2, "dvn", &due, "normal", nil).
+		AddRow(3, "1", "todo", nil, 1, "joel", 2, "dvn", &due, "normal", nil)
+	s.sqlmock.ExpectQuery(getOverdueTasksSQL).WithArgs(sqlmock.AnyArg(), "done", "cancelled").WillReturnRows(rows)
+	s.sqlmock.ExpectQuery(getUsersByRoleSQL).WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(2, "dvn").AddRow(4, "ana"))
+	s.sqlmock.ExpectExec(markOverdueNotifiedSQL).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(markOverdueNotifiedSQL).WithArgs(sqlmock.AnyArg(), 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Another replica notified the last one first
+	s.sqlmock.ExpectExec(markOverdueNotifiedSQL).WithArgs(sqlmock.AnyArg(), 3).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	notified, err := s.service.NotifyOverdue(context.Background())
+
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, notified)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+	close(publisher.messages)
+	var recipients []string
+	for msg := range publisher.messages {
+		assert.Equal(s.T(), EventTaskOverdue, msg.Type)
+		var overdue Overdue
+		assert.Nil(s.T(), json.Unmarshal(msg.Body, &overdue))
+		recipients = append(recipients, overdue.Recipient)
+	}
+	// The unassigned task only has managers to notify
+	assert.Equal(s.T(), []string{"joel", "dvn", "ana", "dvn", "ana"}, recipients)
+}
+
+func (s *TaskAPITestSuite) TestNotifyOverdueWithoutOverdueTasks() {
+	s.sqlmock.ExpectQuery(getOverdueTasksSQL).WillReturnRows(sqlmock.NewRows(taskColumns))
+
+	notified, err := s.service.NotifyOverdue(context.Background())
+
+	assert.Nil(s.T(), err)
+	assert.Zero(s.T(), notified)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
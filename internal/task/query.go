@@ -0,0 +1,105 @@
+package task
+
+import (
+	"strings"
+	"time"
+)
+
+type Priority string
+
+const (
+	PriorityLow    Priority = "low"
+	PriorityNormal Priority = "normal"
+	PriorityHigh   Priority = "high"
+	PriorityUrgent Priority = "urgent"
+)
+
+// sortColumns maps the sort keys accepted by GET /tasks to their columns, a leading - sorts in descending order. Tasks
+// without a due date or estimate are sorted last in ascending order
+var sortColumns = map[string]string{
+	"id":               "t.id",
+	"dueDate":          "t.due_date IS NULL, t.due_date",
+	"priority":         "t.priority",
+	"estimatedMinutes": "t.estimated_minutes IS NULL, t.estimated_minutes",
+}
+
+// taskQuery are the filters and sorting of GET /tasks, every filter is optional and they are combined with AND
+type taskQuery struct {
+	Status    []Status   `form:"status" json:"status" binding:"dive,oneof=todo in_progress blocked in_review done cancelled"`
+	Priority  []Priority `form:"priority" json:"priority" binding:"dive,oneof=low normal high urgent"`
+	DueBefore *time.Time `form:"dueBefore" json:"dueBefore"`
+	DueAfter  *time.Time `form:"dueAfter" json:"dueAfter"`
+	Overdue   bool       `form:"overdue" json:"overdue"`
+	Sort      string     `form:"sort" json:"sort" binding:"omitempty,oneof=id -id dueDate -dueDate priority -priority estimatedMinutes -estimatedMinutes"`
+
+	// UserID limits the tasks to the ones assigned to the user, it's set from the authenticated user for technicians
+	UserID *int `form:"-" json:"-"`
+	// SearchTokens limits the tasks to the ones whose summary has every token, see searchTasks
+	SearchTokens [][]byte `form:"-" json:"-"`
+}
+
+// where builds the WHERE and ORDER BY clauses of the query, values are always bound as arguments. Deleted tasks are
+// always excluded
+func (q *taskQuery) where(now time.Time) (string, []interface{}) {
+	conditions := []string{"t.deleted_at IS NULL"}
+	var args []interface{}
+	if q.UserID != nil {
+		conditions = append(conditions, "t.user_id = ?")
+		args = append(args, *q.UserID)
+	}
+	if len(q.Status) > 0 {
+		conditions = append(conditions, "t.status IN ("+placeholders(len(q.Status))+")")
+		for _, status := range q.Status {
+			args = append(args, status)
+		}
+	}
+	if len(q.Priority) > 0 {
+		conditions = append(conditions, "t.priority IN ("+placeholders(len(q.Priority))+")")
+		for _, priority := range q.Priority {
+			args = append(args, priority)
+		}
+	}
+	if q.DueBefore != nil {
+		conditions = append(conditions, "t.due_date < ?")
+		args = append(args, *q.DueBefore)
+	}
+	if q.DueAfter != nil {
+		conditions = append(conditions, "t.due_date >= ?")
+		args = append(args, *q.DueAfter)
+	}
+	if q.Overdue {
+		conditions = append(conditions, "t.due_date < ? AND t.status NOT IN (?, ?)")
+		args = append(args, now, StatusDone, StatusCancelled)
+	}
+	if len(q.SearchTokens) > 0 {
+		// Tokens are unique per task, a task with as many matching tokens as searched has all of them
+		conditions = append(conditions, "t.id IN (SELECT st.task_id FROM task_search_tokens st WHERE st.token IN ("+placeholders(len(q.SearchTokens))+") GROUP BY st.task_id HAVING COUNT(*) = ?)")
+		for _, token := range q.SearchTokens {
+			args = append(args, token)
+		}
+		args = append(args, len(q.SearchTokens))
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND ") + " ORDER BY " + q.orderBy(), args
+}
+
+func (q *taskQuery) orderBy() string {
+	key, direction := strings.TrimPrefix(q.Sort, "-"), ""
+	if strings.HasPrefix(q.Sort, "-") {
+		direction = " DESC"
+	}
+	column, ok := sortColumns[key]
+	if !ok {
+		return "t.id"
+	}
+	// Every column of the expression is sorted in the same direction, the tie breaker keeps pages stable
+	columns := strings.Split(column, ", ")
+	for i := range columns {
+		columns[i] += direction
+	}
+	return strings.Join(columns, ", ") + ", t.id"
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
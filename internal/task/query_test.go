@@ -0,0 +1,31 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestQueryWithoutFiltersSortsByID(t *testing.T) {
+	where, args := (&taskQuery{}).where(time.Now())
+
+	assert.Equal(t, " WHERE t.deleted_at IS NULL ORDER BY t.id", where)
+	assert.Empty(t, args)
+}
+
+func TestQueryCombinesFilters(t *testing.T) {
+	now := time.Date(2021, 10, 23, 0, 0, 0, 0, time.UTC)
+	dueBefore := now.Add(48 * time.Hour)
+	userID := 1
+	q := &taskQuery{Status: []Status{StatusTodo, StatusBlocked}, Priority: []Priority{PriorityUrgent}, DueBefore: &dueBefore, Overdue: true, Sort: "-dueDate", UserID: &userID}
+
+	where, args := q.where(now)
+
+	assert.Equal(t, " WHERE t.deleted_at IS NULL AND t.user_id = ? AND t.status IN (?, ?) AND t.priority IN (?) AND t.due_date < ? AND t.due_date < ? AND t.status NOT IN (?, ?) ORDER BY t.due_date IS NULL DESC, t.due_date DESC, t.id", where)
+	assert.Equal(t, []interface{}{1, StatusTodo, StatusBlocked, PriorityUrgent, dueBefore, now, StatusDone, StatusCancelled}, args)
+}
+
+func TestQuerySortsByPriority(t *testing.T) {
+	assert.Equal(t, "t.priority, t.id", (&taskQuery{Sort: "priority"}).orderBy())
+	assert.Equal(t, "t.priority DESC, t.id", (&taskQuery{Sort: "-priority"}).orderBy())
+}
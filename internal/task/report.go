@@ -0,0 +1,219 @@
+package task
+
+import (
+	"encoding/csv"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"mime"
+	"net/http"
+	"strconv"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+// reportDefaultRange is how far back from its end a report goes when it has no start
+const reportDefaultRange = 30 * 24 * time.Hour
+
+// reportPeriods maps the periods completions are counted by to the first day of the period of a completion, weeks start
+// on Monday. Days are in the time zone of the database
+var reportPeriods = map[string]string{
+	"day":  "DATE(t.completed_date)",
+	"week": "DATE_SUB(DATE(t.completed_date), INTERVAL WEEKDAY(t.completed_date) DAY)",
+}
+
+// reportStats are the aggregates of TaskStats over the tasks t, bound with reportStatsArgs. Summaries aren't needed so
+// nothing is decrypted
+const reportStats = "COUNT(CASE WHEN t.status NOT IN (?, ?) THEN 1 END) AS open, " +
+	"COUNT(CASE WHEN t.status = ? AND t.completed_date >= ? AND t.completed_date < ? THEN 1 END) AS completed, " +
+	"COUNT(CASE WHEN t.status NOT IN (?, ?) AND t.due_date < ? THEN 1 END) AS overdue, " +
+	"ROUND(AVG(CASE WHEN t.status = ? AND t.completed_date >= ? AND t.completed_date < ? THEN TIMESTAMPDIFF(MINUTE, t.created_date, t.completed_date) END), 1) AS average_completion_minutes"
+
+// reportQuery is the range of a report and its format. Tasks count as completed when their completion date is in
+// [From, To), the open and overdue tasks are the ones at the time of the request
+type reportQuery struct {
+	From   *time.Time `form:"from"`
+	To     *time.Time `form:"to"`
+	Format string     `form:"format" binding:"omitempty,oneof=json csv"`
+}
+
+// completionsQuery is the range of the completions report and the period they are counted by
+type completionsQuery struct {
+	reportQuery
+	Period string `form:"period" binding:"omitempty,oneof=day week"`
+}
+
+// Completions is how many tasks the user completed in the period starting on Period, a date like 2021-10-04
+type Completions struct {
+	Period    string    `json:"period" db:"period"`
+	User      user.User `json:"user" db:"user"`
+	Completed int       `json:"completed" db:"completed"`
+}
+
+// TaskStats count the tasks that are open, completed in the range of the report and overdue. AverageCompletionMinutes
+// is how long the tasks completed took from creation, it's nil when none was completed
+type TaskStats struct {
+	Open                     int      `json:"open" db:"open"`
+	Completed                int      `json:"completed" db:"completed"`
+	Overdue                  int      `json:"overdue" db:"overdue"`
+	AverageCompletionMinutes *float64 `json:"averageCompletionMinutes" db:"average_completion_minutes"`
+}
+
+// TechnicianStats are the TaskStats of the tasks assigned to the user
+type TechnicianStats struct {
+	User user.User `json:"user" db:"user"`
+	TaskStats
+}
+
+// resolveRange sets the range to the reportDefaultRange until now when it's missing, it writes the problem and returns false
+// when the range is empty
+func (q *reportQuery) resolveRange(c *gin.Context, now time.Time) bool {
+	if q.To == nil {
+		q.To = &now
+	}
+	if q.From == nil {
+		from := q.To.Add(-reportDefaultRange)
+		q.From = &from
+	}
+	if !q.From.Before(*q.To) {
+		p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The query parameters have invalid fields")
+		p.Errors = []problem.FieldError{{Field: "from", Code: "ltfield", Message: "must be before to"}}
+		problem.Write(c, p)
+		return false
+	}
+	return true
+}
+
+func reportStatsArgs(q *reportQuery, now time.Time) []interface{} {
+	return []interface{}{StatusDone, StatusCancelled,
+		StatusDone, *q.From, *q.To,
+		StatusDone, StatusCancelled, now,
+		StatusDone, *q.From, *q.To}
+}
+
+// getCompletionsReport counts the tasks each user completed by day or week for managers, periods without completions
+// are left out
+func (s *Service) getCompletionsReport(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	if authUser.(*user.User).Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can see reports")
+		return
+	}
+	query := &completionsQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse report query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+	if !query.resolveRange(c, time.Now()) {
+		return
+	}
+	if query.Period == "" {
+		query.Period = "day"
+	}
+
+	completions, err := s.getCompletionsFromStore(util.RequestContext(c), &query.reportQuery, query.Period)
+	if err != nil {
+		logger.Warnw("Failed to get completions report from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	if query.Format != "csv" {
+		c.JSON(http.StatusOK, completions)
+		return
+	}
+	records := [][]string{{"period", "user", "completed"}}
+	for _, completion := range completions {
+		records = append(records, []string{completion.Period, csvCell(completion.User.Username), strconv.Itoa(completion.Completed)})
+	}
+	writeReportCSV(c, logger, "completions", records)
+}
+
+// getTechniciansReport has the TaskStats of every technician, and of the managers with tasks assigned, for managers
+func (s *Service) getTechniciansReport(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	if authUser.(*user.User).Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can see reports")
+		return
+	}
+	query := &reportQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse report query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+	now := time.Now()
+	if !query.resolveRange(c, now) {
+		return
+	}
+
+	stats, err := s.getTechnicianStatsFromStore(util.RequestContext(c), query, now)
+	if err != nil {
+		logger.Warnw("Failed to get technicians report from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	if query.Format != "csv" {
+		c.JSON(http.StatusOK, stats)
+		return
+	}
+	records := [][]string{{"user", "open", "completed", "overdue", "averageCompletionMinutes"}}
+	for _, technician := range stats {
+		records = append(records, append([]string{csvCell(technician.User.Username)}, technician.TaskStats.csvRecord()...))
+	}
+	writeReportCSV(c, logger, "technicians", records)
+}
+
+// getSummaryReport has the TaskStats of every task, assigned or not, for managers
+func (s *Service) getSummaryReport(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	if authUser.(*user.User).Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can see reports")
+		return
+	}
+	query := &reportQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse report query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+	now := time.Now()
+	if !query.resolveRange(c, now) {
+		return
+	}
+
+	stats, err := s.getTaskStatsFromStore(util.RequestContext(c), query, now)
+	if err != nil {
+		logger.Warnw("Failed to get summary report from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	if query.Format != "csv" {
+		c.JSON(http.StatusOK, stats)
+		return
+	}
+	writeReportCSV(c, logger, "summary", [][]string{{"open", "completed", "overdue", "averageCompletionMinutes"}, stats.csvRecord()})
+}
+
+func (s *TaskStats) csvRecord() []string {
+	averageCompletionMinutes := ""
+	if s.AverageCompletionMinutes != nil {
+		averageCompletionMinutes = strconv.FormatFloat(*s.AverageCompletionMinutes, 'f', -1, 64)
+	}
+	return []string{strconv.Itoa(s.Open), strconv.Itoa(s.Completed), strconv.Itoa(s.Overdue), averageCompletionMinutes}
+}
+
+// writeReportCSV sends the records as a CSV attachment named after the report, the first record is the header
+func writeReportCSV(c *gin.Context, logger *zap.SugaredLogger, report string, records [][]string) {
+	filename := "report-" + report + "-" + time.Now().UTC().Format("2006-01-02") + ".csv"
+	c.Header("Content-Type", exportContentTypes["csv"])
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(http.StatusOK)
+	if err := csv.NewWriter(c.Writer).WriteAll(records); err != nil {
+		logger.Infow("Failed to write report", "error", err)
+	}
+}
@@ -0,0 +1,471 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+	"net/http"
+	"strconv"
+	"strings"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/tracing"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const (
+	// scheduleBatchSize is how many schedules each transaction locks to create their tasks
+	scheduleBatchSize = 10
+	// maxOccurrencesPerBatch is how many tasks of a schedule each transaction creates, schedules with more occurrences
+	// in the horizon are picked again by the next one
+	maxOccurrencesPerBatch = 100
+)
+
+// schedule is a recurring task, a task with its fields is created for every occurrence of Cron in Timezone and is due
+// at the occurrence. NextRun is the next occurrence without a task and LastRun the last one with a task
+type schedule struct {
+	ID               int        `json:"id,omitempty"`
+	Summary          string     `json:"summary" binding:"required,max=2500"`
+	Cron             string     `json:"cron" binding:"required,max=255"`
+	Timezone         string     `json:"timezone" binding:"max=64"`
+	User             *user.User `json:"user"`
+	CreatedBy        *user.User `json:"createdBy,omitempty"`
+	Priority         Priority   `json:"priority" binding:"omitempty,oneof=low normal high urgent"`
+	EstimatedMinutes *int       `json:"estimatedMinutes,omitempty" binding:"omitempty,min=1"`
+	Paused           bool       `json:"paused"`
+	NextRun          *time.Time `json:"nextRun"`
+	LastRun          *time.Time `json:"lastRun"`
+}
+
+type encryptedSchedule struct {
+	ID               int        `db:"id"`
+	EncryptedSummary []byte     `db:"summary"`
+	Cron             string     `db:"cron"`
+	Timezone         string     `db:"timezone"`
+	User             *user.User `db:"user"`
+	CreatedBy        *user.User `db:"created_by"`
+	Priority         Priority   `db:"priority"`
+	EstimatedMinutes *int       `db:"estimated_minutes"`
+	Paused           bool       `db:"paused"`
+	NextRun          time.Time  `db:"next_run"`
+	LastRun          *time.Time `db:"last_run"`
+}
+
+// parseSchedule parses a standard cron expression with 5 fields, or a descriptor like @weekly, in the timezone. The
+// timezone is its own field so expressions can't set another one
+func parseSchedule(spec string, timezone string) (cron.Schedule, *time.Location, error) {
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return nil, nil, fmt.Errorf("the timezone must be set in its own field")
+	}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sched, location, nil
+}
+
+// nextRun is the first occurrence of the schedule after the last one with a task or now, whichever is later, so
+// occurrences missed while a schedule was paused are skipped and the ones with a task aren't created twice
+func (es *encryptedSchedule) nextRun(now time.Time) (time.Time, error) {
+	sched, location, err := parseSchedule(es.Cron, es.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	after := now
+	if es.LastRun != nil && es.LastRun.After(now) {
+		after = *es.LastRun
+	}
+	next := sched.Next(after.In(location))
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("the schedule never occurs")
+	}
+	return next.UTC(), nil
+}
+
+func (s *Service) encryptSchedule(ctx context.Context, sc *schedule) (*encryptedSchedule, error) {
+	summary, err := s.taskEncryptor.encrypt(ctx, []byte(sc.Summary))
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedSchedule{ID: sc.ID, EncryptedSummary: summary, Cron: sc.Cron, Timezone: sc.Timezone, User: sc.User,
+		CreatedBy: sc.CreatedBy, Priority: sc.Priority, EstimatedMinutes: sc.EstimatedMinutes}, nil
+}
+
+func (s *Service) decryptSchedule(ctx context.Context, es *encryptedSchedule) (*schedule, error) {
+	summary, err := s.taskEncryptor.decrypt(ctx, es.EncryptedSummary)
+	if err != nil {
+		return nil, err
+	}
+	nextRun := es.NextRun
+	return &schedule{ID: es.ID, Summary: string(summary), Cron: es.Cron, Timezone: es.Timezone, User: presentUser(es.User),
+		CreatedBy: presentUser(es.CreatedBy), Priority: es.Priority, EstimatedMinutes: es.EstimatedMinutes, Paused: es.Paused,
+		NextRun: &nextRun, LastRun: es.LastRun}, nil
+}
+
+// mustBindSchedule parses the schedule in the body for managers, checking its cron expression, timezone and assignee.
+// Otherwise the request is aborted with the matching problem
+func (s *Service) mustBindSchedule(c *gin.Context) (*schedule, *user.User, bool) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can manage recurring tasks")
+		return nil, nil, false
+	}
+
+	received := &schedule{}
+	if err := c.ShouldBindJSON(received); err != nil {
+		logger.Infow("Failed to parse schedule request body", "error", err)
+		problem.Binding(c, err)
+		return nil, nil, false
+	}
+	if received.Timezone == "" {
+		received.Timezone = "UTC"
+	}
+	if received.Priority == "" {
+		received.Priority = PriorityNormal
+	}
+	invalid := func(field string, code string, message string) (*schedule, *user.User, bool) {
+		p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The request body has invalid fields")
+		p.Errors = []problem.FieldError{{Field: field, Code: code, Message: message}}
+		problem.Write(c, p)
+		return nil, nil, false
+	}
+	if _, err := time.LoadLocation(received.Timezone); err != nil {
+		return invalid("timezone", "timezone", "must be an IANA time zone like Europe/Lisbon")
+	}
+	sched, location, err := parseSchedule(received.Cron, received.Timezone)
+	if err != nil {
+		return invalid("cron", "cron", err.Error())
+	} else if sched.Next(time.Now().In(location)).IsZero() {
+		return invalid("cron", "cron", "never occurs")
+	}
+	if received.User != nil {
+		assignee, err := s.userService.GetUserByID(util.RequestContext(c), received.User.ID)
+		if err == sql.ErrNoRows {
+			return invalid("user.id", "exists", fmt.Sprintf("user %d does not exist", received.User.ID))
+		} else if err != nil {
+			logger.Warnw("Failed to get assignee of schedule", "userId", received.User.ID, "error", err)
+			problem.Internal(c)
+			return nil, nil, false
+		}
+		received.User = &user.User{ID: assignee.ID, Username: assignee.Username}
+	}
+	return received, currentUser, true
+}
+
+func (s *Service) mustGetScheduleID(c *gin.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("schedule-id"))
+	if err != nil || id <= 0 {
+		s.requestLogger(c).Infow("Failed to parse schedule ID", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidScheduleID, "The schedule ID must be a positive number")
+		return 0, fmt.Errorf("invalid schedule ID %q", c.Param("schedule-id"))
+	}
+	return id, nil
+}
+
+// writeSchedule sends the schedule, or the problem when changing or getting it failed
+func (s *Service) writeSchedule(c *gin.Context, status int, id int, es *encryptedSchedule, err error) {
+	logger := s.requestLogger(c)
+	if err == sql.ErrNoRows {
+		problem.Abort(c, http.StatusNotFound, problem.CodeScheduleNotFound, fmt.Sprintf("Schedule %d does not exist", id))
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to get schedule from storage", "scheduleId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+	sc, err := s.decryptSchedule(util.RequestContext(c), es)
+	if err != nil {
+		logger.Warnw("Failed to decrypt schedule", "scheduleId", id)
+		problem.Internal(c)
+		return
+	}
+	c.JSON(status, sc)
+}
+
+// getSchedules lists every schedule for managers, paused or not
+func (s *Service) getSchedules(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	if authUser.(*user.User).Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can manage recurring tasks")
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	encryptedSchedules, err := s.getSchedulesFromStore(ctx)
+	if err != nil {
+		logger.Warnw("Failed to get schedules from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	schedules := make([]schedule, len(encryptedSchedules))
+	for i := range encryptedSchedules {
+		sc, err := s.decryptSchedule(ctx, &encryptedSchedules[i])
+		if err != nil {
+			logger.Warnw("Failed to decrypt schedule", "scheduleId", encryptedSchedules[i].ID)
+			problem.Internal(c)
+			return
+		}
+		schedules[i] = *sc
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+func (s *Service) getSchedule(c *gin.Context) {
+	authUser, _ := c.Get(util.UserContextKey)
+	if authUser.(*user.User).Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can manage recurring tasks")
+		return
+	}
+	id, err := s.mustGetScheduleID(c)
+	if err != nil {
+		return
+	}
+	es, err := s.getScheduleFromStore(util.RequestContext(c), id)
+	s.writeSchedule(c, http.StatusOK, id, es, err)
+}
+
+// createSchedule starts a series of recurring tasks, the first task is created by the scheduler once the first
+// occurrence is within its horizon
+func (s *Service) createSchedule(c *gin.Context) {
+	logger := s.requestLogger(c)
+	received, currentUser, ok := s.mustBindSchedule(c)
+	if !ok {
+		return
+	}
+	received.CreatedBy = &user.User{ID: currentUser.ID, Username: currentUser.Username}
+
+	ctx := util.RequestContext(c)
+	es, err := s.encryptSchedule(ctx, received)
+	if err != nil {
+		logger.Warnw("Failed to encrypt schedule")
+		problem.Internal(c)
+		return
+	}
+	if es.NextRun, err = es.nextRun(time.Now()); err != nil {
+		logger.Warnw("Failed to compute first occurrence of schedule", "error", err)
+		problem.Internal(c)
+		return
+	}
+	id, err := s.addScheduleToStore(ctx, es)
+	if err != nil {
+		logger.Warnw("Failed to add schedule to storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	received.ID = id
+	received.Paused = false
+	received.NextRun = &es.NextRun
+	received.LastRun = nil
+	c.JSON(http.StatusCreated, received)
+}
+
+// updateSchedule replaces the fields of the series, the tasks created from then on have the new ones. The tasks already
+// created are left as they are, and the occurrences they were created for don't get another one
+func (s *Service) updateSchedule(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetScheduleID(c)
+	if err != nil {
+		return
+	}
+	received, _, ok := s.mustBindSchedule(c)
+	if !ok {
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	edited, err := s.encryptSchedule(ctx, received)
+	if err != nil {
+		logger.Warnw("Failed to encrypt schedule", "scheduleId", id)
+		problem.Internal(c)
+		return
+	}
+	now := time.Now()
+	es, err := s.changeScheduleInStore(ctx, id, func(es *encryptedSchedule) error {
+		es.EncryptedSummary, es.Cron, es.Timezone, es.User = edited.EncryptedSummary, edited.Cron, edited.Timezone, edited.User
+		es.Priority, es.EstimatedMinutes = edited.Priority, edited.EstimatedMinutes
+		next, err := es.nextRun(now)
+		es.NextRun = next
+		return err
+	})
+	s.writeSchedule(c, http.StatusOK, id, es, err)
+}
+
+// pauseSchedule stops the series from creating tasks, the tasks already created for upcoming occurrences are kept
+func (s *Service) pauseSchedule(c *gin.Context) {
+	s.setSchedulePaused(c, true)
+}
+
+// resumeSchedule creates tasks for the series again from its next occurrence, the occurrences missed while it was
+// paused are skipped
+func (s *Service) resumeSchedule(c *gin.Context) {
+	s.setSchedulePaused(c, false)
+}
+
+func (s *Service) setSchedulePaused(c *gin.Context, paused bool) {
+	authUser, _ := c.Get(util.UserContextKey)
+	if authUser.(*user.User).Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can manage recurring tasks")
+		return
+	}
+	id, err := s.mustGetScheduleID(c)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	es, err := s.changeScheduleInStore(util.RequestContext(c), id, func(es *encryptedSchedule) error {
+		if es.Paused == paused {
+			return nil
+		}
+		es.Paused = paused
+		if paused {
+			return nil
+		}
+		next, err := es.nextRun(now)
+		es.NextRun = next
+		return err
+	})
+	s.writeSchedule(c, http.StatusOK, id, es, err)
+}
+
+// CreateScheduledTasks creates the tasks of the occurrences of every schedule that isn't paused from now until horizon,
+// each due at its occurrence. Schedules are locked while their tasks are created and replicas skip the locked ones, so
+// running it on every replica doesn't create a task twice. A schedule whose tasks can't be created is logged and left
+// out of the rest of the run, so it doesn't stop the others, and is tried again on the next run
+func (s *Service) CreateScheduledTasks(ctx context.Context, horizon time.Duration) (created int, err error) {
+	ctx, span := tracing.Start(ctx, "CreateScheduledTasks")
+	defer func() { tracing.End(span, err) }()
+
+	until := time.Now().Add(horizon)
+	var failed []int
+	for {
+		batch, batchFailed, err := s.createScheduledTasksBatch(ctx, until, failed)
+		created += batch
+		failed = append(failed, batchFailed...)
+		if err != nil || (batch == 0 && len(batchFailed) == 0) {
+			return created, err
+		}
+	}
+}
+
+// createScheduledTasksBatch creates the tasks of up to scheduleBatchSize schedules in a transaction, schedules locked by
+// another replica and the ones in skip are left out. Each schedule has its own savepoint, so one that fails is rolled
+// back alone and returned with the failed ones. It returns how many tasks were created, 0 once no schedule is left
+func (s *Service) createScheduledTasksBatch(ctx context.Context, until time.Time, skip []int) (int, []int, error) {
+	defer metrics.ObserveQuery("createScheduledTasksBatch")()
+	logger := logging.FromContext(ctx, s.logger)
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	query, args := selectSchedules+" WHERE sc.paused = FALSE AND sc.next_run <= ?", []interface{}{until}
+	if len(skip) > 0 {
+		query, args, err = sqlx.In(query+" AND sc.id NOT IN (?)", until, skip)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	var schedules []encryptedSchedule
+	err = tx.SelectContext(ctx, &schedules, query+" ORDER BY sc.next_run LIMIT ? FOR UPDATE OF sc SKIP LOCKED;", append(args, scheduleBatchSize)...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	created := 0
+	var failed []int
+	for i := range schedules {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT schedule;"); err != nil {
+			return 0, nil, err
+		}
+		count, err := s.createTasksOfScheduleInTx(ctx, tx, &schedules[i], until)
+		if err != nil {
+			logger.Warnw("Failed to create the tasks of a schedule, it's skipped", "scheduleId", schedules[i].ID, "error", err)
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT schedule;"); err != nil {
+				return 0, nil, err
+			}
+			failed = append(failed, schedules[i].ID)
+			continue
+		}
+		created += count
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+	return created, failed, nil
+}
+
+// createTasksOfScheduleInTx creates the tasks of the occurrences of the schedule until then, at most
+// maxOccurrencesPerBatch, and moves the schedule past them
+func (s *Service) createTasksOfScheduleInTx(ctx context.Context, tx *sqlx.Tx, es *encryptedSchedule, until time.Time) (int, error) {
+	summary, err := s.taskEncryptor.decrypt(ctx, es.EncryptedSummary)
+	if err != nil {
+		return 0, err
+	}
+	sched, location, err := parseSchedule(es.Cron, es.Timezone)
+	if err != nil {
+		return 0, err
+	}
+
+	var tasks []*encryptedTask
+	occurrence := es.NextRun
+	for !occurrence.IsZero() && !occurrence.After(until) && len(tasks) < maxOccurrencesPerBatch {
+		scheduleID, scheduledFor := es.ID, occurrence.UTC()
+		t := &task{Summary: string(summary), User: presentUser(es.User), CreatedBy: es.CreatedBy, DueDate: &scheduledFor,
+			Priority: es.Priority, EstimatedMinutes: es.EstimatedMinutes}
+		et, err := s.encryptTask(ctx, t)
+		if err != nil {
+			return 0, err
+		}
+		et.ScheduleID, et.ScheduledFor = &scheduleID, &scheduledFor
+		tasks = append(tasks, et)
+		occurrence = sched.Next(occurrence.In(location))
+	}
+	if err := addTasksInTx(ctx, tx, tasks); err != nil {
+		return 0, err
+	}
+	lastRun, nextRun := tasks[len(tasks)-1].ScheduledFor, occurrence.UTC()
+	// A schedule without occurrences left is paused rather than picked again
+	paused := occurrence.IsZero()
+	if paused {
+		nextRun = *lastRun
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE task_schedules SET next_run = ?, last_run = ?, paused = ? WHERE id = ?;",
+		nextRun, lastRun, paused, es.ID); err != nil {
+		return 0, err
+	}
+	return len(tasks), nil
+}
+
+// RunRecurringScheduler creates the tasks of the schedules every interval, horizon ahead, until the context is cancelled
+func (s *Service) RunRecurringScheduler(ctx context.Context, interval time.Duration, horizon time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			created, err := s.CreateScheduledTasks(ctx, horizon)
+			if err != nil {
+				s.logger.Warnw("Failed to create scheduled tasks", "error", err)
+			} else if created > 0 {
+				s.logger.Infow("Created scheduled tasks", "tasks", created)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
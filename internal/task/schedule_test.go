@@ -0,0 +1,116 @@
+package task
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+const lockSchedulesSQL = "SELECT sc.id, .+ FROM task_schedules sc .+ WHERE sc.paused = FALSE AND sc.next_run <= .+ ORDER BY sc.next_run LIMIT .+ FOR UPDATE OF sc SKIP LOCKED;"
+const finishScheduleRunSQL = "UPDATE task_schedules SET next_run = .+, last_run = .+, paused = .+ WHERE id = .+;"
+const scheduleSavepointSQL = "SAVEPOINT schedule;"
+const rollbackScheduleSQL = "ROLLBACK TO SAVEPOINT schedule;"
+
+var scheduleColumns = []string{"id", "summary", "cron", "timezone", "user.id", "user.username", "created_by.id", "created_by.username", "priority", "estimated_minutes", "paused", "next_run", "last_run"}
+
+func TestParseSchedule(t *testing.T) {
+	_, _, err := parseSchedule("0 9 * * 1", "Europe/Lisbon")
+	assert.Nil(t, err)
+	_, _, err = parseSchedule("@weekly", "UTC")
+	assert.Nil(t, err)
+
+	for _, invalid := range [][2]string{
+		{"every monday", "UTC"},
+		{"0 0 9 * * 1", "UTC"},
+		{"CRON_TZ=Asia/Tokyo 0 9 * * 1", "UTC"},
+		{"0 9 * * 1", "Mars/Olympus_Mons"},
+	} {
+		_, _, err := parseSchedule(invalid[0], invalid[1])
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestNextRunIsInTheTimezoneOfTheSchedule(t *testing.T) {
+	// A Tuesday, Lisbon is an hour ahead of UTC until the end of October
+	now := time.Date(2021, 10, 19, 12, 0, 0, 0, time.UTC)
+	es := &encryptedSchedule{Cron: "0 9 * * 1", Timezone: "Europe/Lisbon"}
+
+	next, err := es.nextRun(now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 10, 25, 8, 0, 0, 0, time.UTC), next)
+}
+
+func TestNextRunDoesntRepeatOccurrencesWithTasks(t *testing.T) {
+	now := time.Date(2021, 10, 19, 12, 0, 0, 0, time.UTC)
+	lastRun := time.Date(2021, 11, 1, 9, 0, 0, 0, time.UTC)
+	es := &encryptedSchedule{Cron: "0 9 * * 1", Timezone: "UTC", LastRun: &lastRun}
+
+	next, err := es.nextRun(now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2021, 11, 8, 9, 0, 0, 0, time.UTC), next)
+}
+
+func (s *TaskAPITestSuite) TestCreateScheduledTasksUntilTheHorizon() {
+	first := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	second, third := first.Add(24*time.Hour), first.Add(48*time.Hour)
+	summary, _ := s.tEncryptor.encrypt(context.Background(), []byte("calibrate device"))
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockSchedulesSQL).WithArgs(sqlmock.AnyArg(), scheduleBatchSize).
+		WillReturnRows(sqlmock.NewRows(scheduleColumns).AddRow(7, summary, "@daily", "UTC", 1, "joel", 2, "dvn", "normal", nil, false, first, nil))
+	s.sqlmock.ExpectExec(scheduleSavepointSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectExec(importTasksSQL).
+		WithArgs(1, sqlmock.AnyArg(), 2, first, PriorityNormal, nil, 7, first, 1, sqlmock.AnyArg(), 2, second, PriorityNormal, nil, 7, second).
+		WillReturnResult(sqlmock.NewResult(20, 2))
+	s.sqlmock.ExpectQuery(insertedTaskIDsSQL).WithArgs(20, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(taskIDRows(20, 22))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 8))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 4))
+	s.sqlmock.ExpectExec(finishScheduleRunSQL).WithArgs(third, second, false, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
+	// The next batch finds no schedule left, or only ones locked by other replicas
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockSchedulesSQL).WillReturnRows(sqlmock.NewRows(scheduleColumns))
+	s.sqlmock.ExpectCommit()
+
+	created, err := s.service.CreateScheduledTasks(context.Background(), 48*time.Hour)
+
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, created)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestCreateScheduledTasksSkipsFailingSchedules() {
+	first := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	summary, _ := s.tEncryptor.encrypt(context.Background(), []byte("calibrate device"))
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockSchedulesSQL).WithArgs(sqlmock.AnyArg(), scheduleBatchSize).
+		WillReturnRows(sqlmock.NewRows(scheduleColumns).
+			AddRow(6, []byte("not encrypted"), "@daily", "UTC", 1, "joel", 2, "dvn", "normal", nil, false, first, nil).
+			AddRow(7, summary, "@daily", "UTC", 1, "joel", 2, "dvn", "normal", nil, false, first, nil))
+	// The schedule that can't be decrypted is rolled back alone and the next one still gets its task
+	s.sqlmock.ExpectExec(scheduleSavepointSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectExec(rollbackScheduleSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectExec(scheduleSavepointSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectExec(importTasksSQL).
+		WithArgs(1, sqlmock.AnyArg(), 2, first, PriorityNormal, nil, 7, first).WillReturnResult(sqlmock.NewResult(20, 1))
+	s.sqlmock.ExpectQuery(insertedTaskIDsSQL).WithArgs(20, sqlmock.AnyArg()).WillReturnRows(taskIDRows(20))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 4))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.sqlmock.ExpectExec(finishScheduleRunSQL).WithArgs(first.Add(24*time.Hour), first, false, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
+	// The next batch leaves out the failed schedule instead of picking it first again
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockSchedulesSQL).WithArgs(sqlmock.AnyArg(), 6, scheduleBatchSize).WillReturnRows(sqlmock.NewRows(scheduleColumns))
+	s.sqlmock.ExpectCommit()
+
+	created, err := s.service.CreateScheduledTasks(context.Background(), 24*time.Hour)
+
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, created)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
@@ -0,0 +1,177 @@
+package task
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+	"net/http"
+	"strings"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"unicode"
+)
+
+// rebuildBatchSize is how many tasks have their tokens inserted with each statement while rebuilding the index
+const rebuildBatchSize = 100
+
+// searchIndex is a blind index of the summaries, it keeps a keyed HMAC of every word of a summary instead of the word
+// so tasks can be searched by word without storing any plaintext. Equal words have equal tokens, so the index reveals
+// which tasks share words but not the words themselves
+type searchIndex struct {
+	key []byte
+}
+
+// searchQuery has the filters of GET /tasks and the words searched for
+type searchQuery struct {
+	taskQuery
+	Q string `form:"q" binding:"required,max=200"`
+}
+
+func newSearchIndex(key string) (*searchIndex, error) {
+	decoded, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < 16 {
+		return nil, fmt.Errorf("search key must be at least 16 bytes long, got %d", len(decoded))
+	}
+	return &searchIndex{key: decoded}, nil
+}
+
+// tokens are the tokens of the distinct words of the text
+func (i *searchIndex) tokens(text string) [][]byte {
+	words := searchWords(text)
+	tokens := make([][]byte, len(words))
+	for j, word := range words {
+		mac := hmac.New(sha256.New, i.key)
+		mac.Write([]byte(word))
+		tokens[j] = mac.Sum(nil)
+	}
+	return tokens
+}
+
+// searchWords splits the text into its distinct words in lower case and without accents, so "Válvula" matches
+// "valvula". Anything that isn't a letter or a digit separates words
+func searchWords(text string) []string {
+	withoutAccents := strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		return r
+	}, norm.NFKD.String(strings.ToLower(text)))
+
+	var words []string
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(withoutAccents, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// encryptTask encrypts the summary of the task and computes its tokens for the search index
+func (s *Service) encryptTask(ctx context.Context, t *task) (*encryptedTask, error) {
+	et, err := s.taskEncryptor.encryptTask(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	et.SearchTokens = s.searchIndex.tokens(t.Summary)
+	return et, nil
+}
+
+// searchTasks returns the tasks GET /tasks would return that have every word of the query in their summary, as whole
+// words. Technicians only find their own tasks
+func (s *Service) searchTasks(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+
+	query := &searchQuery{}
+	if err := c.ShouldBindQuery(query); err != nil {
+		logger.Infow("Failed to parse search query", "error", err)
+		problem.Query(c, err)
+		return
+	}
+	query.SearchTokens = s.searchIndex.tokens(query.Q)
+	if len(query.SearchTokens) == 0 {
+		p := problem.New(http.StatusBadRequest, problem.CodeValidationFailed, "The query parameters have invalid fields")
+		p.Errors = []problem.FieldError{{Field: "q", Code: "words", Message: "must have a letter or a digit"}}
+		problem.Write(c, p)
+		return
+	}
+	if currentUser.Role.Name != util.AdminRole {
+		query.UserID = &currentUser.ID
+	}
+
+	ctx := util.RequestContext(c)
+	encryptedTasks, err := s.getTasksFromStore(ctx, &query.taskQuery)
+	if err != nil {
+		logger.Warnw("Failed to search tasks in storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+	tasks := make([]task, len(encryptedTasks))
+	for i, t := range encryptedTasks {
+		t := t
+		decryptedTask, err := s.taskEncryptor.decryptTask(ctx, &t, currentUser.ID)
+		if err != nil {
+			logger.Warnw("Failed to decrypt task")
+			problem.Internal(c)
+			return
+		}
+		tasks[i] = *decryptedTask
+	}
+	c.JSON(http.StatusOK, tasks)
+}
+
+// RebuildSearchIndex replaces the tokens of every task, including the ones in the trash, with tokens computed with the
+// current key in a single transaction. It's needed after the search key changes, until then searches find nothing
+func (s *Service) RebuildSearchIndex(ctx context.Context) (int, error) {
+	defer metrics.ObserveQuery("RebuildSearchIndex")()
+	logger := logging.FromContext(ctx, s.logger)
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var tasks []encryptedTask
+	if err := tx.SelectContext(ctx, &tasks, "SELECT t.id, t.summary FROM tasks t FOR UPDATE;"); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM task_search_tokens;"); err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(tasks); start += rebuildBatchSize {
+		end := start + rebuildBatchSize
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		batch := tasks[start:end]
+		for i := range batch {
+			summary, err := s.taskEncryptor.decrypt(ctx, batch[i].EncryptedSummary)
+			if err != nil {
+				logger.Warnw("Failed to decrypt summary while rebuilding search index", "taskId", batch[i].ID)
+				return 0, err
+			}
+			batch[i].SearchTokens = s.searchIndex.tokens(string(summary))
+		}
+		if err := addSearchTokensInTx(ctx, tx, batch); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(tasks), nil
+}
@@ -0,0 +1,65 @@
+package task
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestSearchWordsAreNormalized(t *testing.T) {
+	assert.Equal(t, []string{"valvula", "da", "bomba", "3"}, searchWords("Válvula da BOMBA 3, valvula!"))
+	assert.Empty(t, searchWords(" -- !"))
+}
+
+func TestSearchTokensDependOnTheKey(t *testing.T) {
+	index, _ := newSearchIndex("7365617263682074686520696e646578")
+	otherIndex, _ := newSearchIndex("6368616e676520746869732070617373")
+
+	assert.Equal(t, index.tokens("Filter"), index.tokens("filter"))
+	assert.Len(t, index.tokens("replace the filter")[0], 32)
+	assert.NotEqual(t, index.tokens("filter"), otherIndex.tokens("filter"))
+}
+
+func TestSearchKeyMustBeLongEnough(t *testing.T) {
+	_, err := newSearchIndex("1a2b")
+	assert.NotNil(t, err)
+}
+
+func TestQueryWithSearchTokens(t *testing.T) {
+	q := &taskQuery{SearchTokens: [][]byte{[]byte("a"), []byte("b")}}
+
+	where, args := q.where(time.Now())
+
+	assert.Equal(t, " WHERE t.deleted_at IS NULL AND t.id IN (SELECT st.task_id FROM task_search_tokens st WHERE st.token IN (?, ?) GROUP BY st.task_id HAVING COUNT(*) = ?) ORDER BY t.id", where)
+	assert.Equal(t, []interface{}{[]byte("a"), []byte("b"), 2}, args)
+}
+
+func TestRebuildSearchIndexReplacesEveryToken(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	t.Cleanup(func() {
+		db.Close()
+	})
+	encryptor, _ := NewCrypto("6368616e676520746869732070617373", zap.NewNop().Sugar())
+	index, _ := newSearchIndex("7365617263682074686520696e646578")
+	service := &Service{db: sqlx.NewDb(db, "mysql"), logger: zap.NewNop().Sugar(), taskEncryptor: encryptor, searchIndex: index}
+
+	filter, _ := encryptor.encryptTask(context.Background(), &task{Summary: "replace filter"})
+	empty, _ := encryptor.encryptTask(context.Background(), &task{})
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT t.id, t.summary FROM tasks t FOR UPDATE;").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "summary"}).AddRow(1, filter.EncryptedSummary).AddRow(2, empty.EncryptedSummary))
+	mock.ExpectExec("DELETE FROM task_search_tokens;").WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("INSERT INTO task_search_tokens \\(task_id, token\\) VALUES \\(\\?, \\?\\), \\(\\?, \\?\\);").
+		WithArgs(1, index.tokens("replace")[0], 1, index.tokens("filter")[0]).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	rebuilt, err := service.RebuildSearchIndex(context.Background())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rebuilt)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
@@ -4,33 +4,79 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
+	"sword-challenge/internal/blob"
+	"sword-challenge/internal/eventbus"
 	"sword-challenge/internal/user"
 )
 
 type Service struct {
-	db            *sqlx.DB
-	logger        *zap.SugaredLogger
-	userService   *user.Service
-	taskPublisher Publisher
-	taskEncryptor *taskCrypto
-}
+	db                 *sqlx.DB
+	logger             *zap.SugaredLogger
+	userService        *user.Service
+	taskPublisher      eventbus.Publisher
+	notificationsTopic string
+	taskEncryptor      *taskCrypto
+	searchIndex        *searchIndex
+
+	// Attachments are stored encrypted in Blobs, uploads are limited to MaxAttachmentSize bytes and the AttachmentTypes
+	Blobs             blob.Store
+	MaxAttachmentSize int64
+	AttachmentTypes   []string
 
-type Publisher interface {
-	PublishTask(t Notification) error
+	// RequireIfMatch refuses changes to tasks without the ETag they were read at, so they can't overwrite each other
+	RequireIfMatch bool
 }
 
-func NewService(userService *user.Service, db *sqlx.DB, taskPublisher Publisher, logger *zap.SugaredLogger, key string) *Service {
-	c, err := NewCrypto(key, logger)
+func NewService(userService *user.Service, db *sqlx.DB, taskPublisher eventbus.Publisher, notificationsTopic string, logger *zap.SugaredLogger, key string, previousKey string, searchKey string) *Service {
+	c, err := newRotatingCrypto(key, previousKey, logger)
 	if err != nil {
 		logger.Fatalw("Failed to create task encryptor", "error", err)
 	}
-	taskService := &Service{userService: userService, db: db, taskPublisher: taskPublisher, taskEncryptor: c, logger: logger}
+	index, err := newSearchIndex(searchKey)
+	if err != nil {
+		logger.Fatalw("Failed to create task search index", "error", err)
+	}
+	taskService := &Service{userService: userService, db: db, taskPublisher: taskPublisher, notificationsTopic: notificationsTopic, taskEncryptor: c, searchIndex: index, logger: logger}
 	return taskService
 }
 
 func (s *Service) SetupRoutes(router *gin.RouterGroup) {
 	router.GET("/tasks", s.getTasks)
+	router.GET("/tasks/:task-id", s.getTask)
 	router.PUT("/tasks/:task-id", s.updateTask)
+	router.PATCH("/tasks/:task-id", s.patchTask)
 	router.DELETE("/tasks/:task-id", s.deleteTask)
+	router.GET("/tasks/unassigned", s.getUnassignedTasks)
+	router.GET("/tasks/trash", s.getTrash)
+	router.GET("/tasks/export", s.exportTasks)
+	router.POST("/tasks/import", s.importTasks)
+	router.GET("/tasks/search", s.searchTasks)
+	router.POST("/tasks/:task-id/restore", s.restoreTask)
+	router.POST("/tasks/:task-id/claim", s.claimTask)
+	router.PUT("/tasks/:task-id/assignee", s.reassignTask)
+	router.DELETE("/tasks/:task-id/assignee", s.reassignTask)
+	router.GET("/tasks/:task-id/transitions", s.getTransitions)
+	router.POST("/tasks/:task-id/transitions", s.transitionTask)
+	router.GET("/tasks/:task-id/history", s.getHistory)
+	router.GET("/tasks/:task-id/comments", s.getComments)
+	router.POST("/tasks/:task-id/comments", s.createComment)
+	router.PUT("/tasks/:task-id/comments/:comment-id", s.updateComment)
+	router.DELETE("/tasks/:task-id/comments/:comment-id", s.deleteComment)
+	router.GET("/tasks/:task-id/attachments", s.getAttachments)
+	router.POST("/tasks/:task-id/attachments", s.uploadAttachment)
+	router.GET("/tasks/:task-id/attachments/:attachment-id", s.downloadAttachment)
+	router.DELETE("/tasks/:task-id/attachments/:attachment-id", s.deleteAttachment)
 	router.POST("/tasks", s.createTask)
+	router.GET("/schedules", s.getSchedules)
+	router.POST("/schedules", s.createSchedule)
+	router.GET("/schedules/:schedule-id", s.getSchedule)
+	router.PUT("/schedules/:schedule-id", s.updateSchedule)
+	router.POST("/schedules/:schedule-id/pause", s.pauseSchedule)
+	router.POST("/schedules/:schedule-id/resume", s.resumeSchedule)
+	router.GET("/reports/completions", s.getCompletionsReport)
+	router.GET("/reports/technicians", s.getTechniciansReport)
+	router.GET("/reports/summary", s.getSummaryReport)
+	// Also served as /tasks:batch, a custom method in the style of Google APIs that gin can't route, see
+	// SwordChallengeServer.Handler
+	router.POST("/tasks/batch", s.batchTasks)
 }
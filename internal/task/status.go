@@ -0,0 +1,74 @@
+package task
+
+import (
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+type Status string
+
+const (
+	StatusTodo       Status = "todo"
+	StatusInProgress Status = "in_progress"
+	StatusBlocked    Status = "blocked"
+	StatusInReview   Status = "in_review"
+	StatusDone       Status = "done"
+	StatusCancelled  Status = "cancelled"
+)
+
+// managerTransitions is the whole state machine, managers can make any of these moves
+var managerTransitions = map[Status][]Status{
+	StatusTodo:       {StatusInProgress, StatusBlocked, StatusCancelled},
+	StatusInProgress: {StatusTodo, StatusBlocked, StatusInReview, StatusDone, StatusCancelled},
+	StatusBlocked:    {StatusInProgress, StatusCancelled},
+	StatusInReview:   {StatusInProgress, StatusDone, StatusCancelled},
+	StatusDone:       {StatusInProgress},
+	StatusCancelled:  {StatusTodo},
+}
+
+// technicianTransitions are the moves a technician makes while working on a task, reviewing, cancelling and reopening
+// are left to the managers
+var technicianTransitions = map[Status][]Status{
+	StatusTodo:       {StatusInProgress},
+	StatusInProgress: {StatusBlocked, StatusInReview, StatusDone},
+	StatusBlocked:    {StatusInProgress},
+}
+
+type transition struct {
+	From        Status     `json:"from" db:"from_status"`
+	To          Status     `json:"to" db:"to_status"`
+	User        *user.User `json:"user" db:"user"`
+	CreatedDate *time.Time `json:"createdDate" db:"created_date"`
+}
+
+type transitionRequest struct {
+	Status Status `json:"status" binding:"required,oneof=todo in_progress blocked in_review done cancelled"`
+}
+
+func canTransition(role string, from, to Status) bool {
+	transitions := technicianTransitions
+	if role == util.AdminRole {
+		transitions = managerTransitions
+	}
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// completedDateAfter keeps completedDate in sync with the status, it's set when the task reaches done and cleared when
+// it's reopened
+func completedDateAfter(from, to Status, current *time.Time) *time.Time {
+	switch {
+	case to == StatusDone:
+		now := time.Now().UTC()
+		return &now
+	case from == StatusDone:
+		return nil
+	default:
+		return current
+	}
+}
@@ -0,0 +1,37 @@
+package task
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sword-challenge/internal/util"
+	"testing"
+	"time"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		role     string
+		from, to Status
+		allowed  bool
+	}{
+		{"technician", StatusTodo, StatusInProgress, true},
+		{"technician", StatusInProgress, StatusDone, true},
+		{"technician", StatusTodo, StatusDone, false},
+		{"technician", StatusInReview, StatusDone, false},
+		{"technician", StatusDone, StatusInProgress, false},
+		{util.AdminRole, StatusInReview, StatusDone, true},
+		{util.AdminRole, StatusDone, StatusInProgress, true},
+		{util.AdminRole, StatusCancelled, StatusDone, false},
+		{util.AdminRole, StatusTodo, StatusTodo, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.allowed, canTransition(c.role, c.from, c.to), "%s %s -> %s", c.role, c.from, c.to)
+	}
+}
+
+func TestCompletedDateFollowsTheStatus(t *testing.T) {
+	completed := time.Date(2011, 1, 1, 1, 1, 1, 0, time.UTC)
+
+	assert.NotNil(t, completedDateAfter(StatusInProgress, StatusDone, nil))
+	assert.Nil(t, completedDateAfter(StatusDone, StatusInProgress, &completed))
+	assert.Equal(t, &completed, completedDateAfter(StatusInProgress, StatusBlocked, &completed))
+}
@@ -1,46 +1,733 @@
 package task
 
-func (s *Service) deleteTaskFromStore(id int) (int, error) {
-	res, err := s.db.Exec("DELETE FROM tasks t WHERE t.id = ?;", id)
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"strings"
+	"sword-challenge/internal/metrics"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const (
+	taskSelection = "t.id, t.summary, t.status, t.completed_date, COALESCE(u.id, 0) as 'user.id', COALESCE(u.username, '') as 'user.username', COALESCE(cb.id, 0) as 'created_by.id', COALESCE(cb.username, '') as 'created_by.username', t.due_date, t.priority, t.estimated_minutes, t.version"
+	taskJoins     = " FROM tasks t LEFT JOIN users u on t.user_id = u.id LEFT JOIN users cb on t.created_by = cb.id"
+
+	// selectTasks loads the assignee as the user, unassigned tasks and tasks without a creator get zero users which are
+	// dropped when decrypting. Queries must exclude the deleted tasks unless they are looking at the trash
+	selectTasks = "SELECT " + taskSelection + taskJoins
+	// selectDeletedTasks also loads when the task was moved to the trash
+	selectDeletedTasks = "SELECT " + taskSelection + ", t.deleted_at" + taskJoins
+
+	// selectSchedules loads the assignee as the user like selectTasks
+	selectSchedules = "SELECT sc.id, sc.summary, sc.cron, sc.timezone, COALESCE(u.id, 0) as 'user.id', COALESCE(u.username, '') as 'user.username', COALESCE(cb.id, 0) as 'created_by.id', COALESCE(cb.username, '') as 'created_by.username', sc.priority, sc.estimated_minutes, sc.paused, sc.next_run, sc.last_run FROM task_schedules sc LEFT JOIN users u on sc.user_id = u.id LEFT JOIN users cb on sc.created_by = cb.id"
+)
+
+// errAlreadyAssigned means the task left the unassigned pool before it could be claimed
+var errAlreadyAssigned = errors.New("task is already assigned")
+
+// errStatusChanged means the status of the task is no longer the one the transition was checked against
+var errStatusChanged = errors.New("task status changed concurrently")
+
+// errVersionMismatch means the task changed since the version the client sent in If-Match
+var errVersionMismatch = errors.New("task version doesn't match")
+
+// deleteTaskFromStore moves the task to the trash, it can be restored until it's purged. errVersionMismatch is returned
+// if ifVersion is set and the task is at another version
+func (s *Service) deleteTaskFromStore(ctx context.Context, id int, ifVersion *int, userID int) (int, error) {
+	defer metrics.ObserveQuery("deleteTaskFromStore")()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return 0, err
 	}
-	affected, err := res.RowsAffected()
-	if err != nil {
+	defer tx.Rollback()
+
+	deleted, err := deleteTaskInTx(ctx, tx, id, ifVersion, userID)
+	if err != nil || deleted == 0 {
 		return 0, err
 	}
-	return int(affected), nil
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
 }
 
-func (s *Service) getTaskFromStore(id int) (*encryptedTask, error) {
+// deleteTaskInTx is deleteTaskFromStore in a transaction of the caller
+func deleteTaskInTx(ctx context.Context, tx *sqlx.Tx, id int, ifVersion *int, userID int) (int, error) {
+	locked, err := lockTaskInTx(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if ifVersion != nil && locked.Version != *ifVersion {
+		return 0, errVersionMismatch
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET deleted_at = ?, version = version + 1 WHERE id = ?;", now, id); err != nil {
+		return 0, err
+	}
+	deletedAt := []byte(now.Format(time.RFC3339))
+	if err := addHistoryInTx(ctx, tx, id, userID, historyDeleted, []fieldChange{{Field: "deletedAt", After: deletedAt}}); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// getDeletedTasksFromStore returns the tasks in the trash, the last deleted first
+func (s *Service) getDeletedTasksFromStore(ctx context.Context) ([]encryptedDeletedTask, error) {
+	defer metrics.ObserveQuery("getDeletedTasksFromStore")()
+	tasks := []encryptedDeletedTask{}
+	err := s.db.SelectContext(ctx, &tasks, selectDeletedTasks+" WHERE t.deleted_at IS NOT NULL ORDER BY t.deleted_at DESC, t.id;")
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// restoreTaskFromStore takes the task out of the trash, sql.ErrNoRows is returned when it isn't in the trash
+func (s *Service) restoreTaskFromStore(ctx context.Context, id int, userID int) (*encryptedTask, error) {
+	defer metrics.ObserveQuery("restoreTaskFromStore")()
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var deletedAt time.Time
+	if err := tx.GetContext(ctx, &deletedAt, "SELECT t.deleted_at FROM tasks t WHERE t.id = ? AND t.deleted_at IS NOT NULL FOR UPDATE;", id); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE tasks SET deleted_at = NULL, version = version + 1 WHERE id = ?;", id); err != nil {
+		return nil, err
+	}
+	before := []byte(deletedAt.UTC().Format(time.RFC3339))
+	if err := addHistoryInTx(ctx, tx, id, userID, historyRestored, []fieldChange{{Field: "deletedAt", Before: before}}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return s.getTaskFromStore(ctx, id)
+}
+
+// getPurgeableTasksFromStore returns the IDs of the tasks deleted before the date
+func (s *Service) getPurgeableTasksFromStore(ctx context.Context, deletedBefore time.Time) ([]int, error) {
+	defer metrics.ObserveQuery("getPurgeableTasksFromStore")()
+	ids := []int{}
+	err := s.db.SelectContext(ctx, &ids, "SELECT t.id FROM tasks t WHERE t.deleted_at < ? ORDER BY t.id;", deletedBefore)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// purgeTaskFromStore deletes the task with its transitions, comments, attachments and search tokens for good and returns the keys of
+// the attachment files, which are left to the caller. The history of the task is kept. Nothing is deleted if the task was
+// restored or purged by another replica in the meantime, then purged is false
+func (s *Service) purgeTaskFromStore(ctx context.Context, id int, deletedBefore time.Time) (purged bool, attachmentKeys []string, err error) {
+	defer metrics.ObserveQuery("purgeTaskFromStore")()
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	defer tx.Rollback()
+
+	var found int
+	err = tx.GetContext(ctx, &found, "SELECT t.id FROM tasks t WHERE t.id = ? AND t.deleted_at < ? FOR UPDATE;", id, deletedBefore)
+	if err == sql.ErrNoRows {
+		return false, nil, nil
+	} else if err != nil {
+		return false, nil, err
+	}
+	if err := tx.SelectContext(ctx, &attachmentKeys, "SELECT a.storage_key FROM task_attachments a WHERE a.task_id = ?;", id); err != nil {
+		return false, nil, err
+	}
+	for _, query := range []string{
+		"DELETE FROM task_attachments WHERE task_id = ?;",
+		"DELETE FROM task_comments WHERE task_id = ?;",
+		"DELETE FROM task_transitions WHERE task_id = ?;",
+		"DELETE FROM task_search_tokens WHERE task_id = ?;",
+		"DELETE FROM tasks WHERE id = ?;",
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return false, nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, nil, err
+	}
+	return true, attachmentKeys, nil
+}
+
+func (s *Service) getTaskFromStore(ctx context.Context, id int) (*encryptedTask, error) {
+	defer metrics.ObserveQuery("getTaskFromStore")()
 	task := &encryptedTask{}
-	err := s.db.Get(task, "SELECT t.id, t.summary, t.completed_date, u.id as 'user.id', u.username as 'user.username' FROM tasks t INNER JOIN users u on t.user_id = u.id WHERE t.id = ?;", id)
+	err := s.db.GetContext(ctx, task, selectTasks+" WHERE t.id = ? AND t.deleted_at IS NULL;", id)
 	if err != nil {
 		return nil, err
 	}
 	return task, nil
 }
 
-func (s *Service) getTasksFromStore(id int) ([]encryptedTask, error) {
+func (s *Service) getTasksFromStore(ctx context.Context, q *taskQuery) ([]encryptedTask, error) {
+	defer metrics.ObserveQuery("getTasksFromStore")()
 	task := []encryptedTask{}
-	err := s.db.Select(&task, "SELECT t.id, t.summary, t.completed_date, u.id as 'user.id', u.username as 'user.username' FROM tasks t INNER JOIN users u on t.user_id = u.id WHERE t.user_id = ?;", id)
+	where, args := q.where(time.Now().UTC())
+	err := s.db.SelectContext(ctx, &task, selectTasks+where+";", args...)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// streamTasksFromStore is getTasksFromStore without loading every task in memory, the caller must close the rows
+func (s *Service) streamTasksFromStore(ctx context.Context, q *taskQuery) (*sqlx.Rows, error) {
+	defer metrics.ObserveQuery("streamTasksFromStore")()
+	where, args := q.where(time.Now().UTC())
+	return s.db.QueryxContext(ctx, selectTasks+where+";", args...)
+}
+
+// addExportToStore records that the user is exporting the tasks matching the filters, before any of them is sent
+func (s *Service) addExportToStore(ctx context.Context, userID int, format string, filters []byte) (int, error) {
+	defer metrics.ObserveQuery("addExportToStore")()
+	result, err := s.db.ExecContext(ctx, "INSERT INTO task_exports (user_id, format, filters) VALUES (?, ?, ?);", userID, format, filters)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// finishExportInStore records how many tasks the export sent
+func (s *Service) finishExportInStore(ctx context.Context, id int, rowCount int) error {
+	defer metrics.ObserveQuery("finishExportInStore")()
+	_, err := s.db.ExecContext(ctx, "UPDATE task_exports SET row_count = ?, finished_date = CURRENT_TIMESTAMP WHERE id = ?;", rowCount, id)
+	return err
+}
+
+// getCompletionsFromStore counts the tasks completed in the range of the query by user and period, see reportPeriods
+func (s *Service) getCompletionsFromStore(ctx context.Context, q *reportQuery, period string) ([]Completions, error) {
+	defer metrics.ObserveQuery("getCompletionsFromStore")()
+	completions := []Completions{}
+	err := s.db.SelectContext(ctx, &completions, "SELECT DATE_FORMAT("+reportPeriods[period]+", '%Y-%m-%d') AS period, u.id AS 'user.id', u.username AS 'user.username', COUNT(*) AS completed "+
+		"FROM tasks t INNER JOIN users u ON t.user_id = u.id WHERE t.status = ? AND t.completed_date >= ? AND t.completed_date < ? AND t.deleted_at IS NULL "+
+		"GROUP BY period, u.id, u.username ORDER BY period, u.username;", StatusDone, *q.From, *q.To)
+	if err != nil {
+		return nil, err
+	}
+	return completions, nil
+}
+
+// getTechnicianStatsFromStore has the stats of the tasks assigned to every technician, including the ones without tasks,
+// and to the managers that have any
+func (s *Service) getTechnicianStatsFromStore(ctx context.Context, q *reportQuery, now time.Time) ([]TechnicianStats, error) {
+	defer metrics.ObserveQuery("getTechnicianStatsFromStore")()
+	stats := []TechnicianStats{}
+	err := s.db.SelectContext(ctx, &stats, "SELECT u.id AS 'user.id', u.username AS 'user.username', "+reportStats+
+		" FROM users u INNER JOIN roles r ON u.role_id = r.id LEFT JOIN tasks t ON t.user_id = u.id AND t.deleted_at IS NULL "+
+		"WHERE r.name = ? OR t.id IS NOT NULL GROUP BY u.id, u.username ORDER BY u.username;",
+		append(reportStatsArgs(q, now), util.TechnicianRole)...)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// getTaskStatsFromStore has the stats of every task that isn't deleted
+func (s *Service) getTaskStatsFromStore(ctx context.Context, q *reportQuery, now time.Time) (*TaskStats, error) {
+	defer metrics.ObserveQuery("getTaskStatsFromStore")()
+	stats := &TaskStats{}
+	err := s.db.GetContext(ctx, stats, "SELECT "+reportStats+" FROM tasks t WHERE t.deleted_at IS NULL;", reportStatsArgs(q, now)...)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func (s *Service) getUnassignedTasksFromStore(ctx context.Context) ([]encryptedTask, error) {
+	defer metrics.ObserveQuery("getUnassignedTasksFromStore")()
+	task := []encryptedTask{}
+	err := s.db.SelectContext(ctx, &task, selectTasks+" WHERE t.user_id IS NULL AND t.status NOT IN (?, ?) AND t.deleted_at IS NULL;", StatusDone, StatusCancelled)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// addTaskToStore creates the task and records it in the history as created by task.CreatedBy
+func (s *Service) addTaskToStore(ctx context.Context, task *encryptedTask) (int, error) {
+	defer metrics.ObserveQuery("addTaskToStore")()
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := addTaskInTx(ctx, tx, task)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// addTaskInTx is addTaskToStore in a transaction of the caller
+func addTaskInTx(ctx context.Context, tx *sqlx.Tx, task *encryptedTask) (int, error) {
+	result, err := tx.ExecContext(ctx, "INSERT INTO tasks (user_id, summary, created_by, due_date, priority, estimated_minutes) VALUES (?, ?, ?, ?, ?, ?);",
+		userID(task.User), task.EncryptedSummary, userID(task.CreatedBy), task.DueDate, task.Priority, task.EstimatedMinutes)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	created := *task
+	created.ID = int(id)
+	created.Status = StatusTodo
+	if err := addHistoryInTx(ctx, tx, int(id), task.CreatedBy.ID, historyCreated, taskChanges(nil, &created)); err != nil {
+		return 0, err
+	}
+	if err := addSearchTokensInTx(ctx, tx, []encryptedTask{created}); err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// importTasksToStore adds the tasks importBatchSize at a time in a single transaction, so either all of them are
+// imported or none is
+func (s *Service) importTasksToStore(ctx context.Context, tasks []*encryptedTask) error {
+	defer metrics.ObserveQuery("importTasksToStore")()
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for start := 0; start < len(tasks); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		if err := addTasksInTx(ctx, tx, tasks[start:end]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// addTasksInTx inserts the tasks with a single statement, records their creation in the history and indexes them
+func addTasksInTx(ctx context.Context, tx *sqlx.Tx, tasks []*encryptedTask) error {
+	rows := make([]string, len(tasks))
+	args := make([]interface{}, 0, len(tasks)*8)
+	for i, task := range tasks {
+		rows[i] = "(?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, userID(task.User), task.EncryptedSummary, userID(task.CreatedBy), task.DueDate, task.Priority, task.EstimatedMinutes,
+			task.ScheduleID, task.ScheduledFor)
+	}
+	result, err := tx.ExecContext(ctx, "INSERT INTO tasks (user_id, summary, created_by, due_date, priority, estimated_minutes, schedule_id, scheduled_for) VALUES "+strings.Join(rows, ", ")+";", args...)
+	if err != nil {
+		return err
+	}
+	ids, err := insertedTaskIDsInTx(ctx, tx, result, tasks)
+	if err != nil {
+		return err
+	}
+
+	created := make([]encryptedTask, len(tasks))
+	var historyRows []string
+	var historyArgs []interface{}
+	for i, task := range tasks {
+		created[i] = *task
+		created[i].ID = ids[i]
+		created[i].Status = StatusTodo
+		for _, change := range taskChanges(nil, &created[i]) {
+			historyRows = append(historyRows, "(?, ?, ?, ?, ?, ?)")
+			historyArgs = append(historyArgs, created[i].ID, task.CreatedBy.ID, historyCreated, change.Field, nullBytes(change.Before), nullBytes(change.After))
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO task_history (task_id, user_id, action, field, old_value, new_value) VALUES "+strings.Join(historyRows, ", ")+";", historyArgs...); err != nil {
+		return err
+	}
+	return addSearchTokensInTx(ctx, tx, created)
+}
+
+// insertedTaskIDsInTx reads back the ids of the tasks just inserted, in the order of the tasks. Ids aren't consecutive
+// with auto_increment_increment or interleaved auto increments, so the rows are found by their summaries, which are
+// unique thanks to the random nonce, among the ones from the first id on. The rows of an insert get increasing ids
+func insertedTaskIDsInTx(ctx context.Context, tx *sqlx.Tx, result sql.Result, tasks []*encryptedTask) ([]int, error) {
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if inserted != int64(len(tasks)) {
+		return nil, fmt.Errorf("inserted %d tasks out of %d", inserted, len(tasks))
+	}
+	firstID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([][]byte, len(tasks))
+	for i, task := range tasks {
+		summaries[i] = task.EncryptedSummary
+	}
+	query, args, err := sqlx.In("SELECT id FROM tasks WHERE id >= ? AND summary IN (?) ORDER BY id;", firstID, summaries)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	if err := tx.SelectContext(ctx, &ids, query, args...); err != nil {
+		return nil, err
+	}
+	if len(ids) != len(tasks) {
+		return nil, fmt.Errorf("found %d of the %d tasks inserted", len(ids), len(tasks))
+	}
+	return ids, nil
+}
+
+// addSearchTokensInTx adds the SearchTokens of the tasks to the search index with a single statement
+func addSearchTokensInTx(ctx context.Context, tx *sqlx.Tx, tasks []encryptedTask) error {
+	var rows []string
+	var args []interface{}
+	for _, task := range tasks {
+		for _, token := range task.SearchTokens {
+			rows = append(rows, "(?, ?)")
+			args = append(args, task.ID, token)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO task_search_tokens (task_id, token) VALUES "+strings.Join(rows, ", ")+";", args...)
+	return err
+}
+
+// updateTaskInStore sets the fields of the task, named like in the API, to the values in task and leaves the others
+// as they are. Setting no fields changes nothing but still checks ifVersion
+func (s *Service) updateTaskInStore(ctx context.Context, task *encryptedTask, fields []string, ifVersion *int, userID int) (*encryptedTask, error) {
+	defer metrics.ObserveQuery("updateTaskInStore")()
+	return s.changeTaskInStore(ctx, task.ID, ifVersion, userID, historyUpdated, setTaskFields(ctx, task, fields))
+}
+
+// setTaskFields is the change of updateTaskInStore
+func setTaskFields(ctx context.Context, task *encryptedTask, fields []string) func(tx *sqlx.Tx) error {
+	var sets []string
+	var args []interface{}
+	reindex := false
+	for _, field := range fields {
+		switch field {
+		case "summary":
+			sets = append(sets, "summary = ?")
+			args = append(args, task.EncryptedSummary)
+			reindex = true
+		case "dueDate":
+			// A new due date allows the task to be reported as overdue again, it's compared before due_date is set as
+			// MySQL assigns from left to right
+			sets = append(sets, "overdue_notified_at = IF(due_date <=> ?, overdue_notified_at, NULL)", "due_date = ?")
+			args = append(args, task.DueDate, task.DueDate)
+		case "priority":
+			sets = append(sets, "priority = ?")
+			args = append(args, task.Priority)
+		case "estimatedMinutes":
+			sets = append(sets, "estimated_minutes = ?")
+			args = append(args, task.EstimatedMinutes)
+		}
+	}
+
+	return func(tx *sqlx.Tx) error {
+		if len(sets) == 0 {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tasks SET "+strings.Join(sets, ", ")+", version = version + 1 WHERE id = ?;", append(args, task.ID)...); err != nil {
+			return err
+		}
+		if !reindex {
+			return nil
+		}
+		// The tokens of the old summary are replaced by the ones of the new summary
+		if _, err := tx.ExecContext(ctx, "DELETE FROM task_search_tokens WHERE task_id = ?;", task.ID); err != nil {
+			return err
+		}
+		return addSearchTokensInTx(ctx, tx, []encryptedTask{*task})
+	}
+}
+
+// changeTaskInStore locks the task, applies the change and records the fields that changed in the history of the task,
+// all in one transaction so the history never misses a change nor records one that was rolled back. sql.ErrNoRows is
+// returned when the task doesn't exist and errVersionMismatch when ifVersion is set and the task is at another version.
+// Changes must increment the version of the task
+func (s *Service) changeTaskInStore(ctx context.Context, id int, ifVersion *int, userID int, action historyAction, change func(tx *sqlx.Tx) error) (*encryptedTask, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
+
+	after, err := changeTaskInTx(ctx, tx, id, ifVersion, userID, action, change)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+// changeTaskInTx is changeTaskInStore in a transaction of the caller
+func changeTaskInTx(ctx context.Context, tx *sqlx.Tx, id int, ifVersion *int, userID int, action historyAction, change func(tx *sqlx.Tx) error) (*encryptedTask, error) {
+	before, err := lockTaskInTx(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ifVersion != nil && before.Version != *ifVersion {
+		return nil, errVersionMismatch
+	}
+	if err := change(tx); err != nil {
+		return nil, err
+	}
+	after := &encryptedTask{}
+	if err := tx.GetContext(ctx, after, selectTasks+" WHERE t.id = ?;", id); err != nil {
+		return nil, err
+	}
+	if err := addHistoryInTx(ctx, tx, id, userID, action, taskChanges(before, after)); err != nil {
+		return nil, err
+	}
+	return after, nil
+}
+
+// lockTaskInTx loads the task and locks its row until the transaction ends, the users joined aren't locked. Deleted tasks
+// can't be changed so they are never found
+func lockTaskInTx(ctx context.Context, tx *sqlx.Tx, id int) (*encryptedTask, error) {
+	task := &encryptedTask{}
+	if err := tx.GetContext(ctx, task, selectTasks+" WHERE t.id = ? AND t.deleted_at IS NULL FOR UPDATE OF t;", id); err != nil {
+		return nil, err
+	}
 	return task, nil
 }
 
-func (s *Service) getAllTasksFromStore() ([]encryptedTask, error) {
+// addHistoryInTx appends the changes made by the user to the history of the task, rows of the history are never
+// updated or deleted except to re-encrypt summaries when the key is rotated
+func addHistoryInTx(ctx context.Context, tx *sqlx.Tx, taskID int, userID int, action historyAction, changes []fieldChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	rows := make([]string, len(changes))
+	args := make([]interface{}, 0, len(changes)*6)
+	for i, change := range changes {
+		rows[i] = "(?, ?, ?, ?, ?, ?)"
+		args = append(args, taskID, userID, action, change.Field, nullBytes(change.Before), nullBytes(change.After))
+	}
+	_, err := tx.ExecContext(ctx, "INSERT INTO task_history (task_id, user_id, action, field, old_value, new_value) VALUES "+strings.Join(rows, ", ")+";", args...)
+	return err
+}
+
+// nullBytes stores the values of fields that weren't set as NULL
+func nullBytes(value []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+	return value
+}
+
+func (s *Service) getHistoryFromStore(ctx context.Context, taskID int) ([]encryptedHistoryEntry, error) {
+	defer metrics.ObserveQuery("getHistoryFromStore")()
+	history := []encryptedHistoryEntry{}
+	err := s.db.SelectContext(ctx, &history, "SELECT h.id, h.task_id, h.action, h.field, h.old_value, h.new_value, h.created_date, u.id as 'user.id', u.username as 'user.username' FROM task_history h INNER JOIN users u on h.user_id = u.id WHERE h.task_id = ? ORDER BY h.id;", taskID)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// transitionTaskInStore moves the task to the new status and records who did it in the same transaction. The update only
+// applies if the task is still in the status the transition was checked against, otherwise errStatusChanged is returned
+func (s *Service) transitionTaskInStore(ctx context.Context, task *encryptedTask, to Status, userID int) (*encryptedTask, error) {
+	defer metrics.ObserveQuery("transitionTaskInStore")()
+	return s.changeTaskInStore(ctx, task.ID, nil, userID, historyTransitioned, setTaskStatus(ctx, task, to, userID))
+}
+
+// setTaskStatus is the change of transitionTaskInStore
+func setTaskStatus(ctx context.Context, task *encryptedTask, to Status, userID int) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE tasks SET status = ?, completed_date = ?, version = version + 1 WHERE id = ? AND status = ?;",
+			to, completedDateAfter(task.Status, to, task.CompletedDate), task.ID, task.Status)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return errStatusChanged
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO task_transitions (task_id, from_status, to_status, user_id) VALUES (?, ?, ?, ?);",
+			task.ID, task.Status, to, userID)
+		return err
+	}
+}
+
+func (s *Service) getTransitionsFromStore(ctx context.Context, taskID int) ([]transition, error) {
+	defer metrics.ObserveQuery("getTransitionsFromStore")()
+	transitions := []transition{}
+	err := s.db.SelectContext(ctx, &transitions, "SELECT tr.from_status, tr.to_status, tr.created_date, u.id as 'user.id', u.username as 'user.username' FROM task_transitions tr INNER JOIN users u on tr.user_id = u.id WHERE tr.task_id = ? ORDER BY tr.id;", taskID)
+	if err != nil {
+		return nil, err
+	}
+	return transitions, nil
+}
+
+// claimTaskInStore assigns a task of the unassigned pool to the user, errAlreadyAssigned is returned if someone else
+// claimed it first
+func (s *Service) claimTaskInStore(ctx context.Context, id int, userID int) (*encryptedTask, error) {
+	defer metrics.ObserveQuery("claimTaskInStore")()
+	return s.changeTaskInStore(ctx, id, nil, userID, historyAssigned, func(tx *sqlx.Tx) error {
+		res, err := tx.ExecContext(ctx, "UPDATE tasks SET user_id = ?, version = version + 1 WHERE id = ? AND user_id IS NULL AND status NOT IN (?, ?);",
+			userID, id, StatusDone, StatusCancelled)
+		if err != nil {
+			return err
+		}
+		if affected, err := res.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return errAlreadyAssigned
+		}
+		return nil
+	})
+}
+
+// assignTaskInStore sets the assignee of the task, a nil user puts it back in the unassigned pool
+func (s *Service) assignTaskInStore(ctx context.Context, id int, assignee *user.User, actorID int) (*encryptedTask, error) {
+	defer metrics.ObserveQuery("assignTaskInStore")()
+	return s.changeTaskInStore(ctx, id, nil, actorID, historyAssigned, setTaskAssignee(ctx, id, assignee))
+}
+
+// setTaskAssignee is the change of assignTaskInStore
+func setTaskAssignee(ctx context.Context, id int, assignee *user.User) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE tasks SET user_id = ?, version = version + 1 WHERE id = ?;", userID(assignee), id)
+		return err
+	}
+}
+
+// userID is the value stored in the user columns, NULL when there's no user
+func userID(u *user.User) interface{} {
+	if u == nil || u.ID == 0 {
+		return nil
+	}
+	return u.ID
+}
+
+// getOverdueTasksFromStore returns the open tasks past their due date whose notifications weren't sent yet
+func (s *Service) getOverdueTasksFromStore(ctx context.Context, now time.Time) ([]encryptedTask, error) {
+	defer metrics.ObserveQuery("getOverdueTasksFromStore")()
 	task := []encryptedTask{}
-	err := s.db.Select(&task, "SELECT t.id, t.summary, t.completed_date, u.id as 'user.id', u.username as 'user.username' FROM tasks t INNER JOIN users u on t.user_id = u.id;")
+	err := s.db.SelectContext(ctx, &task, selectTasks+" WHERE t.due_date < ? AND t.status NOT IN (?, ?) AND t.overdue_notified_at IS NULL AND t.deleted_at IS NULL ORDER BY t.due_date;",
+		now, StatusDone, StatusCancelled)
 	if err != nil {
 		return nil, err
 	}
 	return task, nil
 }
 
-func (s *Service) addTaskToStore(task *encryptedTask) (int, error) {
-	result, err := s.db.Exec("INSERT INTO tasks (user_id, summary) VALUES (?, ?);", task.User.ID, task.EncryptedSummary)
+// markOverdueNotifiedInStore records the notifications of the task as sent, false means another replica got to it first
+func (s *Service) markOverdueNotifiedInStore(ctx context.Context, id int, now time.Time) (bool, error) {
+	defer metrics.ObserveQuery("markOverdueNotifiedInStore")()
+	res, err := s.db.ExecContext(ctx, "UPDATE tasks SET overdue_notified_at = ? WHERE id = ? AND overdue_notified_at IS NULL;", now, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+const selectComments = "SELECT c.id, c.task_id, c.body, c.created_date, c.updated_date, u.id as 'user.id', u.username as 'user.username' FROM task_comments c INNER JOIN users u on c.user_id = u.id"
+
+func (s *Service) getCommentsFromStore(ctx context.Context, taskID int) ([]encryptedComment, error) {
+	defer metrics.ObserveQuery("getCommentsFromStore")()
+	comments := []encryptedComment{}
+	err := s.db.SelectContext(ctx, &comments, selectComments+" WHERE c.task_id = ? ORDER BY c.id;", taskID)
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (s *Service) getCommentFromStore(ctx context.Context, taskID int, id int) (*encryptedComment, error) {
+	defer metrics.ObserveQuery("getCommentFromStore")()
+	comment := &encryptedComment{}
+	err := s.db.GetContext(ctx, comment, selectComments+" WHERE c.task_id = ? AND c.id = ?;", taskID, id)
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+func (s *Service) addCommentToStore(ctx context.Context, comment *encryptedComment) (int, error) {
+	defer metrics.ObserveQuery("addCommentToStore")()
+	result, err := s.db.ExecContext(ctx, "INSERT INTO task_comments (task_id, user_id, body, created_date) VALUES (?, ?, ?, ?);",
+		comment.TaskID, comment.User.ID, comment.EncryptedBody, comment.CreatedDate)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func (s *Service) updateCommentInStore(ctx context.Context, comment *encryptedComment) error {
+	defer metrics.ObserveQuery("updateCommentInStore")()
+	_, err := s.db.ExecContext(ctx, "UPDATE task_comments SET body = ?, updated_date = ? WHERE id = ?;",
+		comment.EncryptedBody, comment.UpdatedDate, comment.ID)
+	return err
+}
+
+func (s *Service) deleteCommentFromStore(ctx context.Context, id int) error {
+	defer metrics.ObserveQuery("deleteCommentFromStore")()
+	_, err := s.db.ExecContext(ctx, "DELETE FROM task_comments WHERE id = ?;", id)
+	return err
+}
+
+const selectAttachments = "SELECT a.id, a.task_id, a.filename, a.content_type, a.size, a.storage_key, a.created_date, u.id as 'user.id', u.username as 'user.username' FROM task_attachments a INNER JOIN users u on a.user_id = u.id"
+
+func (s *Service) getAttachmentsFromStore(ctx context.Context, taskID int) ([]encryptedAttachment, error) {
+	defer metrics.ObserveQuery("getAttachmentsFromStore")()
+	attachments := []encryptedAttachment{}
+	err := s.db.SelectContext(ctx, &attachments, selectAttachments+" WHERE a.task_id = ? ORDER BY a.id;", taskID)
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (s *Service) getAttachmentFromStore(ctx context.Context, taskID int, id int) (*encryptedAttachment, error) {
+	defer metrics.ObserveQuery("getAttachmentFromStore")()
+	attachment := &encryptedAttachment{}
+	err := s.db.GetContext(ctx, attachment, selectAttachments+" WHERE a.task_id = ? AND a.id = ?;", taskID, id)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
+func (s *Service) addAttachmentToStore(ctx context.Context, attachment *encryptedAttachment) (int, error) {
+	defer metrics.ObserveQuery("addAttachmentToStore")()
+	result, err := s.db.ExecContext(ctx, "INSERT INTO task_attachments (task_id, user_id, filename, content_type, size, storage_key, created_date) VALUES (?, ?, ?, ?, ?, ?, ?);",
+		attachment.TaskID, attachment.User.ID, attachment.EncryptedFilename, attachment.ContentType, attachment.Size, attachment.StorageKey, attachment.CreatedDate)
 	if err != nil {
 		return 0, err
 	}
@@ -48,18 +735,71 @@ func (s *Service) addTaskToStore(task *encryptedTask) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	return int(id), nil
+}
+
+func (s *Service) deleteAttachmentFromStore(ctx context.Context, id int) error {
+	defer metrics.ObserveQuery("deleteAttachmentFromStore")()
+	_, err := s.db.ExecContext(ctx, "DELETE FROM task_attachments WHERE id = ?;", id)
+	return err
+}
+
+func (s *Service) getSchedulesFromStore(ctx context.Context) ([]encryptedSchedule, error) {
+	defer metrics.ObserveQuery("getSchedulesFromStore")()
+	schedules := []encryptedSchedule{}
+	if err := s.db.SelectContext(ctx, &schedules, selectSchedules+" ORDER BY sc.id;"); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (s *Service) getScheduleFromStore(ctx context.Context, id int) (*encryptedSchedule, error) {
+	defer metrics.ObserveQuery("getScheduleFromStore")()
+	schedule := &encryptedSchedule{}
+	if err := s.db.GetContext(ctx, schedule, selectSchedules+" WHERE sc.id = ?;", id); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
 
+func (s *Service) addScheduleToStore(ctx context.Context, schedule *encryptedSchedule) (int, error) {
+	defer metrics.ObserveQuery("addScheduleToStore")()
+	result, err := s.db.ExecContext(ctx, "INSERT INTO task_schedules (summary, cron, timezone, user_id, created_by, priority, estimated_minutes, next_run) VALUES (?, ?, ?, ?, ?, ?, ?, ?);",
+		schedule.EncryptedSummary, schedule.Cron, schedule.Timezone, userID(schedule.User), userID(schedule.CreatedBy), schedule.Priority, schedule.EstimatedMinutes, schedule.NextRun)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
 	return int(id), nil
 }
 
-func (s *Service) updateTaskInStore(task *encryptedTask) (*encryptedTask, error) {
-	_, err := s.db.Exec(
-		// Coalesce the fields so we only update the ones that were not sent as empty to the API
-		"UPDATE tasks SET user_id = COALESCE(?, user_id), summary = COALESCE(?, summary), completed_date = ? WHERE id = ?;",
-		task.User.ID, task.EncryptedSummary, task.CompletedDate, task.ID)
+// changeScheduleInStore locks the schedule, so the scheduler can't create its tasks meanwhile, and saves the changes
+// made by change to its fields
+func (s *Service) changeScheduleInStore(ctx context.Context, id int, change func(schedule *encryptedSchedule) error) (*encryptedSchedule, error) {
+	defer metrics.ObserveQuery("changeScheduleInStore")()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	return s.getTaskFromStore(task.ID)
+	schedule := &encryptedSchedule{}
+	if err := tx.GetContext(ctx, schedule, selectSchedules+" WHERE sc.id = ? FOR UPDATE OF sc;", id); err != nil {
+		return nil, err
+	}
+	if err := change(schedule); err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE task_schedules SET summary = ?, cron = ?, timezone = ?, user_id = ?, priority = ?, estimated_minutes = ?, paused = ?, next_run = ? WHERE id = ?;",
+		schedule.EncryptedSummary, schedule.Cron, schedule.Timezone, userID(schedule.User), schedule.Priority, schedule.EstimatedMinutes, schedule.Paused, schedule.NextRun, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return schedule, nil
 }
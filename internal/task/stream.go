@@ -0,0 +1,152 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Files are too large to be sealed at once, so they are split in chunks sealed on their own. The stream starts with a
+// random prefix and the nonce of each chunk is the prefix followed by the chunk number, so chunks can't be reordered.
+// The last chunk is sealed with different additional data so a truncated stream fails to decrypt
+const (
+	streamChunkSize  = 64 << 10
+	streamPrefixSize = 8
+)
+
+var (
+	chunkAdditionalData     = []byte{0}
+	lastChunkAdditionalData = []byte{1}
+)
+
+var errStreamCorrupted = errors.New("encrypted stream is truncated or was tampered with")
+
+// encryptStream returns a reader with the encrypted content of the reader passed
+func (s *taskCrypto) encryptStream(r io.Reader) (io.Reader, error) {
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		s.logger.Warnw("Failed to generate nonce")
+		return nil, err
+	}
+	er := &streamEncrypter{crypto: s, src: bufio.NewReaderSize(r, streamChunkSize), prefix: prefix, chunk: make([]byte, streamChunkSize)}
+	er.out.Write(prefix)
+	return er, nil
+}
+
+// decryptStream returns a reader with the content of a stream made by encryptStream with either key, it fails when the
+// stream was tampered with or truncated
+func (s *taskCrypto) decryptStream(r io.Reader) io.Reader {
+	return &streamDecrypter{crypto: s, src: bufio.NewReaderSize(r, streamChunkSize+s.gcm.Overhead()), chunk: make([]byte, streamChunkSize+s.gcm.Overhead())}
+}
+
+func (s *taskCrypto) chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, s.ivSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	return nonce
+}
+
+// readChunk fills the chunk and tells whether it's the last one of the reader
+func readChunk(src *bufio.Reader, chunk []byte) (n int, last bool, err error) {
+	n, err = io.ReadFull(src, chunk)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	if _, err := src.Peek(1); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return n, false, err
+	}
+	return n, false, nil
+}
+
+type streamEncrypter struct {
+	crypto  *taskCrypto
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	out     bytes.Buffer
+	done    bool
+}
+
+func (e *streamEncrypter) Read(p []byte) (int, error) {
+	for e.out.Len() == 0 {
+		if e.done {
+			return 0, io.EOF
+		}
+		n, last, err := readChunk(e.src, e.chunk)
+		if err != nil {
+			return 0, err
+		}
+		additionalData := chunkAdditionalData
+		if last {
+			additionalData, e.done = lastChunkAdditionalData, true
+		}
+		e.out.Write(e.crypto.gcm.Seal(nil, e.crypto.chunkNonce(e.prefix, e.counter), e.chunk[:n], additionalData))
+		e.counter++
+	}
+	return e.out.Read(p)
+}
+
+type streamDecrypter struct {
+	crypto *taskCrypto
+	// gcm is the key the first chunk was decrypted with, the rest of the stream must use the same one
+	gcm     cipher.AEAD
+	src     *bufio.Reader
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	out     bytes.Buffer
+	done    bool
+}
+
+func (d *streamDecrypter) Read(p []byte) (int, error) {
+	if d.prefix == nil {
+		d.prefix = make([]byte, streamPrefixSize)
+		if _, err := io.ReadFull(d.src, d.prefix); err != nil {
+			return 0, errStreamCorrupted
+		}
+	}
+	for d.out.Len() == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		n, last, err := readChunk(d.src, d.chunk)
+		if err != nil {
+			return 0, err
+		}
+		additionalData := chunkAdditionalData
+		if last {
+			additionalData, d.done = lastChunkAdditionalData, true
+		}
+		plaintext, err := d.open(d.chunk[:n], additionalData)
+		if err != nil {
+			d.crypto.logger.Warnw("Failed to decrypt chunk", "chunk", d.counter)
+			return 0, errStreamCorrupted
+		}
+		d.out.Write(plaintext)
+		d.counter++
+	}
+	return d.out.Read(p)
+}
+
+func (d *streamDecrypter) open(chunk []byte, additionalData []byte) ([]byte, error) {
+	nonce := d.crypto.chunkNonce(d.prefix, d.counter)
+	if d.gcm != nil {
+		return d.gcm.Open(nil, nonce, chunk, additionalData)
+	}
+	plaintext, err := d.crypto.gcm.Open(nil, nonce, chunk, additionalData)
+	d.gcm = d.crypto.gcm
+	if err != nil && d.crypto.previous != nil {
+		plaintext, err = d.crypto.previous.Open(nil, nonce, chunk, additionalData)
+		d.gcm = d.crypto.previous
+	}
+	return plaintext, err
+}
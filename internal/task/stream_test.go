@@ -0,0 +1,70 @@
+package task
+
+import (
+	"bytes"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"io"
+	"testing"
+)
+
+func streamCrypto(t *testing.T) *taskCrypto {
+	c, err := NewCrypto("6368616e676520746869732070617373", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	c := streamCrypto(t)
+	for _, size := range []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3*streamChunkSize + 7} {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+
+		encrypted, err := c.encryptStream(bytes.NewReader(plaintext))
+		assert.Nil(t, err)
+		ciphertext, err := io.ReadAll(encrypted)
+		assert.Nil(t, err)
+		assert.False(t, size > 16 && bytes.Contains(ciphertext, plaintext[:16]), "size %d", size)
+
+		decrypted, err := io.ReadAll(c.decryptStream(bytes.NewReader(ciphertext)))
+		assert.Nil(t, err, "size %d", size)
+		assert.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestStreamDetectsTamperingAndTruncation(t *testing.T) {
+	c := streamCrypto(t)
+	encrypted, _ := c.encryptStream(bytes.NewReader(bytes.Repeat([]byte("pump"), streamChunkSize)))
+	ciphertext, _ := io.ReadAll(encrypted)
+	chunk := streamChunkSize + c.gcm.Overhead()
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[streamPrefixSize+10] ^= 1
+	// Dropping whole chunks at the end still leaves a stream of valid chunks
+	truncated := ciphertext[:streamPrefixSize+2*chunk]
+
+	for name, corrupted := range map[string][]byte{"tampered": tampered, "truncated": truncated, "empty": {}} {
+		_, err := io.ReadAll(c.decryptStream(bytes.NewReader(corrupted)))
+		assert.Equal(t, errStreamCorrupted, err, name)
+	}
+}
+
+func TestStreamDecryptsWithThePreviousKeyDuringARotation(t *testing.T) {
+	old := streamCrypto(t)
+	c, err := newRotatingCrypto("73736170207369687420656761686321", "6368616e676520746869732070617373", zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := bytes.Repeat([]byte("pump"), streamChunkSize)
+	for _, encryptor := range []*taskCrypto{old, c} {
+		encrypted, _ := encryptor.encryptStream(bytes.NewReader(plaintext))
+
+		decrypted, err := io.ReadAll(c.decryptStream(encrypted))
+
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, decrypted)
+	}
+}
@@ -0,0 +1,238 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const getUnassignedTasksSQL = "SELECT .+ FROM tasks t .+ WHERE t.user_id IS NULL AND t.status NOT IN (.+, .+) AND t.deleted_at IS NULL;"
+const claimTaskSQL = "UPDATE tasks SET user_id = .+ WHERE id = .+ AND user_id IS NULL AND status NOT IN (.+, .+);"
+const assignTaskSQL = "UPDATE tasks SET user_id = .+ WHERE id = .+;"
+const getUserByIDSQL = "SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u LEFT JOIN roles r on u.role_id = r.id WHERE u.id = .+;"
+
+type recordingPublisher struct {
+	messages chan eventbus.Message
+}
+
+func (r *recordingPublisher) Publish(_ context.Context, msg eventbus.Message) error {
+	r.messages <- msg
+	return nil
+}
+
+func (s *TaskAPITestSuite) TestManagerCreatesUnassignedTask() {
+	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(`{"summary": "fix the pump"}`)))
+	s.c.Request = req
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "boss", Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectExec(createTaskSQL).WithArgs(nil, sqlmock.AnyArg(), 2, nil, "normal", nil).WillReturnResult(sqlmock.NewResult(5, 1))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 3))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 3))
+	s.sqlmock.ExpectCommit()
+
+	s.service.createTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 201, s.w.Code)
+	var taskReceived task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &taskReceived); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Nil(s.T(), taskReceived.User)
+	assert.Equal(s.T(), &user.User{ID: 2, Username: "boss"}, taskReceived.CreatedBy)
+}
+
+func (s *TaskAPITestSuite) TestTechnicianCreatesTaskForThemselvesWithoutUser() {
+	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader([]byte(`{"summary": "fix the pump"}`)))
+	s.c.Request = req
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectExec(createTaskSQL).WithArgs(1, sqlmock.AnyArg(), 1, nil, "normal", nil).WillReturnResult(sqlmock.NewResult(5, 1))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 4))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 3))
+	s.sqlmock.ExpectCommit()
+
+	s.service.createTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 201, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestGetUnassignedTasks() {
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	rows := sqlmock.NewRows(taskColumns).AddRow(3, et.EncryptedSummary, "todo", nil, 0, "", 2, "boss", nil, "normal", nil)
+	s.sqlmock.ExpectQuery(getUnassignedTasksSQL).WithArgs("done", "cancelled").WillReturnRows(rows)
+
+	s.service.getUnassignedTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var tasks []task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &tasks); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), []task{{ID: 3, Summary: "fix the pump", Status: StatusTodo, Priority: PriorityNormal, CreatedBy: &user.User{ID: 2, Username: "boss"}}}, tasks)
+}
+
+func (s *TaskAPITestSuite) TestClaimTask() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 0, "", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(claimTaskSQL).WithArgs(1, 1, "done", "cancelled").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(insertHistorySQL).WithArgs(1, 1, historyAssigned, "user", nil, []byte("1")).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.claimTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var taskReceived task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &taskReceived); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), 1, taskReceived.User.ID)
+}
+
+func (s *TaskAPITestSuite) TestClaimTaskAlreadyAssigned() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 3, "ana", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(claimTaskSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectRollback()
+
+	s.service.claimTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 409, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeTaskAlreadyAssigned, p.Code)
+}
+
+func (s *TaskAPITestSuite) TestClaimTaskThatDoesntExist() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns))
+	s.sqlmock.ExpectRollback()
+
+	s.service.claimTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 404, s.w.Code)
+}
+
+func (s *TaskAPITestSuite) TestReassignTaskNotifiesTheAssignee() {
+	publisher := &recordingPublisher{messages: make(chan eventbus.Message, 1)}
+	s.service.taskPublisher = publisher
+	defer func() { s.service.taskPublisher = &LogPublisher{Logger: s.service.logger} }()
+
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1/assignee", bytes.NewReader([]byte(`{"id": 3}`)))
+	s.c.Request = req
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "boss", Role: &user.Role{Name: "manager"}})
+
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	s.sqlmock.ExpectQuery(getUserByIDSQL).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow(3, "ana", "technician", 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(assignTaskSQL).WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 3, "ana", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(insertHistorySQL).WithArgs(1, 2, historyAssigned, "user", []byte("1"), []byte("3")).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.reassignTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	select {
+	case msg := <-publisher.messages:
+		assert.Equal(s.T(), EventTaskAssigned, msg.Type)
+		var assignment Assignment
+		assert.Nil(s.T(), json.Unmarshal(msg.Body, &assignment))
+		assert.Equal(s.T(), "ana", assignment.Assignee.Username)
+		assert.Equal(s.T(), "boss", assignment.AssignedBy.Username)
+	case <-time.After(time.Second):
+		s.T().Fatal("The assignee was not notified")
+	}
+}
+
+func (s *TaskAPITestSuite) TestReassignTaskToUnknownUser() {
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1/assignee", bytes.NewReader([]byte(`{"id": 3}`)))
+	s.c.Request = req
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getUserByIDSQL).WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}))
+
+	s.service.reassignTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), "id", p.Errors[0].Field)
+}
+
+func (s *TaskAPITestSuite) TestTechnicianCantReassignTasks() {
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1/assignee", bytes.NewReader([]byte(`{"id": 1}`)))
+	s.c.Request = req
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.service.reassignTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+}
+
+func (s *TaskAPITestSuite) TestUnassignTaskPutsItBackInThePool() {
+	req, _ := http.NewRequest(http.MethodDelete, "/tasks/1/assignee", nil)
+	s.c.Request = req
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(assignTaskSQL).WithArgs(nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 0, "", 2, "boss", nil, "normal", nil))
+	s.sqlmock.ExpectExec(insertHistorySQL).WithArgs(1, 2, historyAssigned, "user", []byte("1"), nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.reassignTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var taskReceived task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &taskReceived); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Nil(s.T(), taskReceived.User)
+}
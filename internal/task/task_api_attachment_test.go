@@ -0,0 +1,191 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sword-challenge/internal/blob"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const createAttachmentSQL = "INSERT INTO task_attachments (.+) VALUES (.+);"
+const getAttachmentSQL = "SELECT .+ FROM task_attachments a .+ WHERE a.task_id = .+ AND a.id = .+;"
+const deleteAttachmentSQL = "DELETE FROM task_attachments WHERE id = .+;"
+
+var attachmentColumns = []string{"id", "task_id", "filename", "content_type", "size", "storage_key", "created_date", "user.id", "user.username"}
+var validAttachmentID = gin.Param{Key: "attachment-id", Value: "7"}
+var pngContent = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte("pump"), 100)...)
+
+func multipartRequest(filename string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("description", "the pump")
+	part, _ := writer.CreateFormFile("file", filename)
+	_, _ = part.Write(content)
+	_ = writer.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, "/tasks/1/attachments", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// useBlobs stores the attachments of the test in a temporary directory
+func (s *TaskAPITestSuite) useBlobs() string {
+	dir := s.T().TempDir()
+	s.service.Blobs = blob.NewFilesystem(dir)
+	s.service.MaxAttachmentSize = 1024
+	s.service.AttachmentTypes = []string{"image/png", "application/pdf"}
+	return dir
+}
+
+func (s *TaskAPITestSuite) TestUploadAttachmentIsEncryptedAtRest() {
+	dir := s.useBlobs()
+	s.c.Request = multipartRequest("../../pump.png", pngContent)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectExec(createAttachmentSQL).WithArgs(1, 1, sqlmock.AnyArg(), "image/png", int64(len(pngContent)), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	s.service.uploadAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 201, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+	var uploaded attachment
+	if err := json.Unmarshal(s.w.Body.Bytes(), &uploaded); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), 7, uploaded.ID)
+	assert.Equal(s.T(), "pump.png", uploaded.Filename)
+	assert.Equal(s.T(), "image/png", uploaded.ContentType)
+	assert.Equal(s.T(), int64(len(pngContent)), uploaded.Size)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "tasks", "1", "*"))
+	assert.Len(s.T(), files, 1)
+	stored, _ := os.ReadFile(files[0])
+	assert.False(s.T(), bytes.Contains(stored, []byte("PNG")))
+	assert.False(s.T(), bytes.Contains(stored, []byte("pump")))
+}
+
+func (s *TaskAPITestSuite) TestUploadAttachmentTooLarge() {
+	dir := s.useBlobs()
+	s.c.Request = multipartRequest("pump.png", append(pngContent, make([]byte, 1024)...))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+
+	s.service.uploadAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 413, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+	files, _ := filepath.Glob(filepath.Join(dir, "tasks", "1", "*"))
+	assert.Empty(s.T(), files)
+}
+
+func (s *TaskAPITestSuite) TestUploadAttachmentWithTypeNotAllowed() {
+	s.useBlobs()
+	// The name and the type sent by the client are ignored
+	s.c.Request = multipartRequest("pump.png", []byte("#!/bin/sh\nrm -rf /"))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+
+	s.service.uploadAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 415, s.w.Code)
+	var p problem.Problem
+	assert.Nil(s.T(), json.Unmarshal(s.w.Body.Bytes(), &p))
+	assert.Equal(s.T(), problem.CodeUnsupportedMedia, p.Code)
+}
+
+func (s *TaskAPITestSuite) TestUploadAttachmentWithoutFile() {
+	s.useBlobs()
+	s.c.Request, _ = http.NewRequest(http.MethodPost, "/tasks/1/attachments", bytes.NewReader([]byte(`{"file": "pump.png"}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+
+	s.service.uploadAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+}
+
+// storeAttachment puts an encrypted file in the blob store and returns the row of its metadata
+func (s *TaskAPITestSuite) storeAttachment(uploaderID int, uploader string) *sqlmock.Rows {
+	encrypted, _ := s.tEncryptor.encryptStream(bytes.NewReader(pngContent))
+	_ = s.service.Blobs.Put(context.Background(), "tasks/1/pump", encrypted)
+	filename, _ := s.tEncryptor.encrypt(context.Background(), []byte("pump.png"))
+	return sqlmock.NewRows(attachmentColumns).
+		AddRow(7, 1, filename, "image/png", len(pngContent), "tasks/1/pump", time.Date(2021, 10, 23, 10, 0, 0, 0, time.UTC), uploaderID, uploader)
+}
+
+func (s *TaskAPITestSuite) TestDownloadAttachment() {
+	s.useBlobs()
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/1/attachments/7", nil)
+	s.c.Params = append(s.c.Params, validTaskId, validAttachmentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getAttachmentSQL).WithArgs(1, 7).WillReturnRows(s.storeAttachment(1, "joel"))
+
+	s.service.downloadAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), "image/png", s.w.Header().Get("Content-Type"))
+	assert.Equal(s.T(), `attachment; filename=pump.png`, s.w.Header().Get("Content-Disposition"))
+	body, _ := io.ReadAll(s.w.Body)
+	assert.Equal(s.T(), pngContent, body)
+}
+
+func (s *TaskAPITestSuite) TestDeleteAttachmentOfAnotherTechnician() {
+	s.useBlobs()
+	s.c.Params = append(s.c.Params, validTaskId, validAttachmentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getAttachmentSQL).WithArgs(1, 7).WillReturnRows(s.storeAttachment(3, "ana"))
+
+	s.service.deleteAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestDeleteAttachmentRemovesTheFile() {
+	s.useBlobs()
+	s.c.Params = append(s.c.Params, validTaskId, validAttachmentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getAttachmentSQL).WithArgs(1, 7).WillReturnRows(s.storeAttachment(1, "joel"))
+	s.sqlmock.ExpectExec(deleteAttachmentSQL).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.service.deleteAttachment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+	_, err := s.service.Blobs.Get(context.Background(), "tasks/1/pump")
+	assert.Equal(s.T(), blob.ErrNotFound, err)
+}
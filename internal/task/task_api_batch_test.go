@@ -0,0 +1,151 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+func (s *TaskAPITestSuite) batchRequest(role string, body string) {
+	s.c.Request, _ = http.NewRequest(http.MethodPost, "/tasks/batch", bytes.NewReader([]byte(body)))
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: role}})
+}
+
+func (s *TaskAPITestSuite) batchResults() []batchResult {
+	var response batchResponse
+	if err := json.Unmarshal(s.w.Body.Bytes(), &response); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	return response.Results
+}
+
+// batchTaskRows is the task 1 in the status at the version
+func (s *TaskAPITestSuite) batchTaskRows(status Status, version int) *sqlmock.Rows {
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	return sqlmock.NewRows(versionedTaskColumns).AddRow(1, et.EncryptedSummary, status, nil, 1, "joel", 2, "dvn", nil, "normal", nil, version)
+}
+
+func (s *TaskAPITestSuite) TestBatchAsTechnician() {
+	s.batchRequest("technician", `{"operations": [{"op": "delete", "id": 1}]}`)
+
+	s.service.batchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestBatchUpdateChangesTheFieldsAndTheStatus() {
+	publisher := &recordingPublisher{messages: make(chan eventbus.Message, 10)}
+	s.service.taskPublisher = publisher
+	defer func() { s.service.taskPublisher = &LogPublisher{Logger: s.service.logger} }()
+	s.batchRequest("manager", `{"operations": [{"op": "update", "id": 1, "ifMatch": "\"3\"", "task": {"priority": "high", "status": "done"}}]}`)
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusInProgress, 3))
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusInProgress, 3))
+	s.sqlmock.ExpectExec("UPDATE tasks SET priority = \\?, version = version \\+ 1 WHERE id = \\?;").
+		WithArgs(PriorityHigh, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusInProgress, 4))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusInProgress, 4))
+	s.sqlmock.ExpectExec(transitionTaskSQL).WithArgs(StatusDone, sqlmock.AnyArg(), 1, StatusInProgress).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertTransitionSQL).WithArgs(1, StatusInProgress, StatusDone, 2).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusDone, 5))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.batchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	results := s.batchResults()
+	assert.Len(s.T(), results, 1)
+	assert.Equal(s.T(), 200, results[0].Status)
+	assert.Equal(s.T(), `"5"`, results[0].ETag)
+	assert.Equal(s.T(), StatusDone, results[0].Task.Status)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+
+	// The transition is published once it's committed, managers aren't notified of their own completions
+	select {
+	case msg := <-publisher.messages:
+		assert.Equal(s.T(), EventTaskStatusChanged, msg.Type)
+	case <-time.After(time.Second):
+		s.T().Fatal("The transition was not published")
+	}
+	assert.Never(s.T(), func() bool { return len(publisher.messages) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+}
+
+func (s *TaskAPITestSuite) TestAtomicBatchRollsBackWhenAnOperationFails() {
+	s.batchRequest("manager", `{"operations": [{"op": "delete", "id": 1}, {"op": "delete", "id": 2}]}`)
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusTodo, 1))
+	s.sqlmock.ExpectExec(deleteTaskSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(2).WillReturnRows(sqlmock.NewRows(versionedTaskColumns))
+	s.sqlmock.ExpectRollback()
+
+	s.service.batchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	results := s.batchResults()
+	assert.Len(s.T(), results, 2)
+	assert.Equal(s.T(), 424, results[0].Status)
+	assert.Equal(s.T(), problem.CodeBatchAborted, results[0].Problem.Code)
+	assert.Equal(s.T(), 404, results[1].Status)
+	assert.Equal(s.T(), problem.CodeTaskNotFound, results[1].Problem.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestBestEffortBatchKeepsTheOperationsThatSucceed() {
+	s.batchRequest("manager", `{"mode": "bestEffort", "operations": [{"op": "delete", "id": 1}, {"op": "delete", "id": 2}, {"op": "update"}]}`)
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.batchTaskRows(StatusTodo, 1))
+	s.sqlmock.ExpectExec(deleteTaskSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(2).WillReturnRows(sqlmock.NewRows(versionedTaskColumns))
+	s.sqlmock.ExpectRollback()
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectRollback()
+
+	s.service.batchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	results := s.batchResults()
+	assert.Len(s.T(), results, 3)
+	assert.Equal(s.T(), 200, results[0].Status)
+	assert.Equal(s.T(), 404, results[1].Status)
+	assert.Equal(s.T(), 400, results[2].Status)
+	assert.Equal(s.T(), "id", results[2].Problem.Errors[0].Field)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestBatchUpdateCantClearTheSummary() {
+	s.batchRequest("manager", `{"operations": [{"op": "update", "id": 1, "task": {"summary": null}}]}`)
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectRollback()
+
+	s.service.batchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	results := s.batchResults()
+	assert.Equal(s.T(), 400, results[0].Status)
+	assert.Equal(s.T(), []problem.FieldError{{Field: "summary", Code: "required", Message: "can't be cleared"}}, results[0].Problem.Errors)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
@@ -0,0 +1,234 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"testing"
+	"time"
+)
+
+const getCommentsSQL = "SELECT c.id, c.task_id, c.body, c.created_date, c.updated_date, u.id as 'user.id', u.username as 'user.username' FROM task_comments c INNER JOIN users u on c.user_id = u.id WHERE c.task_id = .+ ORDER BY c.id;"
+const getCommentSQL = "SELECT .+ FROM task_comments c .+ WHERE c.task_id = .+ AND c.id = .+;"
+const createCommentSQL = "INSERT INTO task_comments (.+) VALUES (.+);"
+const updateCommentSQL = "UPDATE task_comments SET body = .+, updated_date = .+ WHERE id = .+;"
+const deleteCommentSQL = "DELETE FROM task_comments WHERE id = .+;"
+const getUsersByUsernamesSQL = "SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u LEFT JOIN roles r on u.role_id = r.id WHERE u.username IN (.+);"
+
+var commentColumns = []string{"id", "task_id", "body", "created_date", "updated_date", "user.id", "user.username"}
+var validCommentID = gin.Param{Key: "comment-id", Value: "4"}
+
+func TestMentions(t *testing.T) {
+	assert.Equal(t, []string{"dvn", "ana.m"}, mentions("@dvn can you and @ana.m check it? Thanks @dvn."))
+	assert.Nil(t, mentions("mail joel@example.com, not a mention"))
+	assert.Nil(t, mentions("no mentions @"))
+}
+
+func (s *TaskAPITestSuite) ownTaskRows() *sqlmock.Rows {
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	return sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "in_progress", nil, 1, "joel", 2, "dvn", nil, "normal", nil)
+}
+
+func (s *TaskAPITestSuite) commentRows(authorID int, author string, body string) *sqlmock.Rows {
+	ec, _ := s.tEncryptor.encryptComment(context.Background(), &comment{Body: body})
+	created := time.Date(2021, 10, 23, 10, 0, 0, 0, time.UTC)
+	return sqlmock.NewRows(commentColumns).AddRow(4, 1, ec.EncryptedBody, created, nil, authorID, author)
+}
+
+func (s *TaskAPITestSuite) TestGetComments() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getCommentsSQL).WithArgs(1).WillReturnRows(s.commentRows(2, "dvn", "the pump is in room 3"))
+
+	s.service.getComments(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var comments []comment
+	if err := json.Unmarshal(s.w.Body.Bytes(), &comments); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), []comment{{ID: 4, TaskID: 1, Body: "the pump is in room 3", User: &user.User{ID: 2, Username: "dvn"}, CreatedDate: time.Date(2021, 10, 23, 10, 0, 0, 0, time.UTC)}}, comments)
+}
+
+func (s *TaskAPITestSuite) TestGetCommentsOfAnotherUsersTask() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 3, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+
+	s.service.getComments(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestCreateCommentNotifiesMentionedUsersThatCanSeeTheTask() {
+	publisher := &recordingPublisher{messages: make(chan eventbus.Message, 10)}
+	s.service.taskPublisher = publisher
+	defer func() { s.service.taskPublisher = &LogPublisher{Logger: s.service.logger} }()
+
+	s.c.Request, _ = http.NewRequest(http.MethodPost, "/tasks/1/comments", bytes.NewReader([]byte(`{"body": "@dvn @ana the pump is fixed, cc @joel"}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectExec(createCommentSQL).WithArgs(1, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(4, 1))
+	s.sqlmock.ExpectQuery(getUsersByUsernamesSQL).WithArgs("dvn", "ana", "joel").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).
+		AddRow(2, "dvn", "manager", 1).
+		AddRow(3, "ana", "technician", 2).
+		AddRow(1, "joel", "technician", 2))
+
+	s.service.createComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 201, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+	var created comment
+	if err := json.Unmarshal(s.w.Body.Bytes(), &created); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), 4, created.ID)
+	assert.Equal(s.T(), &user.User{ID: 1, Username: "joel"}, created.User)
+
+	// ana isn't assigned to the task and joel wrote the comment, only the manager is notified
+	select {
+	case msg := <-publisher.messages:
+		assert.Equal(s.T(), EventTaskMentioned, msg.Type)
+		var mention Mention
+		assert.Nil(s.T(), json.Unmarshal(msg.Body, &mention))
+		assert.Equal(s.T(), Mention{ID: 1, CommentID: 4, Mentioned: &user.User{ID: 2, Username: "dvn"}, Author: &user.User{ID: 1, Username: "joel"}}, mention)
+	case <-time.After(time.Second):
+		s.T().Fatal("The mention was not published")
+	}
+	assert.Never(s.T(), func() bool { return len(publisher.messages) > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+}
+
+func (s *TaskAPITestSuite) TestCreateEmptyComment() {
+	s.c.Request, _ = http.NewRequest(http.MethodPost, "/tasks/1/comments", bytes.NewReader([]byte(`{"body": ""}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.service.createComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+}
+
+func (s *TaskAPITestSuite) TestUpdateCommentOnlyNotifiesNewMentions() {
+	publisher := &recordingPublisher{messages: make(chan eventbus.Message, 10)}
+	s.service.taskPublisher = publisher
+	defer func() { s.service.taskPublisher = &LogPublisher{Logger: s.service.logger} }()
+
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1/comments/4", bytes.NewReader([]byte(`{"body": "@dvn @boss the pump is fixed"}`)))
+	s.c.Params = append(s.c.Params, validTaskId, validCommentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getCommentSQL).WithArgs(1, 4).WillReturnRows(s.commentRows(1, "joel", "@dvn the pump is fixed"))
+	s.sqlmock.ExpectExec(updateCommentSQL).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getUsersByUsernamesSQL).WithArgs("boss").WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).
+		AddRow(5, "boss", "manager", 1))
+
+	s.service.updateComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+	var updated comment
+	if err := json.Unmarshal(s.w.Body.Bytes(), &updated); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), "@dvn @boss the pump is fixed", updated.Body)
+	assert.NotNil(s.T(), updated.UpdatedDate)
+
+	select {
+	case msg := <-publisher.messages:
+		var mention Mention
+		assert.Nil(s.T(), json.Unmarshal(msg.Body, &mention))
+		assert.Equal(s.T(), "boss", mention.Mentioned.Username)
+	case <-time.After(time.Second):
+		s.T().Fatal("The mention was not published")
+	}
+}
+
+func (s *TaskAPITestSuite) TestManagerCantEditCommentsOfOthers() {
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1/comments/4", bytes.NewReader([]byte(`{"body": "edited"}`)))
+	s.c.Params = append(s.c.Params, validTaskId, validCommentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getCommentSQL).WithArgs(1, 4).WillReturnRows(s.commentRows(1, "joel", "the pump is fixed"))
+
+	s.service.updateComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestManagerDeletesCommentsOfOthers() {
+	s.c.Params = append(s.c.Params, validTaskId, validCommentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getCommentSQL).WithArgs(1, 4).WillReturnRows(s.commentRows(1, "joel", "the pump is fixed"))
+	s.sqlmock.ExpectExec(deleteCommentSQL).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.service.deleteComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestTechnicianCantDeleteCommentsOfOthers() {
+	s.c.Params = append(s.c.Params, validTaskId, validCommentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getCommentSQL).WithArgs(1, 4).WillReturnRows(s.commentRows(2, "dvn", "the pump is in room 3"))
+
+	s.service.deleteComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestCommentNotFound() {
+	s.c.Params = append(s.c.Params, validTaskId, validCommentID)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getCommentSQL).WithArgs(1, 4).WillReturnRows(sqlmock.NewRows(commentColumns))
+
+	s.service.deleteComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 404, s.w.Code)
+	var p problem.Problem
+	assert.Nil(s.T(), json.Unmarshal(s.w.Body.Bytes(), &p))
+	assert.Equal(s.T(), problem.CodeCommentNotFound, p.Code)
+}
+
+func (s *TaskAPITestSuite) TestInvalidCommentID() {
+	s.c.Params = append(s.c.Params, validTaskId, gin.Param{Key: "comment-id", Value: "first"})
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.service.deleteComment(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+}
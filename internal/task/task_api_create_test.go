@@ -8,6 +8,8 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"strings"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/user"
 	"sword-challenge/internal/util"
 )
@@ -19,7 +21,9 @@ func (s *TaskAPITestSuite) TestFailToCreateRequestedTaskInDatabase() {
 	s.c.Params = append(s.c.Params, gin.Param{Key: "task-id", Value: "1"})
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
 
+	s.sqlmock.ExpectBegin()
 	s.sqlmock.ExpectExec(createTaskSQL).WillReturnError(fmt.Errorf("as"))
+	s.sqlmock.ExpectRollback()
 
 	s.service.createTask(s.c)
 	s.c.Writer.Flush()
@@ -34,15 +38,43 @@ func (s *TaskAPITestSuite) TestCreateRequestedTask() {
 	s.c.Params = append(s.c.Params, gin.Param{Key: "task-id", Value: "1"})
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
 
+	s.sqlmock.ExpectBegin()
 	s.sqlmock.ExpectExec(createTaskSQL).WillReturnResult(sqlmock.NewResult(5, 1))
+	s.sqlmock.ExpectExec(insertHistorySQL).
+		WithArgs(5, 1, historyCreated, "summary", nil, sqlmock.AnyArg(), 5, 1, historyCreated, "status", nil, []byte("todo"),
+			5, 1, historyCreated, "user", nil, []byte("1"), 5, 1, historyCreated, "priority", nil, []byte("normal")).
+		WillReturnResult(sqlmock.NewResult(1, 4))
+	// The summary is indexed by the tokens of its words, never the words
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WithArgs(5, s.service.searchIndex.tokens("test")[0]).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
 
 	s.service.createTask(s.c)
 	s.c.Writer.Flush()
 
 	assert.Equal(s.T(), 201, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
 	var taskReceived task
 	if err := json.Unmarshal(s.w.Body.Bytes(), &taskReceived); err != nil {
 		s.T().Fatalf("Failed to parse response body: error: %v", err)
 	}
 	assert.Equal(s.T(), taskReceived.ID, 5)
 }
+
+func (s *TaskAPITestSuite) TestCreateTaskValidationErrors() {
+	body, _ := json.Marshal(map[string]interface{}{"summary": strings.Repeat("á", 2501), "user": map[string]interface{}{}})
+	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(body))
+
+	s.c.Request = req
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
+
+	s.service.createTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeValidationFailed, p.Code)
+	assert.ElementsMatch(s.T(), []string{"summary", "user.id"}, []string{p.Errors[0].Field, p.Errors[1].Field})
+}
@@ -0,0 +1,146 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"testing"
+)
+
+var versionedTaskColumns = append(append([]string{}, taskColumns...), "version")
+
+func TestWeakMatchIgnoresWeakPrefixes(t *testing.T) {
+	assert.True(t, weakMatch(`"3"`, etag(3)))
+	assert.True(t, weakMatch(`"1", W/"3"`, etag(3)))
+	assert.True(t, weakMatch("*", etag(3)))
+	assert.False(t, weakMatch(`"2"`, etag(3)))
+	assert.False(t, weakMatch("", etag(3)))
+}
+
+// versionedTaskRows is the task of ownTaskRows at the version
+func (s *TaskAPITestSuite) versionedTaskRows(version int) *sqlmock.Rows {
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	return sqlmock.NewRows(versionedTaskColumns).AddRow(1, et.EncryptedSummary, "in_progress", nil, 1, "joel", 2, "dvn", nil, "normal", nil, version)
+}
+
+func (s *TaskAPITestSuite) TestGetTaskReturnsItsETag() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/1", nil)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+
+	s.service.getTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), `"3"`, s.w.Header().Get("ETag"))
+	var t task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &t); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), "fix the pump", t.Summary)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestGetTaskNotModified() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/1", nil)
+	s.c.Request.Header.Set("If-None-Match", `W/"3"`)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+
+	s.service.getTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 304, s.w.Code)
+	assert.Equal(s.T(), `"3"`, s.w.Header().Get("ETag"))
+	assert.Empty(s.T(), s.w.Body.Bytes())
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateTaskChangedSinceIfMatch() {
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader(validJsonTaskEdit))
+	s.c.Request.Header.Set("If-Match", `"2"`)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectRollback()
+
+	s.service.updateTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 412, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodePreconditionFailed, p.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateTaskWithMatchingIfMatch() {
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader(validJsonTaskEdit))
+	s.c.Request.Header.Set("If-Match", `"3"`)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectExec(updateTaskSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(deleteSearchTokensSQL).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(4))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.updateTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), `"4"`, s.w.Header().Get("ETag"))
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestDeleteTaskWithoutRequiredIfMatch() {
+	s.service.RequireIfMatch = true
+	defer func() { s.service.RequireIfMatch = false }()
+	s.c.Request, _ = http.NewRequest(http.MethodDelete, "/tasks/1", nil)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.service.deleteTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 428, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestDeleteTaskWithWeakIfMatch() {
+	s.c.Request, _ = http.NewRequest(http.MethodDelete, "/tasks/1", nil)
+	// If-Match uses the strong comparison so weak tags never match
+	s.c.Request.Header.Set("If-Match", `W/"3"`)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectRollback()
+
+	s.service.deleteTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 412, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
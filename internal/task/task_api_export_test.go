@@ -0,0 +1,101 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+)
+
+const insertExportSQL = "INSERT INTO task_exports \\(user_id, format, filters\\) VALUES \\(.+\\);"
+const finishExportSQL = "UPDATE task_exports SET row_count = .+, finished_date = CURRENT_TIMESTAMP WHERE id = .+;"
+
+func (s *TaskAPITestSuite) TestExportTasksAsCSV() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/export?format=csv", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	pump, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	formula, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "=HYPERLINK(\"http://evil\")"})
+	s.sqlmock.ExpectExec(insertExportSQL).WithArgs(1, "csv", []byte(`{"status":null,"priority":null,"dueBefore":null,"dueAfter":null,"overdue":false,"sort":""}`)).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	// Technicians only export their own tasks
+	s.sqlmock.ExpectQuery(getTasksSQL).WithArgs(1).WillReturnRows(sqlmock.NewRows(taskColumns).
+		AddRow(1, pump.EncryptedSummary, "todo", nil, 1, "joel", 2, "dvn", nil, "normal", 30).
+		AddRow(2, formula.EncryptedSummary, "todo", nil, 1, "joel", 0, "", nil, "high", nil))
+	s.sqlmock.ExpectExec(finishExportSQL).WithArgs(2, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.service.exportTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), "text/csv; charset=utf-8", s.w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(s.w.Body).ReadAll()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), [][]string{
+		csvHeader,
+		{"1", "fix the pump", "todo", "normal", "", "30", "", "joel", "dvn"},
+		{"2", "'=HYPERLINK(\"http://evil\")", "todo", "high", "", "", "", "joel", ""},
+	}, records)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestExportTasksAsJSONLines() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/export?format=jsonl&status=done", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	rows := sqlmock.NewRows(taskColumns)
+	for id := 1; id <= exportFlushRows+1; id++ {
+		et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: fmt.Sprintf("task %d", id)})
+		rows.AddRow(id, et.EncryptedSummary, "done", nil, 1, "joel", 2, "dvn", nil, "normal", nil)
+	}
+	s.sqlmock.ExpectExec(insertExportSQL).WithArgs(2, "jsonl", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(8, 1))
+	s.sqlmock.ExpectQuery("SELECT .+ WHERE t.deleted_at IS NULL AND t.status IN \\(\\?\\) ORDER BY t.id;").WithArgs(StatusDone).WillReturnRows(rows)
+	s.sqlmock.ExpectExec(finishExportSQL).WithArgs(exportFlushRows+1, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.service.exportTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Contains(s.T(), s.w.Header().Get("Content-Disposition"), ".jsonl")
+	lines := 0
+	scanner := bufio.NewScanner(bytes.NewReader(s.w.Body.Bytes()))
+	for scanner.Scan() {
+		var t task
+		assert.Nil(s.T(), json.Unmarshal(scanner.Bytes(), &t))
+		lines++
+		assert.Equal(s.T(), fmt.Sprintf("task %d", lines), t.Summary)
+	}
+	assert.Equal(s.T(), exportFlushRows+1, lines)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestExportTasksWithoutFormat() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/export", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.service.exportTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestExportTasksIsRefusedWhenItCantBeRecorded() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/export?format=csv", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectExec(insertExportSQL).WillReturnError(fmt.Errorf("e"))
+
+	s.service.exportTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 500, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
@@ -1,15 +1,18 @@
 package task
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
+	"net/http"
 	"sword-challenge/internal/user"
 	"sword-challenge/internal/util"
 )
 
 func (s *TaskAPITestSuite) TestGetRequestedTaskDatabaseFailure() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks", nil)
 	s.c.Params = append(s.c.Params, validTaskId)
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
 
@@ -21,12 +24,13 @@ func (s *TaskAPITestSuite) TestGetRequestedTaskDatabaseFailure() {
 }
 
 func (s *TaskAPITestSuite) TestGetRequestedTaskTechnician() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks", nil)
 	s.c.Params = append(s.c.Params, validTaskId)
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
 
-	expectedTask := task{ID: 1, CompletedDate: nil, User: &user.User{ID: 2, Username: "joel"}}
-	et, _ := s.tEncryptor.encryptTask(&expectedTask)
-	rows := sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, nil, 2, "joel")
+	expectedTask := task{ID: 1, Status: StatusTodo, Priority: PriorityNormal, CompletedDate: nil, User: &user.User{ID: 2, Username: "joel"}}
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &expectedTask)
+	rows := sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 2, "joel", 0, "", nil, "normal", nil)
 
 	s.sqlmock.ExpectQuery(getTasksSQL).WithArgs(1).WillReturnRows(rows)
 	s.service.getTasks(s.c)
@@ -42,12 +46,13 @@ func (s *TaskAPITestSuite) TestGetRequestedTaskTechnician() {
 }
 
 func (s *TaskAPITestSuite) TestGetRequestedTaskByManagerReturnsAllTasks() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks", nil)
 	s.c.Params = append(s.c.Params, validTaskId)
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
 
-	expectedTask := task{ID: 1, CompletedDate: nil, User: &user.User{ID: 2, Username: "joel"}}
-	et, _ := s.tEncryptor.encryptTask(&expectedTask)
-	rows := sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, nil, 2, "joel")
+	expectedTask := task{ID: 1, Status: StatusTodo, Priority: PriorityNormal, CompletedDate: nil, User: &user.User{ID: 2, Username: "joel"}}
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &expectedTask)
+	rows := sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 2, "joel", 0, "", nil, "normal", nil)
 
 	s.sqlmock.ExpectQuery(getAllTasksSQL).WillReturnRows(rows)
 	s.service.getTasks(s.c)
@@ -61,3 +66,27 @@ func (s *TaskAPITestSuite) TestGetRequestedTaskByManagerReturnsAllTasks() {
 	}
 	assert.Equal(s.T(), taskReceived[0], expectedTask)
 }
+
+func (s *TaskAPITestSuite) TestGetTasksWithFiltersAndSorting() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks?status=todo&status=blocked&priority=urgent&sort=-dueDate", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery("SELECT .+ WHERE t.deleted_at IS NULL AND t.user_id = .+ AND t.status IN (.+, .+) AND t.priority IN (.+) ORDER BY t.due_date IS NULL DESC, t.due_date DESC, t.id;").
+		WithArgs(1, "todo", "blocked", "urgent").
+		WillReturnRows(sqlmock.NewRows(taskColumns))
+	s.service.getTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestGetTasksWithInvalidQuery() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks?sort=summary&dueBefore=tomorrow", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.service.getTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+}
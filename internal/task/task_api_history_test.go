@@ -0,0 +1,76 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"testing"
+	"time"
+)
+
+const getHistorySQL = "SELECT h.id, h.task_id, h.action, h.field, h.old_value, h.new_value, h.created_date, u.id as 'user.id', u.username as 'user.username' FROM task_history h INNER JOIN users u on h.user_id = u.id WHERE h.task_id = .+ ORDER BY h.id;"
+
+var historyColumns = []string{"id", "task_id", "action", "field", "old_value", "new_value", "created_date", "user.id", "user.username"}
+
+func TestTaskChangesOnlyHasTheFieldsThatChanged(t *testing.T) {
+	dueDate := time.Date(2021, 10, 25, 9, 0, 0, 0, time.UTC)
+	minutes := 30
+	before := &encryptedTask{ID: 1, EncryptedSummary: []byte("a"), Status: StatusTodo, User: &user.User{ID: 1}, Priority: PriorityNormal}
+	after := &encryptedTask{ID: 1, EncryptedSummary: []byte("a"), Status: StatusTodo, User: &user.User{}, DueDate: &dueDate, Priority: PriorityUrgent, EstimatedMinutes: &minutes}
+
+	changes := taskChanges(before, after)
+
+	assert.Equal(t, []fieldChange{
+		{Field: "user", Before: []byte("1")},
+		{Field: "dueDate", After: []byte("2021-10-25T09:00:00Z")},
+		{Field: "priority", Before: []byte("normal"), After: []byte("urgent")},
+		{Field: "estimatedMinutes", After: []byte("30")},
+	}, changes)
+	assert.Empty(t, taskChanges(before, before))
+}
+
+func (s *TaskAPITestSuite) TestGetHistoryDecryptsSummaries() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	created := time.Date(2021, 10, 23, 10, 0, 0, 0, time.UTC)
+	oldSummary, _ := s.tEncryptor.encrypt(context.Background(), []byte("fix the pump"))
+	newSummary, _ := s.tEncryptor.encrypt(context.Background(), []byte("fix the pump in room 3"))
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+	s.sqlmock.ExpectQuery(getHistorySQL).WithArgs(1).WillReturnRows(sqlmock.NewRows(historyColumns).
+		AddRow(1, 1, "created", "summary", nil, oldSummary, created, 2, "dvn").
+		AddRow(2, 1, "updated", "summary", oldSummary, newSummary, created, 1, "joel").
+		AddRow(3, 1, "transitioned", "status", []byte("todo"), []byte("in_progress"), created, 1, "joel"))
+
+	s.service.getHistory(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var history []historyEntry
+	if err := json.Unmarshal(s.w.Body.Bytes(), &history); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	summary, newSummaryText, todo, inProgress := "fix the pump", "fix the pump in room 3", "todo", "in_progress"
+	assert.Equal(s.T(), []historyEntry{
+		{Action: historyCreated, Field: "summary", After: &summary, User: &user.User{ID: 2, Username: "dvn"}, CreatedDate: &created},
+		{Action: historyUpdated, Field: "summary", Before: &summary, After: &newSummaryText, User: &user.User{ID: 1, Username: "joel"}, CreatedDate: &created},
+		{Action: historyTransitioned, Field: "status", Before: &todo, After: &inProgress, User: &user.User{ID: 1, Username: "joel"}, CreatedDate: &created},
+	}, history)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestGetHistoryOfAnotherTechniciansTask() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 3, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+
+	s.service.getHistory(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
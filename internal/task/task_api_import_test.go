@@ -0,0 +1,197 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strings"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+)
+
+const importTasksSQL = "INSERT INTO tasks \\(user_id, summary, created_by, due_date, priority, estimated_minutes, schedule_id, scheduled_for\\) VALUES .+;"
+const insertedTaskIDsSQL = "SELECT id FROM tasks WHERE id >= .+ AND summary IN \\(.+\\) ORDER BY id;"
+
+// taskIDRows are the ids read back after inserting tasks
+func taskIDRows(ids ...int) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"id"})
+	for _, id := range ids {
+		rows.AddRow(id)
+	}
+	return rows
+}
+
+func (s *TaskAPITestSuite) importRequest(role string, query string, body string) {
+	s.c.Request, _ = http.NewRequest(http.MethodPost, "/tasks/import?"+query, strings.NewReader(body))
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: role}})
+}
+
+func (s *TaskAPITestSuite) importResult() ImportResult {
+	var result ImportResult
+	if err := json.Unmarshal(s.w.Body.Bytes(), &result); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	return result
+}
+
+func (s *TaskAPITestSuite) TestImportTasksFromCSV() {
+	// An export can be imported again, the columns that can't be imported are ignored
+	s.importRequest("manager", "format=csv", "id,summary,status,priority,dueDate,estimatedMinutes,completedDate,user,createdBy\n"+
+		"1,fix the pump,done,high,2021-10-01T10:00:00Z,30,2021-09-30T10:00:00Z,joel,dvn\n"+
+		"2,'=SUM(A1:A2),todo,,,,,,dvn\n")
+
+	s.sqlmock.ExpectQuery(getUsersByUsernamesSQL).WithArgs("joel").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow(1, "joel", "technician", 1))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectExec(importTasksSQL).
+		WithArgs(1, sqlmock.AnyArg(), 2, sqlmock.AnyArg(), PriorityHigh, 30, nil, nil, nil, sqlmock.AnyArg(), 2, nil, PriorityNormal, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(10, 2))
+	s.sqlmock.ExpectQuery(insertedTaskIDsSQL).WithArgs(10, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(taskIDRows(10, 11))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 9))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 6))
+	s.sqlmock.ExpectCommit()
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	result := s.importResult()
+	assert.Equal(s.T(), 2, result.Rows)
+	assert.Equal(s.T(), 2, result.Imported)
+	assert.Empty(s.T(), result.Errors)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksReportsEveryInvalidRow() {
+	s.importRequest("manager", "format=jsonl", `{"summary": "fix the pump"}`+"\n"+
+		`{"summary": "`+strings.Repeat("a", 2501)+`"}`+"\n"+
+		"\n"+
+		`{"summary": "check the valve", "user": {"username": "nobody"}}`+"\n"+
+		`not json`+"\n"+
+		`{"summary": "oil the gears", "estimatedMinutes": 0, "dueDate": "tomorrow"}`+"\n")
+
+	s.sqlmock.ExpectQuery(getUsersByUsernamesSQL).WithArgs("nobody").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}))
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	// Nothing is imported when any row is invalid
+	assert.Equal(s.T(), 200, s.w.Code)
+	result := s.importResult()
+	assert.Equal(s.T(), 5, result.Rows)
+	assert.Equal(s.T(), 0, result.Imported)
+	assert.Equal(s.T(), []ImportError{
+		{Line: 2, Field: "summary", Code: "max", Message: "must be at most 2500 characters"},
+		{Line: 4, Field: "user.username", Code: "exists", Message: "user nobody does not exist"},
+		{Line: 5, Code: "syntax", Message: "must be a JSON object"},
+		{Line: 6, Field: "dueDate", Code: "type", Message: "must be an RFC 3339 date"},
+	}, result.Errors)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksDryRunOnlyValidates() {
+	s.importRequest("manager", "format=csv&dryRun=true", "summary,user\nfix the pump,joel\n")
+
+	s.sqlmock.ExpectQuery(getUsersByUsernamesSQL).WithArgs("joel").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow(1, "joel", "technician", 1))
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	result := s.importResult()
+	assert.True(s.T(), result.DryRun)
+	assert.Equal(s.T(), 1, result.Rows)
+	assert.Equal(s.T(), 0, result.Imported)
+	assert.Empty(s.T(), result.Errors)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksInsertsInBatches() {
+	var body strings.Builder
+	body.WriteString("summary\n")
+	for i := 1; i <= importBatchSize+1; i++ {
+		body.WriteString(fmt.Sprintf("task %d\n", i))
+	}
+	s.importRequest("manager", "format=csv", body.String())
+
+	s.sqlmock.ExpectBegin()
+	// Ids aren't consecutive with auto_increment_increment or interleaved inserts, so they're read back
+	ids := make([]int, importBatchSize)
+	for i := range ids {
+		ids[i] = 1 + 2*i
+	}
+	s.sqlmock.ExpectExec(importTasksSQL).WillReturnResult(sqlmock.NewResult(1, importBatchSize))
+	s.sqlmock.ExpectQuery(insertedTaskIDsSQL).WillReturnRows(taskIDRows(ids...))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, importBatchSize*3))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, importBatchSize*2))
+	s.sqlmock.ExpectExec(importTasksSQL).WillReturnResult(sqlmock.NewResult(importBatchSize+7, 1))
+	s.sqlmock.ExpectQuery(insertedTaskIDsSQL).WithArgs(importBatchSize+7, sqlmock.AnyArg()).WillReturnRows(taskIDRows(importBatchSize + 7))
+	s.sqlmock.ExpectExec(insertHistorySQL).WithArgs(importBatchSize+7, 2, historyCreated, "summary", nil, sqlmock.AnyArg(),
+		importBatchSize+7, 2, historyCreated, "status", nil, []byte(StatusTodo),
+		importBatchSize+7, 2, historyCreated, "priority", nil, []byte(PriorityNormal)).
+		WillReturnResult(sqlmock.NewResult(1, 3))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.sqlmock.ExpectCommit()
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), importBatchSize+1, s.importResult().Imported)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksFailsWhenTheInsertedTasksArentFound() {
+	s.importRequest("manager", "format=csv", "summary\nfix the pump\nreplace filter\n")
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectExec(importTasksSQL).WillReturnResult(sqlmock.NewResult(10, 2))
+	s.sqlmock.ExpectQuery(insertedTaskIDsSQL).WillReturnRows(taskIDRows(10))
+	s.sqlmock.ExpectRollback()
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 500, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksAsTechnician() {
+	s.importRequest("technician", "format=csv", "summary\nfix the pump\n")
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksWithJSONLineTooLong() {
+	s.importRequest("manager", "format=jsonl", `{"summary": "fix the pump"}`+"\n"+
+		`{"summary": "`+strings.Repeat("a", maxImportLineSize)+`"}`+"\n")
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	// The line is refused like any invalid row rather than failing the request
+	assert.Equal(s.T(), 200, s.w.Code)
+	result := s.importResult()
+	assert.Equal(s.T(), 0, result.Imported)
+	assert.Equal(s.T(), []ImportError{{Line: 2, Code: "size", Message: fmt.Sprintf("must be at most %d bytes", maxImportLineSize)}}, result.Errors)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestImportTasksTooLarge() {
+	s.importRequest("manager", "format=csv", "summary\n"+strings.Repeat("a", maxImportSize))
+
+	s.service.importTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 413, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), "import_too_large")
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
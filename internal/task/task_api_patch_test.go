@@ -0,0 +1,122 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+)
+
+func (s *TaskAPITestSuite) patchRequest(contentType string, body string) {
+	s.c.Request, _ = http.NewRequest(http.MethodPatch, "/tasks/1", bytes.NewReader([]byte(body)))
+	s.c.Request.Header.Set("Content-Type", contentType)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskOnlyChangesTheFieldsInThePatch() {
+	s.patchRequest(mimeMergePatch, `{"priority": "high"}`)
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectExec("UPDATE tasks SET priority = \\?, version = version \\+ 1 WHERE id = \\?;").
+		WithArgs(PriorityHigh, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(4))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), `"4"`, s.w.Header().Get("ETag"))
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskNullClearsTheField() {
+	s.patchRequest(gin.MIMEJSON, `{"dueDate": null}`)
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(3))
+	s.sqlmock.ExpectExec("UPDATE tasks SET overdue_notified_at = .+, due_date = \\?, version = version \\+ 1 WHERE id = \\?;").
+		WithArgs(nil, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.versionedTaskRows(4))
+	s.sqlmock.ExpectExec(insertHistorySQL).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskWithInvalidField() {
+	s.patchRequest(mimeMergePatch, `{"estimatedMinutes": 0}`)
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeValidationFailed, p.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskCantClearTheSummary() {
+	s.patchRequest(mimeMergePatch, `{"summary": null}`)
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), `{"field":"summary","code":"required","message":"can't be cleared"}`)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskWithStatus() {
+	s.patchRequest(mimeMergePatch, `{"status": "done", "completedDate": null}`)
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), `"field":"status","code":"readonly"`)
+	assert.Contains(s.T(), s.w.Body.String(), `"field":"completedDate","code":"readonly"`)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskThatIsNotAnObject() {
+	s.patchRequest(mimeMergePatch, `null`)
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeInvalidBody, p.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPatchTaskWithUnsupportedContentType() {
+	s.patchRequest("text/plain", `{"priority": "high"}`)
+
+	s.service.patchTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 415, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
@@ -2,20 +2,21 @@ package task
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/user"
 	"sword-challenge/internal/util"
 	"time"
 )
 
 func (s *TaskAPITestSuite) TestUpdateTaskFailureWhenFetchingTaskFromTheDatabase() {
-	jsonTask, _ := json.Marshal(task{Summary: "test", CompletedDate: nil, User: &user.User{ID: 2, Username: "o"}})
-	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(jsonTask))
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader(validJsonTaskEdit))
 
 	s.c.Request = req
 	s.c.Params = append(s.c.Params, validTaskId)
@@ -30,8 +31,7 @@ func (s *TaskAPITestSuite) TestUpdateTaskFailureWhenFetchingTaskFromTheDatabase(
 }
 
 func (s *TaskAPITestSuite) TestUpdateTaskWhenTaskDoesntExist() {
-	jsonTask, _ := json.Marshal(task{Summary: "test", CompletedDate: nil, User: &user.User{ID: 2, Username: "o"}})
-	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(jsonTask))
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader(validJsonTaskEdit))
 
 	s.c.Request = req
 	s.c.Params = append(s.c.Params, validTaskId)
@@ -46,12 +46,12 @@ func (s *TaskAPITestSuite) TestUpdateTaskWhenTaskDoesntExist() {
 }
 
 func (s *TaskAPITestSuite) TestUpdateTaskWhenTaskExistsButDoesntBelongToNonAdminUser() {
-	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(validJsonTask))
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader(validJsonTaskEdit))
 
 	s.c.Request = req
 	s.c.Params = append(s.c.Params, validTaskId)
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
-	rows := sqlmock.NewRows(taskColumns).AddRow(1, "1", nil, 2, "joel")
+	rows := sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 2, "joel", 0, "", nil, "normal", nil)
 	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(rows)
 
 	s.service.updateTask(s.c)
@@ -61,17 +61,18 @@ func (s *TaskAPITestSuite) TestUpdateTaskWhenTaskExistsButDoesntBelongToNonAdmin
 }
 
 func (s *TaskAPITestSuite) TestUpdateTaskFailToUpdateTask() {
-	t := time.Date(2011, 1, 1, 1, 1, 1, 1, time.UTC)
-	jsonTask, _ := json.Marshal(task{Summary: "test", CompletedDate: &t, User: &user.User{ID: 2, Username: "o"}})
-	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(jsonTask))
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader(validJsonTaskEdit))
 
 	s.c.Request = req
 	s.c.Params = append(s.c.Params, gin.Param{Key: "task-id", Value: "1"})
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
-	rows := sqlmock.NewRows(taskColumns).AddRow(1, "1", nil, 1, "joel")
+	rows := sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil)
 
 	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(rows)
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil))
 	s.sqlmock.ExpectExec(updateTaskSQL).WillReturnError(fmt.Errorf("a"))
+	s.sqlmock.ExpectRollback()
 
 	s.service.updateTask(s.c)
 	s.c.Writer.Flush()
@@ -80,24 +81,30 @@ func (s *TaskAPITestSuite) TestUpdateTaskFailToUpdateTask() {
 }
 
 func (s *TaskAPITestSuite) TestUpdateTaskSuccess() {
-	t := time.Date(2011, 1, 1, 1, 1, 1, 1, time.UTC)
-	completedTask := task{Summary: "test", CompletedDate: &t, User: &user.User{ID: 1, Username: "o"}}
-	et, _ := s.tEncryptor.encryptTask(&completedTask)
-	jsonTask, _ := json.Marshal(completedTask)
-	req, _ := http.NewRequest(http.MethodPost, "/tasks", bytes.NewReader(jsonTask))
+	updatedTask := task{Summary: "test", User: &user.User{ID: 1, Username: "o"}}
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &updatedTask)
+	req, _ := http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader([]byte(`{"summary": "test"}`)))
 
 	s.c.Request = req
 	s.c.Params = append(s.c.Params, gin.Param{Key: "task-id", Value: "1"})
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
-	rows := sqlmock.NewRows(taskColumns).AddRow(1, "1", nil, 1, "joel")
+	rows := sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil)
 
 	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(rows)
-	s.sqlmock.ExpectExec(updateTaskSQL).WillReturnResult(sqlmock.NewResult(5, 1))
-	updatedRows := sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, &t, 5, "joel")
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil))
+	// The fields missing from the body are cleared
+	s.sqlmock.ExpectExec(updateTaskSQL).WithArgs(sqlmock.AnyArg(), nil, nil, PriorityNormal, nil, 1).WillReturnResult(sqlmock.NewResult(5, 1))
+	// The tokens of the old summary are replaced
+	s.sqlmock.ExpectExec(deleteSearchTokensSQL).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertSearchTokensSQL).WithArgs(1, s.service.searchIndex.tokens("test")[0]).WillReturnResult(sqlmock.NewResult(0, 1))
+	updatedRows := sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 5, "joel", 0, "", nil, "normal", nil)
 	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(updatedRows)
-
-	userRows := sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow(1, "joao", util.AdminRole, 2).AddRow(2, "j", util.AdminRole, 2)
-	s.sqlmock.ExpectQuery("SELECT u.id, u.username FROM users u INNER JOIN roles r on u.role_id = r.id WHERE r.name = .+;").WillReturnRows(userRows)
+	// Only the fields that changed are recorded, the summary is kept encrypted
+	s.sqlmock.ExpectExec(insertHistorySQL).
+		WithArgs(1, 1, historyUpdated, "summary", []byte("1"), et.EncryptedSummary, 1, 1, historyUpdated, "user", []byte("1"), []byte("5")).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	s.sqlmock.ExpectCommit()
 
 	s.service.updateTask(s.c)
 	s.c.Writer.Flush()
@@ -108,4 +115,80 @@ func (s *TaskAPITestSuite) TestUpdateTaskSuccess() {
 		s.T().Fatalf("Failed to parse response body: error: %v", err)
 	}
 	assert.Equal(s.T(), taskReceived.User.ID, 5)
+	assert.Nil(s.T(), taskReceived.CompletedDate)
+	assert.Equal(s.T(), StatusTodo, taskReceived.Status)
+}
+
+func (s *TaskAPITestSuite) TestUpdateTaskWithoutSummary() {
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader([]byte(`{"priority": "high"}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
+
+	s.service.updateTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), `{"field":"summary","code":"required","message":"is required"}`)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateTaskWithFieldsChangedElsewhere() {
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1",
+		bytes.NewReader([]byte(`{"summary": "test", "status": "done", "user": {"id": 3}}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 2, "joel", 1, "dvn", nil, "normal", nil))
+
+	s.service.updateTask(s.c)
+	s.c.Writer.Flush()
+
+	// The client is told where to send them instead of having them ignored
+	assert.Equal(s.T(), 400, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeValidationFailed, p.Code)
+	assert.Equal(s.T(), []problem.FieldError{
+		{Field: "status", Code: "readonly", Message: "is changed through POST /api/v1/tasks/:task-id/transitions"},
+		{Field: "user", Code: "readonly", Message: "is changed through PUT /api/v1/tasks/:task-id/assignee"},
+	}, p.Errors)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateTaskWithTheFieldsOfGet() {
+	// A task read with GET can be sent back with its status, completion date and assignee as long as they didn't change
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader([]byte(
+		`{"id": 1, "summary": "test", "status": "done", "completedDate": "2021-10-01T10:00:00Z", "user": {"id": 2, "username": "joel"}, "createdBy": {"id": 1, "username": "dvn"}, "priority": "high"}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
+	completed := time.Date(2021, 10, 1, 10, 0, 0, 0, time.UTC)
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "done", completed, 2, "joel", 1, "dvn", nil, "normal", nil))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "done", completed, 2, "joel", 1, "dvn", nil, "normal", nil))
+	s.sqlmock.ExpectExec(updateTaskSQL).WithArgs(sqlmock.AnyArg(), nil, nil, PriorityHigh, nil, 1).WillReturnError(fmt.Errorf("stop"))
+	s.sqlmock.ExpectRollback()
+
+	s.service.updateTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 500, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateTaskOfTheUnassignedPoolWithNullUser() {
+	s.c.Request, _ = http.NewRequest(http.MethodPut, "/tasks/1", bytes.NewReader([]byte(`{"summary": "test", "user": null, "status": "todo"}`)))
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 0, "", 1, "dvn", nil, "normal", nil))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 0, "", 1, "dvn", nil, "normal", nil))
+	s.sqlmock.ExpectExec(updateTaskSQL).WillReturnError(fmt.Errorf("stop"))
+	s.sqlmock.ExpectRollback()
+
+	s.service.updateTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 500, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
 }
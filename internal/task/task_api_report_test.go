@@ -0,0 +1,105 @@
+package task
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const getCompletionsSQL = "SELECT DATE_FORMAT\\(.+, '%Y-%m-%d'\\) AS period, u.id AS 'user.id', u.username AS 'user.username', COUNT\\(\\*\\) AS completed FROM tasks t .+ GROUP BY period, u.id, u.username ORDER BY period, u.username;"
+const getTechnicianStatsSQL = "SELECT u.id AS 'user.id', u.username AS 'user.username', COUNT\\(.+\\) AS open, .+ FROM users u .+ GROUP BY u.id, u.username ORDER BY u.username;"
+const getTaskStatsSQL = "SELECT COUNT\\(.+\\) AS open, .+ AS average_completion_minutes FROM tasks t WHERE t.deleted_at IS NULL;"
+
+var taskStatsColumns = []string{"open", "completed", "overdue", "average_completion_minutes"}
+
+func (s *TaskAPITestSuite) reportRequest(role string, url string) {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, url, nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: role}})
+}
+
+func (s *TaskAPITestSuite) TestCompletionsReportByWeek() {
+	s.reportRequest("manager", "/reports/completions?period=week&from=2021-10-04T00:00:00Z&to=2021-10-18T00:00:00Z")
+
+	from, to := time.Date(2021, 10, 4, 0, 0, 0, 0, time.UTC), time.Date(2021, 10, 18, 0, 0, 0, 0, time.UTC)
+	s.sqlmock.ExpectQuery(getCompletionsSQL).WithArgs(StatusDone, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"period", "user.id", "user.username", "completed"}).
+			AddRow("2021-10-04", 1, "joel", 3).
+			AddRow("2021-10-11", 1, "joel", 1))
+
+	s.service.getCompletionsReport(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var completions []Completions
+	assert.Nil(s.T(), json.Unmarshal(s.w.Body.Bytes(), &completions))
+	assert.Equal(s.T(), []Completions{
+		{Period: "2021-10-04", User: user.User{ID: 1, Username: "joel"}, Completed: 3},
+		{Period: "2021-10-11", User: user.User{ID: 1, Username: "joel"}, Completed: 1},
+	}, completions)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestTechniciansReportAsCSV() {
+	s.reportRequest("manager", "/reports/technicians?format=csv")
+
+	s.sqlmock.ExpectQuery(getTechnicianStatsSQL).
+		WillReturnRows(sqlmock.NewRows(append([]string{"user.id", "user.username"}, taskStatsColumns...)).
+			AddRow(1, "joel", 4, 2, 1, "90.5").
+			AddRow(3, "=ana", 0, 0, 0, nil))
+
+	s.service.getTechniciansReport(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Equal(s.T(), "text/csv; charset=utf-8", s.w.Header().Get("Content-Type"))
+	assert.Contains(s.T(), s.w.Header().Get("Content-Disposition"), "report-technicians-")
+	records, err := csv.NewReader(s.w.Body).ReadAll()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), [][]string{
+		{"user", "open", "completed", "overdue", "averageCompletionMinutes"},
+		{"joel", "4", "2", "1", "90.5"},
+		{"'=ana", "0", "0", "0", ""},
+	}, records)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestSummaryReportDefaultsToTheLastThirtyDays() {
+	s.reportRequest("manager", "/reports/summary")
+
+	s.sqlmock.ExpectQuery(getTaskStatsSQL).
+		WithArgs(StatusDone, StatusCancelled, StatusDone, sqlmock.AnyArg(), sqlmock.AnyArg(), StatusDone, StatusCancelled, sqlmock.AnyArg(), StatusDone, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(taskStatsColumns).AddRow(7, 3, 2, "45.0"))
+
+	s.service.getSummaryReport(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.JSONEq(s.T(), `{"open": 7, "completed": 3, "overdue": 2, "averageCompletionMinutes": 45}`, s.w.Body.String())
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestReportWithEmptyRange() {
+	s.reportRequest("manager", "/reports/summary?from=2021-10-18T00:00:00Z&to=2021-10-04T00:00:00Z")
+
+	s.service.getSummaryReport(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), `"field":"from"`)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestReportsAsTechnician() {
+	s.reportRequest("technician", "/reports/completions")
+
+	s.service.getCompletionsReport(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
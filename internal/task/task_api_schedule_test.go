@@ -0,0 +1,180 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const insertScheduleSQL = "INSERT INTO task_schedules \\(summary, cron, timezone, user_id, created_by, priority, estimated_minutes, next_run\\) VALUES \\(.+\\);"
+const lockScheduleSQL = "SELECT sc.id, .+ FROM task_schedules sc .+ WHERE sc.id = .+ FOR UPDATE OF sc;"
+const updateScheduleSQL = "UPDATE task_schedules SET summary = .+, cron = .+, timezone = .+, user_id = .+, priority = .+, estimated_minutes = .+, paused = .+, next_run = .+ WHERE id = .+;"
+
+func (s *TaskAPITestSuite) scheduleRequest(role string, method string, url string, body string) {
+	s.c.Request, _ = http.NewRequest(method, url, bytes.NewReader([]byte(body)))
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Username: "dvn", Role: &user.Role{Name: role}})
+}
+
+func (s *TaskAPITestSuite) scheduleResponse() schedule {
+	var sc schedule
+	if err := json.Unmarshal(s.w.Body.Bytes(), &sc); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	return sc
+}
+
+func (s *TaskAPITestSuite) TestCreateSchedule() {
+	s.scheduleRequest("manager", http.MethodPost, "/schedules",
+		`{"summary": "calibrate device", "cron": "0 9 * * 1", "timezone": "Europe/Lisbon", "user": {"id": 1}, "estimatedMinutes": 30}`)
+
+	s.sqlmock.ExpectQuery(getUserByIDSQL).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}).AddRow(1, "joel", "technician", 1))
+	s.sqlmock.ExpectExec(insertScheduleSQL).WithArgs(sqlmock.AnyArg(), "0 9 * * 1", "Europe/Lisbon", 1, 2, PriorityNormal, 30, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(7, 1))
+
+	s.service.createSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 201, s.w.Code)
+	sc := s.scheduleResponse()
+	assert.Equal(s.T(), 7, sc.ID)
+	assert.Equal(s.T(), "joel", sc.User.Username)
+	assert.Equal(s.T(), "dvn", sc.CreatedBy.Username)
+	assert.False(s.T(), sc.Paused)
+	// The first occurrence is the next Monday at 9 in Lisbon
+	lisbon, _ := time.LoadLocation("Europe/Lisbon")
+	nextRun := sc.NextRun.In(lisbon)
+	assert.Equal(s.T(), time.Monday, nextRun.Weekday())
+	assert.Equal(s.T(), 9, nextRun.Hour())
+	assert.True(s.T(), nextRun.After(time.Now()))
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestCreateScheduleWithInvalidCron() {
+	s.scheduleRequest("manager", http.MethodPost, "/schedules", `{"summary": "calibrate device", "cron": "every monday"}`)
+
+	s.service.createSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), `"field":"cron"`)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestCreateScheduleThatNeverOccurs() {
+	s.scheduleRequest("manager", http.MethodPost, "/schedules", `{"summary": "calibrate device", "cron": "0 9 30 2 *"}`)
+
+	s.service.createSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), "never occurs")
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateScheduleKeepsTheOccurrencesWithTasks() {
+	s.scheduleRequest("manager", http.MethodPut, "/schedules/7", `{"summary": "calibrate both devices", "cron": "0 9 * * *", "priority": "high"}`)
+	s.c.Params = append(s.c.Params, gin.Param{Key: "schedule-id", Value: "7"})
+
+	summary, _ := s.tEncryptor.encrypt(context.Background(), []byte("calibrate device"))
+	lastRun := time.Now().UTC().Truncate(24 * time.Hour).Add(6*24*time.Hour + 9*time.Hour)
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockScheduleSQL).WithArgs(7).WillReturnRows(sqlmock.NewRows(scheduleColumns).
+		AddRow(7, summary, "0 9 * * 1", "UTC", 1, "joel", 2, "dvn", "normal", nil, false, lastRun.Add(7*24*time.Hour), lastRun))
+	// The assignee is cleared and the new schedule starts after the last task created
+	s.sqlmock.ExpectExec(updateScheduleSQL).
+		WithArgs(sqlmock.AnyArg(), "0 9 * * *", "UTC", nil, PriorityHigh, nil, false, lastRun.Add(24*time.Hour), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.updateSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	sc := s.scheduleResponse()
+	assert.Equal(s.T(), "calibrate both devices", sc.Summary)
+	assert.Nil(s.T(), sc.User)
+	assert.Equal(s.T(), "dvn", sc.CreatedBy.Username)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestUpdateScheduleThatDoesNotExist() {
+	s.scheduleRequest("manager", http.MethodPut, "/schedules/7", `{"summary": "calibrate device", "cron": "@weekly"}`)
+	s.c.Params = append(s.c.Params, gin.Param{Key: "schedule-id", Value: "7"})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockScheduleSQL).WithArgs(7).WillReturnRows(sqlmock.NewRows(scheduleColumns))
+	s.sqlmock.ExpectRollback()
+
+	s.service.updateSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 404, s.w.Code)
+	assert.Contains(s.T(), s.w.Body.String(), "schedule_not_found")
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPauseSchedule() {
+	s.scheduleRequest("manager", http.MethodPost, "/schedules/7/pause", "")
+	s.c.Params = append(s.c.Params, gin.Param{Key: "schedule-id", Value: "7"})
+
+	summary, _ := s.tEncryptor.encrypt(context.Background(), []byte("calibrate device"))
+	nextRun := time.Date(2021, 10, 25, 9, 0, 0, 0, time.UTC)
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockScheduleSQL).WithArgs(7).WillReturnRows(sqlmock.NewRows(scheduleColumns).
+		AddRow(7, summary, "0 9 * * 1", "UTC", 1, "joel", 2, "dvn", "normal", nil, false, nextRun, nil))
+	s.sqlmock.ExpectExec(updateScheduleSQL).
+		WithArgs(summary, "0 9 * * 1", "UTC", 1, PriorityNormal, nil, true, nextRun, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.pauseSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.True(s.T(), s.scheduleResponse().Paused)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestResumeScheduleSkipsTheOccurrencesMissed() {
+	s.scheduleRequest("manager", http.MethodPost, "/schedules/7/resume", "")
+	s.c.Params = append(s.c.Params, gin.Param{Key: "schedule-id", Value: "7"})
+
+	summary, _ := s.tEncryptor.encrypt(context.Background(), []byte("calibrate device"))
+	lastRun := time.Date(2021, 10, 18, 9, 0, 0, 0, time.UTC)
+	nextRun := &capturedArg{}
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockScheduleSQL).WithArgs(7).WillReturnRows(sqlmock.NewRows(scheduleColumns).
+		AddRow(7, summary, "0 9 * * 1", "UTC", 0, "", 2, "dvn", "normal", nil, true, lastRun.Add(7*24*time.Hour), lastRun))
+	s.sqlmock.ExpectExec(updateScheduleSQL).
+		WithArgs(summary, "0 9 * * 1", "UTC", nil, PriorityNormal, nil, false, nextRun, 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
+
+	s.service.resumeSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.False(s.T(), s.scheduleResponse().Paused)
+	next := nextRun.value.(time.Time)
+	assert.True(s.T(), next.After(time.Now()))
+	assert.Equal(s.T(), time.Monday, next.Weekday())
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestSchedulesAsTechnician() {
+	s.scheduleRequest("technician", http.MethodPost, "/schedules", `{"summary": "calibrate device", "cron": "@weekly"}`)
+
+	s.service.createSchedule(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
@@ -0,0 +1,70 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+)
+
+const searchTasksSQL = "SELECT .+ WHERE t.deleted_at IS NULL%s AND t.id IN \\(SELECT st.task_id FROM task_search_tokens st WHERE st.token IN \\(\\?, \\?\\) GROUP BY st.task_id HAVING COUNT\\(\\*\\) = \\?\\) ORDER BY t.id;"
+
+func (s *TaskAPITestSuite) TestSearchTasksMatchesEveryWord() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/search?q=Replace+FILTER", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "replace the filter"})
+	s.sqlmock.ExpectQuery(fmt.Sprintf(searchTasksSQL, "")).
+		WithArgs(s.service.searchIndex.tokens("replace")[0], s.service.searchIndex.tokens("filter")[0], 2).
+		WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "dvn", nil, "normal", nil))
+
+	s.service.searchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var tasks []task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &tasks); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Len(s.T(), tasks, 1)
+	assert.Equal(s.T(), "replace the filter", tasks[0].Summary)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestSearchTasksAsTechnicianOnlyFindsTheirTasks() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/search?q=replace+filter", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(fmt.Sprintf(searchTasksSQL, " AND t.user_id = \\?")).
+		WithArgs(1, sqlmock.AnyArg(), sqlmock.AnyArg(), 2).
+		WillReturnRows(sqlmock.NewRows(taskColumns))
+
+	s.service.searchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	assert.JSONEq(s.T(), "[]", s.w.Body.String())
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestSearchTasksWithoutWords() {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, "/tasks/search?q=--", nil)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.service.searchTasks(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeValidationFailed, p.Code)
+	assert.Equal(s.T(), "q", p.Errors[0].Field)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
@@ -19,14 +19,21 @@ import (
 var validTaskId = gin.Param{Key: "task-id", Value: "1"}
 var validJsonTask, _ = json.Marshal(task{Summary: "test", User: &user.User{ID: 1, Username: "o"}})
 
-const getTaskSQL = "SELECT t.id, t.summary, t.completed_date, u.id as 'user.id', u.username as 'user.username' FROM tasks t INNER JOIN users u on t.user_id = u.id WHERE t.id = .+;"
-const getTasksSQL = "SELECT t.id, t.summary, t.completed_date, u.id as 'user.id', u.username as 'user.username' FROM tasks t INNER JOIN users u on t.user_id = u.id WHERE t.user_id = .+;"
-const getAllTasksSQL = "SELECT t.id, t.summary, t.completed_date, u.id as 'user.id', u.username as 'user.username' FROM tasks t INNER JOIN users u on t.user_id = u.id;"
-const deleteTaskSQL = "DELETE FROM tasks t WHERE t.id = .+;"
-const createTaskSQL = "INSERT INTO tasks (.+, .+) VALUES (.+, .+);"
-const updateTaskSQL = "UPDATE tasks SET user_id = COALESCE(.+, .+), summary = COALESCE(.+, .+), completed_date = .+ WHERE id = .+;"
-
-var taskColumns = []string{"id", "summary", "completed_date", "user.id", "user.username"}
+// validJsonTaskEdit is a PUT body, which has neither the assignee nor the status
+var validJsonTaskEdit = []byte(`{"summary": "test", "priority": "normal"}`)
+
+const getTaskSQL = "SELECT t.id, t.summary, t.status, t.completed_date, COALESCE\\(u.id, 0\\) as 'user.id', COALESCE\\(u.username, ''\\) as 'user.username', COALESCE\\(cb.id, 0\\) as 'created_by.id', COALESCE\\(cb.username, ''\\) as 'created_by.username', t.due_date, t.priority, t.estimated_minutes, t.version FROM tasks t LEFT JOIN users u on t.user_id = u.id LEFT JOIN users cb on t.created_by = cb.id WHERE t.id = .+;"
+const getTasksSQL = "SELECT t.id, t.summary, t.status, t.completed_date, COALESCE\\(u.id, 0\\) as 'user.id', COALESCE\\(u.username, ''\\) as 'user.username', COALESCE\\(cb.id, 0\\) as 'created_by.id', COALESCE\\(cb.username, ''\\) as 'created_by.username', t.due_date, t.priority, t.estimated_minutes, t.version FROM tasks t LEFT JOIN users u on t.user_id = u.id LEFT JOIN users cb on t.created_by = cb.id WHERE t.deleted_at IS NULL AND t.user_id = .+ ORDER BY t.id;"
+const getAllTasksSQL = "SELECT t.id, t.summary, t.status, t.completed_date, COALESCE\\(u.id, 0\\) as 'user.id', COALESCE\\(u.username, ''\\) as 'user.username', COALESCE\\(cb.id, 0\\) as 'created_by.id', COALESCE\\(cb.username, ''\\) as 'created_by.username', t.due_date, t.priority, t.estimated_minutes, t.version FROM tasks t LEFT JOIN users u on t.user_id = u.id LEFT JOIN users cb on t.created_by = cb.id WHERE t.deleted_at IS NULL ORDER BY t.id;"
+const deleteTaskSQL = "UPDATE tasks SET deleted_at = .+ WHERE id = .+;"
+const createTaskSQL = "INSERT INTO tasks (.+) VALUES (.+);"
+const updateTaskSQL = "UPDATE tasks SET .+, version = version \\+ 1 WHERE id = .+;"
+const lockTaskSQL = "SELECT .+ FROM tasks t .+ WHERE t.id = .+ FOR UPDATE OF t;"
+const insertHistorySQL = "INSERT INTO task_history (.+) VALUES (.+);"
+const insertSearchTokensSQL = "INSERT INTO task_search_tokens \\(task_id, token\\) VALUES .+;"
+const deleteSearchTokensSQL = "DELETE FROM task_search_tokens WHERE task_id = .+;"
+
+var taskColumns = []string{"id", "summary", "status", "completed_date", "user.id", "user.username", "created_by.id", "created_by.username", "due_date", "priority", "estimated_minutes"}
 
 type TaskAPITestSuite struct {
 	suite.Suite
@@ -54,12 +61,14 @@ func (s *TaskAPITestSuite) SetupSuite() {
 
 	c, _ := NewCrypto("6368616e676520746869732070617373", logger.Sugar())
 	s.tEncryptor = c
+	index, _ := newSearchIndex("7365617263682074686520696e646578")
 	s.service = &Service{
 		db:            sqlxDb,
 		logger:        logger.Sugar(),
 		userService:   userService,
 		taskPublisher: &LogPublisher{Logger: logger.Sugar()},
 		taskEncryptor: c,
+		searchIndex:   index,
 	}
 }
 
@@ -114,20 +123,32 @@ func (s *TaskAPITestSuite) TestFailToParseRequestBodyWhenParsingTask() {
 
 func (s *TaskAPITestSuite) TestDeleteRequestedTask() {
 	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Request, _ = http.NewRequest(http.MethodDelete, "/tasks/1", nil)
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
 
-	s.sqlmock.ExpectExec(deleteTaskSQL).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectExec(deleteTaskSQL).WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	// The task is only moved to the trash so its history records when
+	s.sqlmock.ExpectExec(insertHistorySQL).
+		WithArgs(1, 1, historyDeleted, "deletedAt", nil, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
 	s.service.deleteTask(s.c)
 	s.c.Writer.Flush()
 
 	assert.Equal(s.T(), 200, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
 }
 
 func (s *TaskAPITestSuite) TestDeleteRequestedTaskNotFound() {
 	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Request, _ = http.NewRequest(http.MethodDelete, "/tasks/1", nil)
 	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
 
-	s.sqlmock.ExpectExec(deleteTaskSQL).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WithArgs(1).WillReturnRows(sqlmock.NewRows(taskColumns))
+	s.sqlmock.ExpectRollback()
 
 	s.service.deleteTask(s.c)
 	s.c.Writer.Flush()
@@ -0,0 +1,139 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const transitionTaskSQL = "UPDATE tasks SET status = .+, completed_date = .+ WHERE id = .+ AND status = .+;"
+const insertTransitionSQL = "INSERT INTO task_transitions (.+) VALUES (.+);"
+const getTransitionsSQL = "SELECT tr.from_status, tr.to_status, tr.created_date, u.id as 'user.id', u.username as 'user.username' FROM task_transitions tr INNER JOIN users u on tr.user_id = u.id WHERE tr.task_id = .+ ORDER BY tr.id;"
+
+func (s *TaskAPITestSuite) transitionRequest(status Status) *http.Request {
+	body, _ := json.Marshal(transitionRequest{Status: status})
+	req, _ := http.NewRequest(http.MethodPost, "/tasks/1/transitions", bytes.NewReader(body))
+	return req
+}
+
+func (s *TaskAPITestSuite) TestTechnicianCompletesTaskAndManagersAreNotified() {
+	s.c.Request = s.transitionRequest(StatusDone)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Username: "joel", Role: &user.Role{Name: "technician"}})
+
+	et, _ := s.tEncryptor.encryptTask(s.c, &task{Summary: "test"})
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "in_progress", nil, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "in_progress", nil, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectExec(transitionTaskSQL).WithArgs("done", sqlmock.AnyArg(), 1, "in_progress").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertTransitionSQL).WithArgs(1, "in_progress", "done", 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	completedDate := time.Date(2011, 1, 1, 1, 1, 1, 0, time.UTC)
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, et.EncryptedSummary, "done", &completedDate, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectExec(insertHistorySQL).
+		WithArgs(1, 1, historyTransitioned, "status", []byte("in_progress"), []byte("done"), 1, 1, historyTransitioned, "completedDate", nil, []byte("2011-01-01T01:01:01Z")).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	s.sqlmock.ExpectCommit()
+
+	userRows := sqlmock.NewRows([]string{"id", "username"}).AddRow(2, "joao")
+	s.sqlmock.ExpectQuery("SELECT u.id, u.username FROM users u INNER JOIN roles r on u.role_id = r.id WHERE r.name = .+;").WillReturnRows(userRows)
+
+	s.service.transitionTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var taskReceived task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &taskReceived); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), StatusDone, taskReceived.Status)
+	assert.Equal(s.T(), &completedDate, taskReceived.CompletedDate)
+	// Notifications are sent asynchronously, wait for them so the expectations don't leak into the next test
+	assert.Eventually(s.T(), func() bool { return s.sqlmock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+}
+
+func (s *TaskAPITestSuite) TestTechnicianCantMakeManagerTransitions() {
+	s.c.Request = s.transitionRequest(StatusCancelled)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil))
+
+	s.service.transitionTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 409, s.w.Code)
+	var p problem.Problem
+	if err := json.Unmarshal(s.w.Body.Bytes(), &p); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), problem.CodeInvalidTransition, p.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestTransitionFailsWhenStatusChangedConcurrently() {
+	s.c.Request = s.transitionRequest(StatusCancelled)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "in_progress", nil, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectExec(transitionTaskSQL).WithArgs("cancelled", nil, 1, "todo").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectRollback()
+
+	s.service.transitionTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 409, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestTransitionOfTaskOfAnotherTechnician() {
+	s.c.Request = s.transitionRequest(StatusInProgress)
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "todo", nil, 2, "joel", 0, "", nil, "normal", nil))
+
+	s.service.transitionTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+}
+
+func (s *TaskAPITestSuite) TestTransitionToUnknownStatus() {
+	req, _ := http.NewRequest(http.MethodPost, "/tasks/1/transitions", bytes.NewReader([]byte(`{"status": "finished"}`)))
+	s.c.Request = req
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "manager"}})
+
+	s.service.transitionTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 400, s.w.Code)
+}
+
+func (s *TaskAPITestSuite) TestGetTransitions() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	createdDate := time.Date(2011, 1, 1, 1, 1, 1, 0, time.UTC)
+	s.sqlmock.ExpectQuery(getTaskSQL).WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(1, "1", "in_progress", nil, 1, "joel", 0, "", nil, "normal", nil))
+	s.sqlmock.ExpectQuery(getTransitionsSQL).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"from_status", "to_status", "created_date", "user.id", "user.username"}).AddRow("todo", "in_progress", createdDate, 1, "joel"))
+
+	s.service.getTransitions(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var transitions []transition
+	if err := json.Unmarshal(s.w.Body.Bytes(), &transitions); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), []transition{{From: StatusTodo, To: StatusInProgress, User: &user.User{ID: 1, Username: "joel"}, CreatedDate: &createdDate}}, transitions)
+}
@@ -0,0 +1,121 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"sword-challenge/internal/blob"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+const getDeletedTasksSQL = "SELECT .+, t.deleted_at FROM tasks t .+ WHERE t.deleted_at IS NOT NULL ORDER BY t.deleted_at DESC, t.id;"
+const getDeletedAtSQL = "SELECT t.deleted_at FROM tasks t WHERE t.id = .+ AND t.deleted_at IS NOT NULL FOR UPDATE;"
+const restoreTaskSQL = "UPDATE tasks SET deleted_at = NULL, version = version \\+ 1 WHERE id = .+;"
+const getPurgeableTasksSQL = "SELECT t.id FROM tasks t WHERE t.deleted_at < .+ ORDER BY t.id;"
+const lockPurgeableTaskSQL = "SELECT t.id FROM tasks t WHERE t.id = .+ AND t.deleted_at < .+ FOR UPDATE;"
+
+func (s *TaskAPITestSuite) TestGetTrash() {
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	deletedAt := time.Date(2021, 10, 23, 10, 0, 0, 0, time.UTC)
+	et, _ := s.tEncryptor.encryptTask(context.Background(), &task{Summary: "fix the pump"})
+	s.sqlmock.ExpectQuery(getDeletedTasksSQL).WillReturnRows(sqlmock.NewRows(append(taskColumns, "deleted_at")).
+		AddRow(1, et.EncryptedSummary, "todo", nil, 1, "joel", 2, "dvn", nil, "normal", nil, deletedAt))
+
+	s.service.getTrash(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var tasks []task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &tasks); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Len(s.T(), tasks, 1)
+	assert.Equal(s.T(), "fix the pump", tasks[0].Summary)
+	assert.Equal(s.T(), &deletedAt, tasks[0].DeletedAt)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestGetTrashAsTechnician() {
+	s.c.Set(util.UserContextKey, &user.User{ID: 1, Role: &user.Role{Name: "technician"}})
+
+	s.service.getTrash(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 403, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestRestoreTask() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	deletedAt := time.Date(2021, 10, 23, 10, 0, 0, 0, time.UTC)
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(getDeletedAtSQL).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(deletedAt))
+	s.sqlmock.ExpectExec(restoreTaskSQL).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec(insertHistorySQL).
+		WithArgs(1, 2, historyRestored, "deletedAt", []byte("2021-10-23T10:00:00Z"), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.sqlmock.ExpectCommit()
+	s.sqlmock.ExpectQuery(getTaskSQL).WithArgs(1).WillReturnRows(s.ownTaskRows())
+
+	s.service.restoreTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 200, s.w.Code)
+	var restored task
+	if err := json.Unmarshal(s.w.Body.Bytes(), &restored); err != nil {
+		s.T().Fatalf("Failed to parse response body: error: %v", err)
+	}
+	assert.Equal(s.T(), "fix the pump", restored.Summary)
+	assert.Nil(s.T(), restored.DeletedAt)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestRestoreTaskNotInTheTrash() {
+	s.c.Params = append(s.c.Params, validTaskId)
+	s.c.Set(util.UserContextKey, &user.User{ID: 2, Role: &user.Role{Name: "manager"}})
+
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(getDeletedAtSQL).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}))
+	s.sqlmock.ExpectRollback()
+
+	s.service.restoreTask(s.c)
+	s.c.Writer.Flush()
+
+	assert.Equal(s.T(), 404, s.w.Code)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
+
+func (s *TaskAPITestSuite) TestPurgeTrashDeletesTheAttachments() {
+	s.useBlobs()
+	s.storeAttachment(1, "joel")
+
+	s.sqlmock.ExpectQuery(getPurgeableTasksSQL).WithArgs(sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockPurgeableTaskSQL).WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.sqlmock.ExpectQuery("SELECT a.storage_key FROM task_attachments a WHERE a.task_id = .+;").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("tasks/1/pump"))
+	s.sqlmock.ExpectExec("DELETE FROM task_attachments WHERE task_id = .+;").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectExec("DELETE FROM task_comments WHERE task_id = .+;").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.sqlmock.ExpectExec("DELETE FROM task_transitions WHERE task_id = .+;").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	s.sqlmock.ExpectExec(deleteSearchTokensSQL).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+	s.sqlmock.ExpectExec("DELETE FROM tasks WHERE id = .+;").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.sqlmock.ExpectCommit()
+	// The second task was restored in the meantime
+	s.sqlmock.ExpectBegin()
+	s.sqlmock.ExpectQuery(lockPurgeableTaskSQL).WithArgs(2, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.sqlmock.ExpectRollback()
+
+	purged, err := s.service.PurgeTrash(context.Background(), 30*24*time.Hour)
+
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, purged)
+	_, err = s.service.Blobs.Get(context.Background(), "tasks/1/pump")
+	assert.Equal(s.T(), blob.ErrNotFound, err)
+	assert.Nil(s.T(), s.sqlmock.ExpectationsWereMet())
+}
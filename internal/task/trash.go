@@ -0,0 +1,138 @@
+package task
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/tracing"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
+	"time"
+)
+
+type encryptedDeletedTask struct {
+	encryptedTask
+	DeletedAt time.Time `db:"deleted_at"`
+}
+
+func (s *Service) getTrash(c *gin.Context) {
+	logger := s.requestLogger(c)
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can see the trash")
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	deletedTasks, err := s.getDeletedTasksFromStore(ctx)
+	if err != nil {
+		logger.Warnw("Failed to get deleted tasks from storage", "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	tasks := make([]task, len(deletedTasks))
+	for i, t := range deletedTasks {
+		t := t
+		decryptedTask, err := s.taskEncryptor.decryptTask(ctx, &t.encryptedTask, currentUser.ID)
+		if err != nil {
+			logger.Warnw("Failed to decrypt task")
+			problem.Internal(c)
+			return
+		}
+		decryptedTask.DeletedAt = &t.DeletedAt
+		tasks[i] = *decryptedTask
+	}
+
+	c.JSON(http.StatusOK, tasks)
+}
+
+func (s *Service) restoreTask(c *gin.Context) {
+	logger := s.requestLogger(c)
+	id, err := s.mustGetTaskID(c)
+	if err != nil {
+		return
+	}
+
+	authUser, _ := c.Get(util.UserContextKey)
+	currentUser := authUser.(*user.User)
+	if currentUser.Role.Name != util.AdminRole {
+		problem.Forbidden(c, "Only managers can restore tasks")
+		return
+	}
+
+	ctx := util.RequestContext(c)
+	restoredTask, err := s.restoreTaskFromStore(ctx, id, currentUser.ID)
+	if err == sql.ErrNoRows {
+		logger.Infow("Failed to find task in the trash", "taskId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d is not in the trash", id))
+		return
+	} else if err != nil {
+		logger.Warnw("Failed to restore task in storage", "taskId", id, "error", err)
+		problem.Internal(c)
+		return
+	}
+
+	decryptedTask, err := s.taskEncryptor.decryptTask(ctx, restoredTask, currentUser.ID)
+	if err != nil {
+		logger.Warnw("Failed to decrypt task")
+		problem.Internal(c)
+		return
+	}
+
+	c.Header("ETag", etag(restoredTask.Version))
+	c.JSON(http.StatusOK, decryptedTask)
+}
+
+// PurgeTrash deletes for good the tasks that have been in the trash for longer than the retention, along with their
+// comments and attachments. Replicas purging at the same time skip the tasks the others already purged
+func (s *Service) PurgeTrash(ctx context.Context, retention time.Duration) (purged int, err error) {
+	ctx, span := tracing.Start(ctx, "PurgeTrash")
+	defer func() { tracing.End(span, err) }()
+
+	deletedBefore := time.Now().UTC().Add(-retention)
+	ids, err := s.getPurgeableTasksFromStore(ctx, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		ok, attachmentKeys, err := s.purgeTaskFromStore(ctx, id, deletedBefore)
+		if err != nil {
+			return purged, err
+		} else if !ok {
+			continue
+		}
+		// The rows are gone so a file that fails to be deleted is only an orphan
+		for _, key := range attachmentKeys {
+			if err := s.Blobs.Delete(ctx, key); err != nil {
+				s.logger.Warnw("Failed to delete attachment of purged task from the blob storage", "taskId", id, "key", key, "error", err)
+			}
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunTrashPurger purges the trash every interval until the context is cancelled
+func (s *Service) RunTrashPurger(ctx context.Context, interval time.Duration, retention time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			purged, err := s.PurgeTrash(ctx, retention)
+			if err != nil {
+				s.logger.Warnw("Failed to purge the trash", "error", err)
+			} else if purged > 0 {
+				s.logger.Infow("Purged tasks from the trash", "tasks", purged)
+			}
+		}
+	}
+}
@@ -1,27 +1,62 @@
 package task
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"net/http"
 	"strconv"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/user"
+	"sword-challenge/internal/util"
 )
 
+// requestLogger is the logger of the request being handled, it carries the request ID and the authenticated user
+func (s *Service) requestLogger(c *gin.Context) *zap.SugaredLogger {
+	return logging.FromContext(util.RequestContext(c), s.logger)
+}
+
 func (s *Service) mustGetTaskID(c *gin.Context) (int, error) {
 	id, err := strconv.Atoi(c.Param("task-id"))
 	if err != nil || id == 0 {
-		s.logger.Infow("Failed to parse task ID", "error", err)
-		c.Status(http.StatusBadRequest)
+		s.requestLogger(c).Infow("Failed to parse task ID", "error", err)
+		problem.Abort(c, http.StatusBadRequest, problem.CodeInvalidTaskID, "The task ID must be a positive number")
 		return 0, err
 	}
 	return id, nil
 }
 
+// mustGetOwnTask fetches the task and checks it belongs to the user or that the user is a manager, otherwise the request
+// is aborted with the matching problem
+func (s *Service) mustGetOwnTask(c *gin.Context, id int, currentUser *user.User) (*encryptedTask, bool) {
+	logger := s.requestLogger(c)
+	t, err := s.getTaskFromStore(util.RequestContext(c), id)
+	if err == sql.ErrNoRows {
+		logger.Infow("Failed to find task", "taskId", id)
+		problem.Abort(c, http.StatusNotFound, problem.CodeTaskNotFound, fmt.Sprintf("Task %d does not exist", id))
+		return nil, false
+	} else if err != nil {
+		logger.Infow("Failed to get task", "taskId", id, "error", err)
+		problem.Internal(c)
+		return nil, false
+	}
+
+	if currentUser.Role.Name != util.AdminRole && t.User.ID != currentUser.ID {
+		problem.Forbidden(c, "Only managers can access tasks of other users")
+		return nil, false
+	}
+	return t, true
+}
+
 type LogPublisher struct {
 	Logger *zap.SugaredLogger
 }
 
-func (r *LogPublisher) PublishTask(t Notification) error {
-	r.Logger.Infow("Task published by LogPublisher", "taskId", t.ID)
+func (r *LogPublisher) Publish(_ context.Context, msg eventbus.Message) error {
+	r.Logger.Infow("Message published by LogPublisher", "topic", msg.Topic, "type", msg.Type)
 	return nil
 }
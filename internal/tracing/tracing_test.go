@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+	return recorder
+}
+
+func TestMiddlewareContinuesTheCallerTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware)
+	var handlerSpan trace.SpanContext
+	router.GET("/tasks/:task-id", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusNotFound)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1", nil)
+	req.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "/tasks/:task-id", spans[0].Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	assert.Equal(t, spans[0].SpanContext(), handlerSpan)
+}
+
+func TestInjectAndExtractRoundTrip(t *testing.T) {
+	recordSpans(t)
+	ctx, span := Start(context.Background(), "publish")
+	defer span.End()
+
+	headers := Inject(ctx, map[string]string{"retries": "1"})
+	assert.Equal(t, "1", headers["retries"])
+	assert.Contains(t, headers, "traceparent")
+
+	extracted := trace.SpanContextFromContext(Extract(context.Background(), headers))
+	assert.Equal(t, span.SpanContext().TraceID(), extracted.TraceID())
+	assert.True(t, extracted.IsRemote())
+}
+
+func TestDetachKeepsTheSpanButNotTheCancellation(t *testing.T) {
+	recordSpans(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := Start(ctx, "updateTask")
+	defer span.End()
+	cancel()
+
+	detached := Detach(ctx)
+	assert.Nil(t, detached.Err())
+	assert.Equal(t, span.SpanContext(), trace.SpanContextFromContext(detached))
+}
@@ -1,37 +1,61 @@
 package user
 
 import (
+	"context"
+	"database/sql"
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"sword-challenge/internal/metrics"
+	"time"
 )
 
-func (s *Service) authenticateUser(id int) (string, error) {
+func (s *Service) authenticateUser(ctx context.Context, id int) (string, error) {
+	defer metrics.ObserveQuery("authenticateUser")()
 	token, err := uuid.NewUUID()
 	if err != nil {
 		return "", err
 	}
-	_, err = s.DB.Exec(
+	_, err = s.DB.ExecContext(ctx,
 		"INSERT INTO tokens (uuid, user_id, created_date) VALUES (?, (SELECT id FROM users WHERE id = ?), CURRENT_TIME);", token, id)
 	if err != nil {
 		return "", err
 	}
+	metrics.TokenIssued()
 	return token.String(), nil
 }
 
-func (s *Service) GetUserByToken(token string) (*User, error) {
+// GetUserByToken returns the user the token was issued to, tokens older than the TokenTTL are treated as missing even
+// before they're purged
+func (s *Service) GetUserByToken(ctx context.Context, token string) (*User, error) {
+	defer metrics.ObserveQuery("GetUserByToken")()
 	user := &User{}
-	err := s.DB.Get(
+	err := s.DB.GetContext(ctx,
 		user,
-		"SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u INNER JOIN tokens t on u.id = t.user_id LEFT JOIN roles r on u.role_id = r.id WHERE t.uuid = ?;",
-		token)
+		"SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u INNER JOIN tokens t on u.id = t.user_id LEFT JOIN roles r on u.role_id = r.id WHERE t.uuid = ? AND t.created_date > ?;",
+		token, time.Now().Add(-s.TokenTTL))
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (s *Service) GetUsersByRole(role string) ([]User, error) {
+func (s *Service) GetUserByID(ctx context.Context, id int) (*User, error) {
+	defer metrics.ObserveQuery("GetUserByID")()
+	user := &User{}
+	err := s.DB.GetContext(ctx,
+		user,
+		"SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u LEFT JOIN roles r on u.role_id = r.id WHERE u.id = ?;",
+		id)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *Service) GetUsersByRole(ctx context.Context, role string) ([]User, error) {
+	defer metrics.ObserveQuery("GetUsersByRole")()
 	var users []User
-	err := s.DB.Select(
+	err := s.DB.SelectContext(ctx,
 		&users,
 		"SELECT u.id, u.username FROM users u INNER JOIN roles r on u.role_id = r.id WHERE r.name = ?;",
 		role)
@@ -40,3 +64,70 @@ func (s *Service) GetUsersByRole(role string) ([]User, error) {
 	}
 	return users, nil
 }
+
+// GetUsersByUsernames returns the users that exist among the usernames, in no particular order
+func (s *Service) GetUsersByUsernames(ctx context.Context, usernames []string) ([]User, error) {
+	defer metrics.ObserveQuery("GetUsersByUsernames")()
+	users := []User{}
+	if len(usernames) == 0 {
+		return users, nil
+	}
+	query, args, err := sqlx.In("SELECT u.id, u.username, r.name as 'role.name', r.id as 'role.id' FROM users u LEFT JOIN roles r on u.role_id = r.id WHERE u.username IN (?);", usernames)
+	if err != nil {
+		return nil, err
+	}
+	err = s.DB.SelectContext(ctx, &users, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CreateUser adds a user with the given role, usernames are unique
+func (s *Service) CreateUser(username string, role string) (*User, error) {
+	defer metrics.ObserveQuery("CreateUser")()
+	r := &Role{}
+	err := s.DB.Get(r, "SELECT r.id, r.name FROM roles r WHERE r.name = ?;", role)
+	if err == sql.ErrNoRows {
+		return nil, ErrRoleNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	result, err := s.DB.Exec(
+		"INSERT INTO users (username, role_id) SELECT ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM users WHERE username = ?);",
+		username, r.ID, username)
+	if err != nil {
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, ErrUsernameTaken
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &User{ID: int(id), Username: username, Role: r}, nil
+}
+
+func (s *Service) EnsureRole(name string) error {
+	defer metrics.ObserveQuery("EnsureRole")()
+	_, err := s.DB.Exec("INSERT INTO roles (name) VALUES (?) ON DUPLICATE KEY UPDATE name=name;", name)
+	return err
+}
+
+// PurgeTokens deletes every login token created before the date passed and returns how many were deleted
+func (s *Service) PurgeTokens(createdBefore time.Time) (int, error) {
+	defer metrics.ObserveQuery("PurgeTokens")()
+	result, err := s.DB.Exec("DELETE FROM tokens WHERE created_date < ?;", createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 	"net/http"
+	"sword-challenge/internal/logging"
+	"sword-challenge/internal/problem"
 	"sword-challenge/internal/util"
 	"time"
 )
@@ -22,12 +24,13 @@ type User struct {
 }
 
 type Service struct {
-	DB     *sqlx.DB
-	Logger *zap.SugaredLogger
+	DB       *sqlx.DB
+	Logger   *zap.SugaredLogger
+	TokenTTL time.Duration
 }
 
 func NewService(db *sqlx.DB, logger *zap.SugaredLogger) *Service {
-	service := &Service{DB: db, Logger: logger}
+	service := &Service{DB: db, Logger: logger, TokenTTL: time.Hour}
 	return service
 }
 
@@ -37,21 +40,23 @@ func (s *Service) SetupRoutes(publicAPI *gin.RouterGroup) {
 }
 
 func (s *Service) loginUser(c *gin.Context) {
+	logger := logging.FromContext(util.RequestContext(c), s.Logger)
 	user := &User{}
 
-	if err := c.BindJSON(user); err != nil {
-		s.Logger.Infow("Failed to parse user request body", "error", err)
+	if err := c.ShouldBindJSON(user); err != nil {
+		logger.Infow("Failed to parse user request body", "error", err)
+		problem.Binding(c, err)
 		return
 	}
 
-	token, err := s.authenticateUser(user.ID)
+	token, err := s.authenticateUser(util.RequestContext(c), user.ID)
 	// Here we would handle the error where the user doesn't exist differently, but since this is not a required endpoint for the API...
 	if err != nil || token == "" {
-		s.Logger.Warnw("Failed to add user to storage", "error", err)
-		c.Status(http.StatusInternalServerError)
+		logger.Warnw("Failed to add user to storage", "error", err)
+		problem.Internal(c)
 		return
 	}
 
-	c.SetCookie(util.AuthCookie, token, int(time.Hour), "/", "localhost", true, true)
+	c.SetCookie(util.AuthCookie, token, int(s.TokenTTL.Seconds()), "/", "localhost", true, true)
 	c.Status(http.StatusOK)
 }
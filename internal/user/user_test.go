@@ -2,6 +2,9 @@ package user
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/gin-gonic/gin"
@@ -11,6 +14,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func TestUserHandlers(t *testing.T) {
@@ -48,3 +52,88 @@ func shouldLoginUser(service *Service, mock sqlmock.Sqlmock) func(t *testing.T)
 		assert.Equal(t, 200, w.Code)
 	}
 }
+
+func TestCreateUser(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	t.Cleanup(func() {
+		db.Close()
+	})
+	service := NewService(sqlx.NewDb(db, "mysql"), zap.NewNop().Sugar())
+
+	t.Run("shouldCreateUserWithExistingRole", func(t *testing.T) {
+		mock.ExpectQuery("SELECT r.id, r.name FROM roles r WHERE r.name = .+;").WithArgs("manager").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("2", "manager"))
+		mock.ExpectExec("INSERT INTO users").WithArgs("ana", "2", "ana").WillReturnResult(sqlmock.NewResult(3, 1))
+
+		u, err := service.CreateUser("ana", "manager")
+
+		assert.Nil(t, err)
+		assert.Equal(t, 3, u.ID)
+		assert.Equal(t, "manager", u.Role.Name)
+	})
+
+	t.Run("shouldFailWhenRoleDoesntExist", func(t *testing.T) {
+		mock.ExpectQuery("SELECT r.id, r.name FROM roles r WHERE r.name = .+;").WithArgs("admin").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}))
+
+		_, err := service.CreateUser("ana", "admin")
+
+		assert.Equal(t, ErrRoleNotFound, err)
+	})
+
+	t.Run("shouldFailWhenUsernameIsTaken", func(t *testing.T) {
+		mock.ExpectQuery("SELECT r.id, r.name FROM roles r WHERE r.name = .+;").WithArgs("manager").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("2", "manager"))
+		mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		_, err := service.CreateUser("dvn", "manager")
+
+		assert.Equal(t, ErrUsernameTaken, err)
+	})
+}
+
+func TestPurgeTokens(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	t.Cleanup(func() {
+		db.Close()
+	})
+	service := NewService(sqlx.NewDb(db, "mysql"), zap.NewNop().Sugar())
+
+	mock.ExpectExec("DELETE FROM tokens WHERE created_date < .+;").WillReturnResult(sqlmock.NewResult(0, 7))
+
+	purged, err := service.PurgeTokens(time.Now())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 7, purged)
+}
+
+func TestGetUserByTokenIgnoresExpiredTokens(t *testing.T) {
+	db, mock, _ := sqlmock.New()
+	t.Cleanup(func() {
+		db.Close()
+	})
+	service := NewService(sqlx.NewDb(db, "mysql"), zap.NewNop().Sugar())
+	service.TokenTTL = 2 * time.Hour
+
+	createdAfter := &createdAfterArg{}
+	mock.ExpectQuery("SELECT u.id, .+ FROM users u INNER JOIN tokens t .+ WHERE t.uuid = .+ AND t.created_date > .+;").
+		WithArgs("123", createdAfter).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role.name", "role.id"}))
+
+	_, err := service.GetUserByToken(context.Background(), "123")
+
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.WithinDuration(t, time.Now().Add(-2*time.Hour), createdAfter.value, time.Minute)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+// createdAfterArg matches the date tokens must be created after and keeps it
+type createdAfterArg struct {
+	value time.Time
+}
+
+func (c *createdAfterArg) Match(v driver.Value) bool {
+	value, ok := v.(time.Time)
+	c.value = value
+	return ok
+}
@@ -7,6 +7,8 @@ import (
 )
 
 var ErrForbidden = fmt.Errorf("IDs do not match")
+var ErrRoleNotFound = fmt.Errorf("role does not exist")
+var ErrUsernameTaken = fmt.Errorf("username is already taken")
 
 // CheckIdsMatchIfPresentOrIsManager checks whether:
 //	* The ID passed is not nil and it matches the user ID
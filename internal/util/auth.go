@@ -5,3 +5,4 @@ const AuthHeader = "x-auth-token"
 const AuthCookie = "auth-token"
 
 const AdminRole = "manager"
+const TechnicianRole = "technician"
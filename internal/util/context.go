@@ -0,0 +1,14 @@
+package util
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+)
+
+// RequestContext is the context of the request being handled, gin contexts built in tests may not have a request
+func RequestContext(c *gin.Context) context.Context {
+	if c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
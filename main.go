@@ -2,104 +2,203 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/XSAM/otelsql"
 	"github.com/gin-gonic/gin"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
 	amqp "github.com/rabbitmq/amqp091-go"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"log"
 	"os"
+	"strings"
 	"sword-challenge/internal"
+	serverAmqp "sword-challenge/internal/amqp"
+	"sword-challenge/internal/config"
+	"sword-challenge/internal/eventbus"
+	"sword-challenge/internal/problem"
+	"sword-challenge/internal/tracing"
+	"time"
 )
 
 func main() {
-	db, err := setupDatabase()
-	if err != nil {
-		log.Fatalf("Failed to setup database. err: %v", err)
+	// Running without a command serves the API, that's what the Docker image does
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
-	defer func(db *sqlx.DB) {
-		if err := db.Close(); err != nil {
-			log.Printf("Failed to close DB connection. error: %v", err)
-		}
-	}(db)
 
-	conn, ch := setupRabbit()
-	defer func(conn *amqp.Connection) {
-		if err := conn.Close(); err != nil {
-			log.Printf("Failed to close RabbitMQ connection. error: %v", err)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(args); err != nil {
+				log.Fatalf("Command %s failed. error: %v", name, err)
+			}
+			return
 		}
-	}(conn)
-	defer ch.Close()
+	}
 
-	ginEngine := setupGin()
+	printUsage()
+	os.Exit(2)
+}
+
+// app holds the dependencies every command shares, close releases them in the reverse order they were acquired
+type app struct {
+	cfg     *config.Config
+	logger  *zap.SugaredLogger
+	db      *sqlx.DB
+	server  *internal.SwordChallengeServer
+	closers []func()
+}
 
-	logger := setupLogger()
-	defer func(Logger *zap.SugaredLogger) {
-		if err := Logger.Sync(); err != nil {
+func loadConfig(fs *flag.FlagSet, args []string) (*config.Config, error) {
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// newApp wires the server the same way for every command. Only serve needs the broker, the other commands use the
+// in-memory bus so they can run while RabbitMQ is down
+func newApp(cfg *config.Config, withBroker bool) (*app, error) {
+	var err error
+	a := &app{cfg: cfg, logger: setupLogger(cfg.Log)}
+	a.closers = append(a.closers, func() {
+		if err := a.logger.Sync(); err != nil {
 			log.Printf("Failed to sync zap logger. error: %v", err)
 		}
-	}(logger)
+	})
 
-	// TODO QueueName should be configurable
-	s, err := internal.NewServer(db, logger, ginEngine, ch, os.Getenv("AES_KEY"), "tasks")
+	// Tracing goes first so the database driver and the event bus pick up the provider
+	shutdownTracing, err := tracing.Setup(cfg.Tracing)
 	if err != nil {
-		log.Fatalf("Failed to create server. error: %v", err)
+		a.close()
+		return nil, fmt.Errorf("failed to setup tracing: %w", err)
 	}
+	a.closers = append(a.closers, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Failed to flush spans. error: %v", err)
+		}
+	})
 
-	s.SetupRoutes()
-	// TODO This should be configurable
-	err = s.RunMigrations()
+	a.db, err = setupDatabase(cfg.Database)
 	if err != nil {
-		log.Fatalf("Failed to run migration on the database. err: %v", err)
+		a.close()
+		return nil, fmt.Errorf("failed to setup database: %w", err)
 	}
-	// TODO This should be configurable
-	err = s.StartWithGracefulShutdown(context.Background(), 8080)
+	a.closers = append(a.closers, func() {
+		if err := a.db.Close(); err != nil {
+			log.Printf("Failed to close DB connection. error: %v", err)
+		}
+	})
+
+	consumerConfig := eventbus.ConsumerConfig{Prefetch: cfg.Messaging.Prefetch, Workers: cfg.Messaging.Workers, HandlerTimeout: cfg.Messaging.HandlerTimeout}
+	var bus eventbus.EventBus
+	if withBroker && cfg.Messaging.Driver == config.DriverRabbitMQ {
+		conn, ch, err := setupRabbit(cfg.Messaging.URL)
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		a.closers = append(a.closers, func() {
+			if err := conn.Close(); err != nil {
+				log.Printf("Failed to close RabbitMQ connection. error: %v", err)
+			}
+		}, func() { _ = ch.Close() })
+
+		bus, err = serverAmqp.NewBus(ch, a.logger, consumerConfig, cfg.Messaging.Exchange, cfg.Messaging.Queue)
+		if err != nil {
+			a.close()
+			return nil, fmt.Errorf("failed to create RabbitMQ event bus: %w", err)
+		}
+	} else {
+		if withBroker {
+			a.logger.Warnw("Using the in-memory event bus, notifications will be lost on restart")
+		}
+		bus = eventbus.NewMemoryBus(a.logger, consumerConfig, cfg.Messaging.BufferSize)
+	}
+
+	a.server, err = internal.NewServer(a.db, a.logger, setupGin(cfg.Server), bus, cfg)
 	if err != nil {
-		log.Fatalf("Failed to start server. error: %v", err)
+		a.close()
+		return nil, fmt.Errorf("failed to create server: %w", err)
 	}
+	return a, nil
 }
 
-func setupGin() *gin.Engine {
-	ginEngine := gin.New()
-	ginEngine.Use(gin.Recovery())
-	ginMode := os.Getenv("GIN_MODE")
-	if ginMode != "" {
-		gin.SetMode(ginMode)
+func (a *app) close() {
+	for i := len(a.closers) - 1; i >= 0; i-- {
+		a.closers[i]()
 	}
+}
+
+func setupGin(cfg config.ServerConfig) *gin.Engine {
+	if cfg.GinMode != "" {
+		gin.SetMode(cfg.GinMode)
+	}
+	ginEngine := gin.New()
+	// Panics are still logged by gin, the client gets the same problem as any other internal error
+	ginEngine.Use(gin.CustomRecovery(func(c *gin.Context, _ interface{}) { problem.Internal(c) }))
 	return ginEngine
 }
 
-func setupRabbit() (*amqp.Connection, *amqp.Channel) {
-	conn, err := amqp.Dial(os.Getenv("RABBIT_URL"))
+func setupRabbit(url string) (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(url)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ. error: %v", err)
+		return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
 	c, err := conn.Channel()
 	if err != nil {
-		log.Fatalf("Failed to open RabbitMQ channel. error: %v", err)
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
 	}
 
-	return conn, c
+	return conn, c, nil
 }
 
-func setupDatabase() (*sqlx.DB, error) {
+func setupDatabase(cfg config.DatabaseConfig) (*sqlx.DB, error) {
 	// multiStatements=true is bad (increases SQLi possibilities) but I need it here because we're migrating the database to the latest version from the server itself (server.go:36)
 	// This is good for development but would be removed if this ever went to prod and the MySQL DB wasn't always running in Docker container
-	db, err := sqlx.Open("mysql", os.Getenv("DB_USER")+":"+os.Getenv("DB_PASSWORD")+"@tcp("+os.Getenv("DB_HOST")+")/"+os.Getenv("DB_NAME")+"?multiStatements=true&parseTime=true")
+	// Every query gets a span through the wrapped driver, sqlx is told it's still MySQL so it binds parameters the same way
+	driverName, err := otelsql.Register("mysql", semconv.DBSystemMySQL.Value.AsString())
 	if err != nil {
-		log.Fatalf("Failed to connect to the database. error: %v", err)
+		return nil, err
 	}
+	sqlDB, err := sql.Open(driverName, cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sqlDB, "mysql")
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	if err = db.Ping(); err != nil {
-		log.Fatalf("Failed to validate DB connection. error: %v", err)
+		_ = db.Close()
+		return nil, err
 	}
 	return db, nil
 }
 
-func setupLogger() *zap.SugaredLogger {
+func setupLogger(cfg config.LogConfig) *zap.SugaredLogger {
 	loggerConfig := zap.NewDevelopmentConfig()
+	if cfg.Format == config.LogFormatJSON {
+		// Same encoder as the production preset so log collectors get ISO8601 timestamps and one JSON object per line
+		loggerConfig = zap.NewProductionConfig()
+		loggerConfig.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	}
 	loggerConfig.DisableStacktrace = true
+	var level zapcore.Level
+	// The level was already validated when loading the config
+	_ = level.UnmarshalText([]byte(cfg.Level))
+	loggerConfig.Level = zap.NewAtomicLevelAt(level)
 	simpleLogger, _ := loggerConfig.Build()
 	logger := simpleLogger.Sugar()
 	return logger